@@ -0,0 +1,46 @@
+package doctor
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+	doctorservice "github.com/s-samadi/ghas-lab-builder/internal/services"
+	"github.com/spf13/cobra"
+)
+
+var (
+	templateReposFile string
+)
+
+func init() {
+	DoctorCmd.Flags().StringVar(&templateReposFile, "template-repos", "", "Path to template repositories file (JSON) to check (optional)")
+}
+
+var DoctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check credentials, permissions, templates and rate limit before running a lab",
+	Long: `The 'doctor' command runs preflight checks against GitHub: PAT scopes or GitHub App permissions,
+enterprise access, whether the app can be installed on organizations, that each template repository exists
+and is a template, and the remaining rate limit. It exits non-zero if any check fails.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		// Get logger from context (initialized in root command)
+		logger, ok := ctx.Value(config.LoggerKey).(*slog.Logger)
+		if !ok || logger == nil {
+			// Fallback to default logger if not found
+			logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+		}
+
+		report := doctorservice.RunDoctor(ctx, logger, templateReposFile)
+		report.Print(cmd.OutOrStdout())
+
+		if failures := report.Failures(); failures > 0 {
+			return fmt.Errorf("doctor found %d problem(s)", failures)
+		}
+		return nil
+	},
+}
@@ -5,12 +5,23 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	"github.com/s-samadi/ghas-lab-builder/cmd/doctor"
 	"github.com/s-samadi/ghas-lab-builder/cmd/lab"
 	"github.com/s-samadi/ghas-lab-builder/cmd/orgs"
 	"github.com/s-samadi/ghas-lab-builder/cmd/repo"
+	"github.com/s-samadi/ghas-lab-builder/cmd/templates"
+	"github.com/s-samadi/ghas-lab-builder/internal/auth"
 	"github.com/s-samadi/ghas-lab-builder/internal/config"
+	"github.com/s-samadi/ghas-lab-builder/internal/progress"
+	"github.com/s-samadi/ghas-lab-builder/internal/telemetry"
+	"github.com/s-samadi/ghas-lab-builder/internal/transport"
 	"github.com/s-samadi/ghas-lab-builder/internal/util"
 	"github.com/spf13/cobra"
 )
@@ -18,27 +29,66 @@ import (
 var (
 	appId          string
 	privateKey     string
+	privateKeyFile string
 	token          string
 	baseURL        string
 	enterpriseSlug string
+	noProgress     bool
+	logLevel       string
+	logFormat      string
+	logDir         string
+	noLogFile      bool
+	logHTTPBodies  bool
+	logBodyLimit   int64
+	traceEnabled   bool
+	otelEndpoint   string
+	tokenCache     bool
+	tokenCacheFile string
+	installationID int64
+	appsFile       string
+	hostName       string
+	hostType       string
+	ghesVersion    string
+	caFile         string
+	proxyURL       string
+	clientCert     string
+	clientKey      string
+	connectTimeout time.Duration
+	respTimeout    time.Duration
 )
 
+// shutdownTracing flushes exported spans; it runs after the command finishes, even on error
+var shutdownTracing = func(context.Context) error { return nil }
+
+// revokeTokens revokes the installation tokens minted during the run. It runs in PersistentPostRunE
+// and again after the command returns, so tokens are also revoked on errors and interrupts.
+var revokeTokens = func(context.Context) {}
+
+// revokeTimeout bounds token revocation, which runs even after the command's context is cancelled
+const revokeTimeout = 30 * time.Second
+
 var rootCmd = &cobra.Command{
 	Use:   "ghas-lab-builder",
 	Short: "Builds GitHub Advanced Security Lab environments(orgs, repos, users)",
 	Long: `ghas-lab-builder is a CLI tool that helps you set up GitHub Advanced Security Lab environments by 
           automating the creation of organizations, repositories, and addings  users required for hands-on labs.`,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Fill in credentials from --private-key-file, stdin and environment variables
+		if err := resolveCredentials(cmd); err != nil {
+			return err
+		}
+
 		// Validate that either token OR (app-id + private-key) is provided, but not both
 		hasToken := token != ""
 		hasAppCreds := appId != "" || privateKey != ""
 
 		if !hasToken && !hasAppCreds {
-			return fmt.Errorf("authentication required: provide either --token OR both --app-id and --private-key")
+			return fmt.Errorf("authentication required: provide either --token OR both --app-id and --private-key-file (or set %s, or %s and %s)",
+				auth.EnvToken, auth.EnvAppID, auth.EnvPrivateKey)
 		}
 
 		if hasToken && hasAppCreds {
-			return fmt.Errorf("conflicting authentication methods: provide either --token OR (--app-id and --private-key), not both")
+			return fmt.Errorf("conflicting authentication methods: provide either --token OR (--app-id and --private-key-file), not both")
 		}
 
 		// If using app credentials, both app-id and private-key must be provided
@@ -47,22 +97,85 @@ var rootCmd = &cobra.Command{
 				return fmt.Errorf("--app-id is required when using GitHub App authentication")
 			}
 			if privateKey == "" {
-				return fmt.Errorf("--private-key is required when using GitHub App authentication")
+				return fmt.Errorf("--private-key-file is required when using GitHub App authentication")
+			}
+		}
+
+		// Derive the REST and GraphQL roots from --host, or from --base-url for backward compatibility
+		host, err := resolveHost()
+		if err != nil {
+			return err
+		}
+		baseURL = host.RESTURL()
+
+		// Every GitHub call, including token minting, shares one transport with the TLS and proxy settings
+		httpTransport, err := transport.New(transport.Options{
+			CAFile:          caFile,
+			ProxyURL:        proxyURL,
+			ClientCertFile:  clientCert,
+			ClientKeyFile:   clientKey,
+			ConnectTimeout:  connectTimeout,
+			ResponseTimeout: respTimeout,
+		})
+		if err != nil {
+			return err
+		}
+
+		if appsFile != "" && !hasAppCreds {
+			return fmt.Errorf("--apps-file adds apps to the one given with --app-id and --private-key-file, which are required")
+		}
+
+		// Validate the app IDs and private keys before any provisioning begins
+		var apps []pooledApp
+		if hasAppCreds {
+			ts, err := auth.NewTokenService(appId, privateKey, baseURL)
+			if err != nil {
+				return fmt.Errorf("invalid GitHub App credentials: %w", err)
+			}
+			ts.SetTransport(httpTransport)
+			apps = append(apps, pooledApp{service: ts, installationID: installationID})
+
+			if appsFile != "" {
+				extra, err := auth.LoadAppsFile(appsFile)
+				if err != nil {
+					return err
+				}
+				for _, app := range extra {
+					ts, err := auth.NewTokenService(app.AppID, app.PrivateKey, baseURL)
+					if err != nil {
+						return fmt.Errorf("invalid GitHub App credentials for app %q in %s: %w", app.AppID, appsFile, err)
+					}
+					ts.SetTransport(httpTransport)
+					apps = append(apps, pooledApp{service: ts, installationID: app.InstallationID})
+				}
 			}
 		}
 
-		// Set default base URL if not provided
-		if baseURL == "" {
-			baseURL = config.DefaultBaseURL
+		level, err := util.ParseLogLevel(logLevel)
+		if err != nil {
+			return err
 		}
 
-		// Generate log file path automatically
-		logFilePath := util.GenerateLogFileName("ghas-lab-builder")
+		if logBodyLimit < 0 {
+			return fmt.Errorf("--log-body-limit must not be negative")
+		}
+
+		// Generate log file path automatically unless file logging is disabled
+		logFilePath := ""
+		if !noLogFile {
+			logFilePath = util.GenerateLogFileName(logDir, "ghas-lab-builder", logFormat)
+		}
+
+		// Show the live progress view instead of log lines when a long-running command runs in a terminal.
+		// Without a log file there would be nowhere else for the logs to go, so keep them on stdout.
+		showProgress := !noProgress && logFilePath != "" && cmd.Annotations[config.ProgressAnnotation] == "true" && util.IsTerminal(os.Stdout)
 
 		// Initialize logger with automatic log file
 		loggerConfig := util.LoggerConfig{
-			LogFilePath: logFilePath,
-			LogLevel:    slog.LevelInfo,
+			LogFilePath:   logFilePath,
+			LogLevel:      level,
+			LogFormat:     logFormat,
+			DisableStdout: showProgress,
 		}
 		logger, closer, err := util.NewLogger(loggerConfig)
 		if err != nil {
@@ -85,17 +198,86 @@ var rootCmd = &cobra.Command{
 			// Using GitHub App authentication
 			ctx = context.WithValue(ctx, config.AppIDKey, appId)
 			ctx = context.WithValue(ctx, config.PrivateKeyKey, privateKey)
+
+			// One token manager per app, shared by every worker and optionally persisted between runs
+			pool, err := newAppPool(apps, logger)
+			if err != nil {
+				return err
+			}
+			ctx = context.WithValue(ctx, config.AppPoolKey, pool)
+
+			// Cached tokens are meant to outlive the run, so they are only revoked without --token-cache
+			if tokenCache || tokenCacheFile != "" {
+				logger.Info("Keeping installation tokens for reuse by later runs", slog.Bool("token_cache", true))
+			} else {
+				revokeTokens = func(ctx context.Context) {
+					revoked, err := pool.RevokeTokens(ctx)
+					if err != nil {
+						logger.Warn("Some installation tokens could not be revoked", slog.Int("revoked", revoked), slog.Any("error", err))
+						return
+					}
+					if revoked > 0 {
+						logger.Info("Revoked installation tokens", slog.Int("revoked", revoked))
+					}
+				}
+			}
 		}
 
 		ctx = context.WithValue(ctx, config.BaseURLKey, baseURL)
+		ctx = context.WithValue(ctx, config.HostKey, host)
+		ctx = context.WithValue(ctx, config.TransportKey, http.RoundTripper(httpTransport))
 		ctx = context.WithValue(ctx, config.EnterpriseSlugKey, enterpriseSlug)
 
-		logger.Info("Logging initialized", slog.String("log_file", logFilePath))
+		// Request/response bodies are only logged when explicitly requested
+		if logHTTPBodies {
+			ctx = context.WithValue(ctx, config.MaxBodyLogBytesKey, logBodyLimit)
+		}
+
+		logger.Info("Targeting GitHub host",
+			slog.String("host", host.String()),
+			slog.String("rest_url", host.RESTURL()),
+			slog.String("graphql_url", host.GraphQLURL()))
+
+		logger.Info("Logging initialized",
+			slog.String("log_file", logFilePath),
+			slog.String("level", level.String()),
+			slog.String("format", loggerConfig.LogFormat),
+			slog.Bool("http_bodies", logHTTPBodies))
+
+		// Tracing is exported to a collector when an endpoint is configured, otherwise to a JSON file next to the logs
+		shutdown, traceFilePath, err := telemetry.Setup(ctx, telemetry.Config{
+			Enabled:  traceEnabled,
+			Endpoint: otelEndpoint,
+			FileDir:  logDir,
+			NoFile:   noLogFile,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to initialize tracing: %w", err)
+		}
+		shutdownTracing = shutdown
+		switch {
+		case traceFilePath != "":
+			logger.Info("Tracing initialized", slog.String("trace_file", traceFilePath))
+		case traceEnabled || otelEndpoint != "":
+			logger.Info("Tracing initialized", slog.String("otel_endpoint", otelEndpoint))
+		}
+
+		if showProgress {
+			title := fmt.Sprintf("%s (logs: %s)", cmd.CommandPath(), logFilePath)
+			ctx = context.WithValue(ctx, config.ProgressKey, progress.Tracker(progress.NewConsole(os.Stdout, title)))
+		}
 
 		cmd.SetContext(ctx)
 		return nil
 	},
 	PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+		progress.FromContext(cmd.Context()).Finish()
+
+		// Use a fresh context: the command's context may already be cancelled
+		revokeCtx, cancel := context.WithTimeout(context.WithoutCancel(cmd.Context()), revokeTimeout)
+		revokeTokens(revokeCtx)
+		cancel()
+
 		// Cleanup: close log file if it was opened
 		if closer, ok := cmd.Context().Value("logCloser").(io.Closer); ok && closer != nil {
 			return closer.Close()
@@ -104,31 +286,184 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+// resolveCredentials loads the private key from --private-key-file (or stdin with "-") and falls back to
+// environment variables when no credentials were passed as flags. Explicit flags always take precedence.
+func resolveCredentials(cmd *cobra.Command) error {
+	if privateKey != "" && privateKeyFile != "" {
+		return fmt.Errorf("conflicting private key sources: provide either --private-key or --private-key-file, not both")
+	}
+
+	if privateKeyFile != "" {
+		key, err := auth.ReadPrivateKeyFile(privateKeyFile, cmd.InOrStdin())
+		if err != nil {
+			return err
+		}
+		privateKey = key
+	}
+
+	// Environment variables only fill in values that were not provided on the command line,
+	// and never introduce a second authentication method alongside explicit flags
+	usingAppFlags := appId != "" || privateKey != ""
+	if token == "" && !usingAppFlags {
+		token = os.Getenv(auth.EnvToken)
+	}
+	if token == "" {
+		if appId == "" {
+			appId = os.Getenv(auth.EnvAppID)
+		}
+		if privateKey == "" {
+			privateKey = os.Getenv(auth.EnvPrivateKey)
+		}
+		if installationID == 0 {
+			if value := os.Getenv(auth.EnvInstallationID); value != "" {
+				id, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					return fmt.Errorf("%s must be a numeric installation ID, got %q", auth.EnvInstallationID, value)
+				}
+				installationID = id
+			}
+		}
+	}
+
+	if installationID < 0 {
+		return fmt.Errorf("--installation-id must be a positive installation ID")
+	}
+	if installationID != 0 && token != "" {
+		return fmt.Errorf("--installation-id only applies to GitHub App authentication")
+	}
+
+	return nil
+}
+
+// resolveHost builds the target host from --host, --host-type and --ghes-version, or from --base-url
+func resolveHost() (*config.Host, error) {
+	if hostName != "" && baseURL != "" {
+		return nil, fmt.Errorf("conflicting host settings: provide either --host or --base-url, not both")
+	}
+	if hostName != "" {
+		return config.ParseHost(hostName, hostType, ghesVersion)
+	}
+	if baseURL == "" {
+		baseURL = config.DefaultBaseURL
+	}
+	return config.HostFromBaseURL(baseURL, hostType, ghesVersion)
+}
+
+// pooledApp is a validated GitHub App together with its pinned enterprise installation, if any
+type pooledApp struct {
+	service        *auth.TokenService
+	installationID int64
+}
+
+// newAppPool creates a token manager for each app, with its own encrypted cache when --token-cache is set.
+// With --token-cache-file, additional apps use that path suffixed with their app ID.
+func newAppPool(apps []pooledApp, logger *slog.Logger) (*auth.AppPool, error) {
+	managers := make([]*auth.TokenManager, 0, len(apps))
+	for i, app := range apps {
+		var store *auth.TokenStore
+		if tokenCache || tokenCacheFile != "" {
+			cachePath := tokenCacheFile
+			if cachePath == "" {
+				path, err := auth.DefaultTokenCachePath(app.service.AppID(), baseURL)
+				if err != nil {
+					return nil, err
+				}
+				cachePath = path
+			} else if i > 0 {
+				cachePath = fmt.Sprintf("%s-%s", tokenCacheFile, app.service.AppID())
+			}
+			s, err := auth.NewTokenStore(cachePath, app.service)
+			if err != nil {
+				return nil, err
+			}
+			store = s
+		}
+		managers = append(managers, auth.NewTokenManager(app.service, auth.TokenManagerOptions{
+			Store:                    store,
+			EnterpriseSlug:           enterpriseSlug,
+			EnterpriseInstallationID: app.installationID,
+			Logger:                   logger,
+		}))
+	}
+
+	if len(managers) > 1 {
+		logger.Info("Spreading requests across GitHub Apps", slog.Int("apps", len(managers)))
+	}
+
+	return auth.NewAppPool(logger, managers...), nil
+}
+
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
+	// Cancel in-flight work on Ctrl+C or SIGTERM so the run can clean up; a second signal exits immediately
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+
+	// PersistentPostRunE does not run when the command fails, so revoke here as well; it is a no-op
+	// for tokens that were already revoked
+	revokeCtx, cancel := context.WithTimeout(context.Background(), revokeTimeout)
+	revokeTokens(revokeCtx)
+	cancel()
+
+	if shutdownErr := shutdownTracing(context.Background()); shutdownErr != nil {
+		fmt.Fprintln(os.Stderr, "Warning: failed to flush traces:", util.Redact(shutdownErr.Error()))
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, util.Redact(err.Error()))
 		os.Exit(1)
 	}
 }
 
 func init() {
 	// GitHub App authentication flags
-	rootCmd.PersistentFlags().StringVar(&appId, "app-id", "", "GitHub App ID (required if not using --token)")
-	rootCmd.PersistentFlags().StringVar(&privateKey, "private-key", "", "GitHub App private key PEM content (required if not using --token)")
+	rootCmd.PersistentFlags().StringVar(&appId, "app-id", "", "GitHub App ID (required if not using --token; env "+auth.EnvAppID+")")
+	rootCmd.PersistentFlags().StringVar(&privateKeyFile, "private-key-file", "", "Path to the GitHub App private key PEM file, or '-' to read it from stdin (env "+auth.EnvPrivateKey+" holds the PEM content)")
+	rootCmd.PersistentFlags().StringVar(&privateKey, "private-key", "", "GitHub App private key PEM content (visible in shell history and process listings; prefer --private-key-file)")
+
+	rootCmd.PersistentFlags().Int64Var(&installationID, "installation-id", 0, "Pin the GitHub App's enterprise installation ID instead of looking it up by enterprise slug (env "+auth.EnvInstallationID+")")
+	rootCmd.PersistentFlags().StringVar(&appsFile, "apps-file", "", "JSON file listing additional GitHub Apps installed on the enterprise; requests are spread across all apps by remaining rate limit")
+	rootCmd.PersistentFlags().BoolVar(&tokenCache, "token-cache", false, "Persist GitHub App installation tokens to an encrypted cache in the user cache directory and reuse them across runs")
+	rootCmd.PersistentFlags().StringVar(&tokenCacheFile, "token-cache-file", "", "Path of the encrypted installation token cache (implies --token-cache)")
 
 	// PAT authentication flag
-	rootCmd.PersistentFlags().StringVar(&token, "token", "", "GitHub Personal Access Token (required if not using GitHub App authentication)")
+	rootCmd.PersistentFlags().StringVar(&token, "token", "", "GitHub Personal Access Token (required if not using GitHub App authentication; env "+auth.EnvToken+")")
 
 	// Common flags
-	rootCmd.PersistentFlags().StringVar(&baseURL, "base-url", "", "GitHub API base URL")
+	rootCmd.PersistentFlags().StringVar(&hostName, "host", "", "GitHub hostname: github.com, <subdomain>.ghe.com or a GitHub Enterprise Server hostname (API roots are derived from it)")
+	rootCmd.PersistentFlags().StringVar(&hostType, "host-type", "", "Host type: dotcom, ghe.com or ghes (inferred from --host or --base-url when omitted)")
+	rootCmd.PersistentFlags().StringVar(&ghesVersion, "ghes-version", "", "GitHub Enterprise Server version, e.g. 3.14 (checked against the minimum supported version)")
+	rootCmd.PersistentFlags().StringVar(&baseURL, "base-url", "", "GitHub REST API root (alternative to --host), e.g. https://github.example.com/api/v3")
+	rootCmd.PersistentFlags().StringVar(&caFile, "ca-file", "", "PEM bundle of additional certificate authorities to trust, e.g. an internal CA for GitHub Enterprise Server or a TLS-inspecting proxy")
+	rootCmd.PersistentFlags().StringVar(&proxyURL, "proxy", "", "HTTP(S) proxy URL for GitHub requests (defaults to HTTPS_PROXY/HTTP_PROXY, honoring NO_PROXY)")
+	rootCmd.PersistentFlags().StringVar(&clientCert, "client-cert", "", "PEM client certificate for mutual TLS (requires --client-key)")
+	rootCmd.PersistentFlags().StringVar(&clientKey, "client-key", "", "PEM private key of the --client-cert certificate")
+	rootCmd.PersistentFlags().DurationVar(&connectTimeout, "connect-timeout", transport.DefaultConnectTimeout, "Timeout for connecting to GitHub, including the TLS handshake")
+	rootCmd.PersistentFlags().DurationVar(&respTimeout, "response-timeout", transport.DefaultResponseTimeout, "Timeout waiting for GitHub to start responding to a request")
 	rootCmd.PersistentFlags().StringVar(&enterpriseSlug, "enterprise-slug", "", "GitHub Enterprise slug")
 	rootCmd.MarkPersistentFlagRequired("enterprise-slug")
 
-	if baseURL == "" {
-		baseURL = config.DefaultBaseURL
-	}
+	// Logging flags
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Minimum log level: debug, info, warn or error")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", util.LogFormatJSON, "Log output format: json or text")
+	rootCmd.PersistentFlags().StringVar(&logDir, "log-dir", "logs", "Directory for the log file")
+	rootCmd.PersistentFlags().BoolVar(&noLogFile, "no-log-file", false, "Do not write a log file; log to stdout only")
+	rootCmd.PersistentFlags().BoolVar(&logHTTPBodies, "log-http-bodies", false, "Log GitHub API request and response bodies (may be large)")
+	rootCmd.PersistentFlags().Int64Var(&logBodyLimit, "log-body-limit", 4096, "Maximum number of bytes of each request/response body to log when --log-http-bodies is set")
+	rootCmd.PersistentFlags().BoolVar(&noProgress, "no-progress", false, "Disable the live terminal progress view and print JSON logs to stdout")
+
+	// Tracing flags
+	rootCmd.PersistentFlags().BoolVar(&traceEnabled, "trace", false, "Record OpenTelemetry traces (to the OTLP endpoint if configured, otherwise to a JSON file in the log directory)")
+	rootCmd.PersistentFlags().StringVar(&otelEndpoint, "otel-endpoint", "", "OTLP/HTTP collector endpoint for traces, e.g. http://localhost:4318 (implies --trace; defaults to OTEL_EXPORTER_OTLP_ENDPOINT)")
 
 	rootCmd.AddCommand(lab.LabCmd)
 	rootCmd.AddCommand(repo.RepoCmd)
 	rootCmd.AddCommand(orgs.OrgsCmd)
+	rootCmd.AddCommand(doctor.DoctorCmd)
+	rootCmd.AddCommand(templates.TemplatesCmd)
 }
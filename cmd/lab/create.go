@@ -15,18 +15,23 @@ var (
 	repos             string
 	templateReposFile string
 	facilitators      string
+	allowOverage      bool
 )
 
 func init() {
 
 	CreateCmd.PersistentFlags().StringVar(&templateReposFile, "template-repos", "", "Path to template repositories file (JSON) (required)")
 	CreateCmd.MarkPersistentFlagRequired("template-repos")
+	CreateCmd.PersistentFlags().BoolVar(&allowOverage, "allow-license-overage", false, "Provision the lab even when it needs more seats or GHAS committers than the enterprise has available")
 
 }
 
 var CreateCmd = &cobra.Command{
 	Use:   "create",
 	Short: "Create a full lab environment (org, repos, users)",
+	Annotations: map[string]string{
+		config.ProgressAnnotation: "true",
+	},
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 		// Traverse up to find and call the root command's PersistentPreRunE
 		root := cmd
@@ -45,6 +50,11 @@ var CreateCmd = &cobra.Command{
 		ctx := cmd.Context()
 		ctx = context.WithValue(ctx, config.FacilitatorsKey, strings.Split(facilitators, ","))
 		ctx = context.WithValue(ctx, config.LabDateKey, labDate)
+		ctx = context.WithValue(ctx, config.AllowLicenseOverageKey, allowOverage)
+		ctx, err := withManagedUsers(ctx)
+		if err != nil {
+			return err
+		}
 
 		cmd.SetContext(ctx)
 		return nil
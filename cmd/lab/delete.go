@@ -11,9 +11,16 @@ import (
 	"github.com/spf13/cobra"
 )
 
+func init() {
+	DeleteCmd.PersistentFlags().StringVar(&templateReposFile, "template-repos", "", "Path to the template repositories file (JSON) the lab was created with; its topology decides which organizations are deleted (default org-per-user)")
+}
+
 var DeleteCmd = &cobra.Command{
 	Use:   "delete",
 	Short: "Delete a full lab environment (org, repos, users)",
+	Annotations: map[string]string{
+		config.ProgressAnnotation: "true",
+	},
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 
 		// Traverse up to find and call the root command's PersistentPreRunE
@@ -32,6 +39,10 @@ var DeleteCmd = &cobra.Command{
 		ctx := cmd.Context()
 		ctx = context.WithValue(ctx, config.FacilitatorsKey, strings.Split(facilitators, ","))
 		ctx = context.WithValue(ctx, config.LabDateKey, labDate)
+		ctx, err := withManagedUsers(ctx)
+		if err != nil {
+			return err
+		}
 
 		cmd.SetContext(ctx)
 		return nil
@@ -43,6 +54,6 @@ var DeleteCmd = &cobra.Command{
 			logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
 		}
 
-		return labservice.DestroyLabEnvironment(ctx, logger, labDate, usersFile)
+		return labservice.DestroyLabEnvironment(ctx, logger, labDate, usersFile, templateReposFile)
 	},
 }
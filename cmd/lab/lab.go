@@ -1,14 +1,46 @@
 package lab
 
 import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
 	"github.com/spf13/cobra"
 )
 
 var (
-	usersFile string
-	labDate   string
+	usersFile    string
+	labDate      string
+	emuShortcode string
+	scimURL      string
 )
 
+// shortcodePattern matches an Enterprise Managed Users shortcode, the suffix of managed user logins
+var shortcodePattern = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// withManagedUsers adds the EMU settings to the context when --emu-shortcode is set
+func withManagedUsers(ctx context.Context) (context.Context, error) {
+	if emuShortcode == "" {
+		if scimURL != "" {
+			return nil, fmt.Errorf("--scim-url requires --emu-shortcode")
+		}
+		return ctx, nil
+	}
+	if !shortcodePattern.MatchString(emuShortcode) {
+		return nil, fmt.Errorf("invalid --emu-shortcode %q: expected the letters and digits after the underscore in managed user logins", emuShortcode)
+	}
+	if host, ok := ctx.Value(config.HostKey).(*config.Host); ok && host != nil {
+		if err := host.Supports(config.FeatureManagedUserProvisioning); err != nil {
+			return nil, err
+		}
+	}
+
+	ctx = context.WithValue(ctx, config.EMUShortcodeKey, emuShortcode)
+	ctx = context.WithValue(ctx, config.SCIMURLKey, scimURL)
+	return ctx, nil
+}
+
 var LabCmd = &cobra.Command{
 	Use:   "lab",
 	Short: "Manage complete lab environments (orgs, repos, users)",
@@ -18,10 +50,11 @@ var LabCmd = &cobra.Command{
 func init() {
 	LabCmd.PersistentFlags().StringVar(&labDate, "lab-date", "", "Date string to identify date of the lab (e.g., '2024-06-15')")
 	LabCmd.MarkPersistentFlagRequired("lab-date")
-	LabCmd.PersistentFlags().StringVar(&usersFile, "users-file", "", "Path to user file (txt) (required)")
+	LabCmd.PersistentFlags().StringVar(&usersFile, "users-file", "", "Path to users file: txt of comma-separated logins, or csv, json or yaml with per-user metadata and roles (required)")
 	LabCmd.MarkPersistentFlagRequired("users-file")
-	LabCmd.PersistentFlags().StringVar(&facilitators, "facilitators", "", "lab facilitators usernames, comma-separated")
-	LabCmd.MarkPersistentFlagRequired("facilitators")
+	LabCmd.PersistentFlags().StringVar(&facilitators, "facilitators", "", "lab facilitators usernames, comma-separated (in addition to users with the facilitator role in the users file)")
+	LabCmd.PersistentFlags().StringVar(&emuShortcode, "emu-shortcode", "", "Enterprise Managed Users shortcode; provisions lab users through SCIM on create and deprovisions them on delete")
+	LabCmd.PersistentFlags().StringVar(&scimURL, "scim-url", "", "SCIM base URL overriding <base-url>/scim/v2/enterprises/<slug>, e.g. a local SCIM stand-in")
 
 	LabCmd.AddCommand(CreateCmd)
 	LabCmd.AddCommand(DeleteCmd)
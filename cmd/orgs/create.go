@@ -69,7 +69,8 @@ var CreateCmd = &cobra.Command{
 			return fmt.Errorf("user validation failed: %w", err)
 		}
 		if len(userValidation.ValidUsers) == 0 {
-			return fmt.Errorf("user '%s' not found in GitHub", user)
+			invalid := userValidation.Invalid[0]
+			return fmt.Errorf("user '%s' cannot be added (%s): %s", user, invalid.Reason, invalid.Detail)
 		}
 
 		// Validate and filter facilitators
@@ -83,7 +84,7 @@ var CreateCmd = &cobra.Command{
 
 			if len(facilitatorValidation.InvalidUsers) > 0 {
 				logger.Warn("Some facilitators are invalid and will be skipped",
-					slog.Any("invalid_facilitators", facilitatorValidation.InvalidUsers))
+					slog.Any("invalid_facilitators", facilitatorValidation.Invalid))
 			}
 
 			facilitators = facilitatorValidation.ValidUsers
@@ -112,7 +113,13 @@ var CreateCmd = &cobra.Command{
 			slog.String("user", user),
 			slog.String("lab_date", labDate))
 
-		// Install app on the organization
+		// Install app on the organization; a PAT needs no installation
+		if !api.UsesAppAuth(ctx) {
+			logger.Info("Skipping app installation with Personal Access Token authentication",
+				slog.String("org", org.Login))
+			return nil
+		}
+
 		_, err = enterprise.InstallAppOnOrg(ctx, logger, org.Login)
 		if err != nil {
 			logger.Error("Failed to install app on organization",
@@ -4,7 +4,6 @@ import (
 	"context"
 	"log/slog"
 	"os"
-	"strings"
 
 	"github.com/s-samadi/ghas-lab-builder/internal/config"
 	reposervice "github.com/s-samadi/ghas-lab-builder/internal/services"
@@ -63,13 +62,7 @@ var DeleteCmd = &cobra.Command{
 
 			repoNames = make([]string, len(repoConfigs))
 			for i, config := range repoConfigs {
-
-				parts := strings.Split(config.Template, "/")
-				if len(parts) == 2 {
-					repoNames[i] = parts[1]
-				} else {
-					repoNames[i] = config.Template
-				}
+				repoNames[i] = config.RepoName()
 			}
 		} else {
 			logger.Info("No repos file specified, will delete all repositories in the organization")
@@ -0,0 +1,79 @@
+package templates
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/auth"
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+	templateservice "github.com/s-samadi/ghas-lab-builder/internal/services"
+	"github.com/spf13/cobra"
+)
+
+var (
+	templateReposFile string
+	org               string
+	outputFile        string
+	sourceURL         string
+	sourceToken       string
+	visibility        string
+	overwrite         bool
+)
+
+func init() {
+	SyncCmd.Flags().StringVar(&templateReposFile, "template-repos", "", "Path to template repositories file (JSON) (required)")
+	SyncCmd.MarkFlagRequired("template-repos")
+	SyncCmd.Flags().StringVar(&org, "org", "", "Organization of the target enterprise to copy the templates into (required)")
+	SyncCmd.MarkFlagRequired("org")
+	SyncCmd.Flags().StringVar(&outputFile, "output", "", "Path of the template repositories file written with the copies as templates (default <template-repos>.<org>.json)")
+	SyncCmd.Flags().StringVar(&sourceURL, "source-url", "https://github.com", "Web URL of the host the templates are copied from")
+	SyncCmd.Flags().StringVar(&sourceToken, "source-token", "", "Token to clone private templates from the source host (or set GHAS_LAB_SOURCE_TOKEN)")
+	SyncCmd.Flags().StringVar(&visibility, "visibility", "internal", "Visibility of the copies: internal, private or public")
+	SyncCmd.Flags().BoolVar(&overwrite, "overwrite", false, "Push to repositories of the organization that templates sync did not create, replacing their branches and tags")
+}
+
+var SyncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Copy template repositories into an organization of the target enterprise",
+	Long: `The 'templates sync' command copies each template of a template repositories file, with all its branches
+and tags, into an organization of the target enterprise and marks the copies as templates. It then writes the
+file with the copies as the lab's templates, for GitHub Enterprise Server and Enterprise Managed Users
+enterprises that cannot generate repositories from templates on github.com. Copies made by an earlier sync
+are updated; other repositories with the same name are only replaced with --overwrite. Requires git.`,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		// Get logger from context (initialized in root command)
+		logger, ok := ctx.Value(config.LoggerKey).(*slog.Logger)
+		if !ok || logger == nil {
+			// Fallback to default logger if not found
+			logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+		}
+
+		switch visibility {
+		case "internal", "private", "public":
+		default:
+			return fmt.Errorf("invalid --visibility %q: expected internal, private or public", visibility)
+		}
+		if sourceToken == "" {
+			sourceToken = os.Getenv(auth.EnvSourceToken)
+		}
+		// The default output sits next to the input, so paths of imported repositories still resolve
+		if outputFile == "" {
+			outputFile = fmt.Sprintf("%s.%s.json", strings.TrimSuffix(templateReposFile, ".json"), org)
+		}
+
+		return templateservice.SyncTemplates(ctx, logger, templateservice.TemplateSyncOptions{
+			TemplateReposFile: templateReposFile,
+			OutputFile:        outputFile,
+			Org:               org,
+			SourceURL:         sourceURL,
+			SourceToken:       sourceToken,
+			Visibility:        visibility,
+			Overwrite:         overwrite,
+		})
+	},
+}
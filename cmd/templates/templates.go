@@ -0,0 +1,15 @@
+package templates
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var TemplatesCmd = &cobra.Command{
+	Use:   "templates",
+	Short: "Manage the template repositories labs are generated from",
+	Long:  "The 'templates' command copies the template repositories of a lab into the target enterprise, for hosts that cannot generate repositories from templates on another instance.",
+}
+
+func init() {
+	TemplatesCmd.AddCommand(SyncCmd)
+}
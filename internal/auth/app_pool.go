@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AppCredentials identifies one GitHub App listed in an apps file
+type AppCredentials struct {
+	AppID          string `json:"app_id"`
+	PrivateKeyFile string `json:"private_key_file"`
+	InstallationID int64  `json:"installation_id,omitempty"`
+
+	// PrivateKey holds the PEM content once the file has been read
+	PrivateKey string `json:"-"`
+}
+
+type appsFile struct {
+	Apps []AppCredentials `json:"apps"`
+}
+
+// LoadAppsFile reads a JSON file listing additional GitHub Apps for the pool.
+// Relative private key paths are resolved against the directory of the apps file.
+func LoadAppsFile(path string) ([]AppCredentials, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read apps file: %w", err)
+	}
+
+	var file appsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse apps file %s: %w", path, err)
+	}
+	if len(file.Apps) == 0 {
+		return nil, fmt.Errorf("apps file %s does not list any apps", path)
+	}
+
+	for i := range file.Apps {
+		app := &file.Apps[i]
+		if app.PrivateKeyFile == "" || app.PrivateKeyFile == "-" {
+			return nil, fmt.Errorf("app %q in %s needs a private_key_file path", app.AppID, path)
+		}
+		keyPath := app.PrivateKeyFile
+		if !filepath.IsAbs(keyPath) {
+			keyPath = filepath.Join(filepath.Dir(path), keyPath)
+		}
+		key, err := ReadPrivateKeyFile(keyPath, nil)
+		if err != nil {
+			return nil, fmt.Errorf("app %q: %w", app.AppID, err)
+		}
+		app.PrivateKey = key
+	}
+
+	return file.Apps, nil
+}
+
+// quota is the last rate limit GitHub reported for one app installation
+type quota struct {
+	remaining int
+	reset     time.Time
+}
+
+type quotaKey struct {
+	appID          string
+	installationID int64
+}
+
+// AppPool spreads requests across several GitHub Apps installed on the same enterprise. Every
+// request is routed to the app whose installation on the target has the most rate limit
+// remaining, using the X-RateLimit headers of earlier responses. A pool of one app behaves
+// exactly like that app's TokenManager.
+type AppPool struct {
+	managers []*TokenManager
+	logger   *slog.Logger
+
+	mu     sync.Mutex
+	quotas map[quotaKey]*quota
+	owners map[string]quotaKey
+	next   int
+}
+
+// NewAppPool creates a pool from one or more token managers; the first is the primary app
+func NewAppPool(logger *slog.Logger, managers ...*TokenManager) *AppPool {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &AppPool{
+		managers: managers,
+		logger:   logger,
+		quotas:   make(map[quotaKey]*quota),
+		owners:   make(map[string]quotaKey),
+	}
+}
+
+// Managers returns the token manager of every app in the pool, primary first
+func (p *AppPool) Managers() []*TokenManager {
+	return p.managers
+}
+
+// RevokeTokens revokes the tokens minted during this run by every app in the pool
+func (p *AppPool) RevokeTokens(ctx context.Context) (int, error) {
+	total := 0
+	var errs []error
+	for _, manager := range p.managers {
+		revoked, err := manager.RevokeTokens(ctx)
+		total += revoked
+		if err != nil {
+			errs = append(errs, fmt.Errorf("app %s: %w", manager.Service().AppID(), err))
+		}
+	}
+
+	p.mu.Lock()
+	p.owners = make(map[string]quotaKey)
+	p.mu.Unlock()
+
+	return total, errors.Join(errs...)
+}
+
+// TokenFor returns a token from the app with the most rate limit headroom for the target
+func (p *AppPool) TokenFor(ctx context.Context, targetType string, orgLogin string) (string, error) {
+	if len(p.managers) == 1 {
+		return p.managers[0].TokenFor(ctx, targetType, orgLogin)
+	}
+
+	type candidate struct {
+		manager      *TokenManager
+		installation Installation
+		headroom     int
+	}
+
+	var candidates []candidate
+	var lastErr error
+	for _, manager := range p.managers {
+		installation, err := manager.installationFor(ctx, targetType, orgLogin, false)
+		if err != nil {
+			// One app missing from an org should not stop the others from serving it
+			p.logger.Debug("App cannot serve request",
+				slog.String("app_id", manager.Service().AppID()),
+				slog.String("target_type", targetType),
+				slog.String("org", orgLogin),
+				slog.Any("error", err))
+			lastErr = err
+			continue
+		}
+		candidates = append(candidates, candidate{manager: manager, installation: installation})
+	}
+	if len(candidates) == 0 {
+		return "", lastErr
+	}
+
+	p.mu.Lock()
+	for i := range candidates {
+		candidates[i].headroom = p.headroom(quotaKey{candidates[i].manager.Service().AppID(), candidates[i].installation.ID})
+	}
+	// Ties (e.g. before any quota is known) rotate between apps so concurrent workers spread out
+	start := p.next % len(candidates)
+	p.next++
+	best := start
+	for i := 1; i < len(candidates); i++ {
+		j := (start + i) % len(candidates)
+		if candidates[j].headroom > candidates[best].headroom {
+			best = j
+		}
+	}
+	chosen := candidates[best]
+	key := quotaKey{chosen.manager.Service().AppID(), chosen.installation.ID}
+	if q, ok := p.quotas[key]; ok && q.remaining > 0 {
+		// Count the request against the app now so workers choosing concurrently see it
+		q.remaining--
+	}
+	p.mu.Unlock()
+
+	token, err := chosen.manager.TokenFor(ctx, targetType, orgLogin)
+	if err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	p.owners[token] = key
+	p.mu.Unlock()
+
+	return token, nil
+}
+
+// headroom returns the requests remaining for an installation; callers must hold p.mu.
+// Installations with no response yet, or whose window has reset, are treated as unused.
+func (p *AppPool) headroom(key quotaKey) int {
+	q, ok := p.quotas[key]
+	if !ok || time.Now().After(q.reset) {
+		return math.MaxInt
+	}
+	return q.remaining
+}
+
+// RecordRateLimit updates the quota of the app installation that owns token from the
+// X-RateLimit headers of a response. Responses without those headers are ignored.
+func (p *AppPool) RecordRateLimit(token string, header http.Header) {
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	// Only the REST core limit is shared by the calls this tool makes
+	if resource := header.Get("X-RateLimit-Resource"); resource != "" && resource != "core" {
+		return
+	}
+	resetUnix, _ := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	owner, ok := p.owners[token]
+	if !ok {
+		return
+	}
+	p.quotas[owner] = &quota{
+		remaining: remaining,
+		reset:     time.Unix(resetUnix, 0),
+	}
+}
@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rateLimit returns the X-RateLimit headers of a response with remaining requests left
+func rateLimit(remaining int, reset time.Time, resource string) http.Header {
+	header := http.Header{}
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+	if resource != "" {
+		header.Set("X-RateLimit-Resource", resource)
+	}
+	return header
+}
+
+// newTestPool returns a pool of two apps, a and b, both installed on organization lab
+func newTestPool(t *testing.T) (*AppPool, *fakeApp, *fakeApp) {
+	t.Helper()
+	a := newFakeApp(t, "a")
+	a.installations["orgs/lab"] = 1
+	b := newFakeApp(t, "b")
+	b.installations["orgs/lab"] = 2
+	return NewAppPool(nil, a.manager(t, "100", ""), b.manager(t, "200", "")), a, b
+}
+
+// appOf returns which fake app minted a token
+func appOf(token string) string {
+	return strings.Split(token, "_")[1]
+}
+
+func TestAppPoolRoutesByHeadroom(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Minute)
+
+	tests := []struct {
+		name string
+		a, b http.Header
+		want string
+	}{
+		{"more remaining on b", rateLimit(10, future, "core"), rateLimit(4000, future, "core"), "b"},
+		{"more remaining on a", rateLimit(4000, future, "core"), rateLimit(10, future, "core"), "a"},
+		{"exhausted window has reset", rateLimit(0, past, "core"), rateLimit(100, future, "core"), "a"},
+		{"headers without a resource count as core", rateLimit(5, future, ""), rateLimit(50, future, ""), "b"},
+		{"search limits are ignored", rateLimit(5, future, "search"), rateLimit(50, future, "core"), "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool, _, _ := newTestPool(t)
+			ctx := context.Background()
+
+			// Before any quota is known the pool rotates between apps, so two calls reach both
+			tokens := map[string]string{}
+			for range 2 {
+				token, err := pool.TokenFor(ctx, "Organization", "lab")
+				if err != nil {
+					t.Fatal(err)
+				}
+				tokens[appOf(token)] = token
+			}
+			if len(tokens) != 2 {
+				t.Fatalf("tied apps were not rotated: %v", tokens)
+			}
+
+			pool.RecordRateLimit(tokens["a"], tt.a)
+			pool.RecordRateLimit(tokens["b"], tt.b)
+
+			token, err := pool.TokenFor(ctx, "Organization", "lab")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := appOf(token); got != tt.want {
+				t.Errorf("routed to app %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppPoolCountsPendingRequests(t *testing.T) {
+	pool, _, _ := newTestPool(t)
+	ctx := context.Background()
+	future := time.Now().Add(time.Hour)
+
+	tokens := map[string]string{}
+	for range 2 {
+		token, err := pool.TokenFor(ctx, "Organization", "lab")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[appOf(token)] = token
+	}
+	pool.RecordRateLimit(tokens["a"], rateLimit(12, future, "core"))
+	pool.RecordRateLimit(tokens["b"], rateLimit(10, future, "core"))
+
+	// Each request taken from a counts against it before its response arrives, so after two
+	// requests a has no more headroom than b and requests start going to both
+	var routed []string
+	for range 6 {
+		token, err := pool.TokenFor(ctx, "Organization", "lab")
+		if err != nil {
+			t.Fatal(err)
+		}
+		routed = append(routed, appOf(token))
+	}
+	if got := strings.Join(routed[:2], ""); got != "aa" {
+		t.Errorf("first requests went to %s, want aa", got)
+	}
+	if got := strings.Join(routed[2:], ""); strings.Count(got, "a") != 2 || strings.Count(got, "b") != 2 {
+		t.Errorf("requests after the quotas evened out went to %s, want both apps equally", got)
+	}
+}
+
+func TestAppPoolSkipsAppsNotInstalled(t *testing.T) {
+	pool, a, _ := newTestPool(t)
+	a.mu.Lock()
+	delete(a.installations, "orgs/lab")
+	a.mu.Unlock()
+
+	for range 3 {
+		token, err := pool.TokenFor(context.Background(), "Organization", "lab")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if appOf(token) != "b" {
+			t.Errorf("token %s is from an app that is not installed", token)
+		}
+	}
+
+	if _, err := pool.TokenFor(context.Background(), "Organization", "other"); err == nil {
+		t.Errorf("expected an error when no app is installed on the organization")
+	}
+}
+
+func TestAppPoolIgnoresUnknownTokens(t *testing.T) {
+	pool, _, _ := newTestPool(t)
+	pool.RecordRateLimit("ghs_unknown", rateLimit(0, time.Now().Add(time.Hour), "core"))
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if len(pool.quotas) != 0 {
+		t.Errorf("recorded a quota for a token the pool did not hand out: %v", pool.quotas)
+	}
+}
+
+func TestAppPoolRevokeTokens(t *testing.T) {
+	pool, a, b := newTestPool(t)
+	for range 2 {
+		if _, err := pool.TokenFor(context.Background(), "Organization", "lab"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revoked, err := pool.RevokeTokens(context.Background())
+	if err != nil || revoked != 2 {
+		t.Fatalf("RevokeTokens = %d, %v; want 2", revoked, err)
+	}
+	if len(a.revoked) != 1 || len(b.revoked) != 1 {
+		t.Errorf("revoked %v from a and %v from b", a.revoked, b.revoked)
+	}
+}
+
+func TestLoadAppsFile(t *testing.T) {
+	key, err := testKeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "second.pem"), []byte(key), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"relative key path", `{"apps": [{"app_id": "200", "private_key_file": "second.pem", "installation_id": 7}]}`, ""},
+		{"no apps", `{"apps": []}`, "does not list any apps"},
+		{"key from stdin", `{"apps": [{"app_id": "200", "private_key_file": "-"}]}`, "needs a private_key_file path"},
+		{"missing key", `{"apps": [{"app_id": "200", "private_key_file": "missing.pem"}]}`, `app "200"`},
+		{"invalid json", `{"apps": [`, "failed to parse apps file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "apps.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			apps, err := LoadAppsFile(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(apps) != 1 || apps[0].AppID != "200" || apps[0].InstallationID != 7 || apps[0].PrivateKey != key {
+				t.Errorf("got %+v", apps)
+			}
+		})
+	}
+}
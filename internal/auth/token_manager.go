@@ -0,0 +1,494 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/telemetry"
+)
+
+const (
+	// tokenRefreshMargin is how long before GitHub's expires_at a cached token is replaced
+	tokenRefreshMargin = 5 * time.Minute
+	// installationTTL bounds how long a looked-up installation is trusted; lab orgs are deleted and recreated
+	installationTTL = 30 * time.Minute
+)
+
+// TokenManagerOptions configures a TokenManager
+type TokenManagerOptions struct {
+	// Store persists tokens between runs. If nil, tokens are kept in memory only.
+	Store *TokenStore
+
+	// EnterpriseSlug is the enterprise whose installation is used for enterprise-level requests
+	EnterpriseSlug string
+
+	// EnterpriseInstallationID pins the enterprise installation, skipping the lookup entirely.
+	// Required when the app has more than one enterprise installation that the slug cannot disambiguate.
+	EnterpriseInstallationID int64
+
+	// Logger used for structured logging. If nil, slog.Default() is used.
+	Logger *slog.Logger
+}
+
+// mintedToken is a token created during this run and the installation it belongs to
+type mintedToken struct {
+	installationID int64
+	token          *InstallationToken
+}
+
+// cachedInstallation is an installation together with when it was looked up
+type cachedInstallation struct {
+	Installation Installation `json:"installation"`
+	FetchedAt    time.Time    `json:"fetched_at"`
+}
+
+// TokenManager is the single source of GitHub App credentials for a run. It caches the app's
+// client ID, the installations it has looked up and per-installation tokens, refreshes tokens
+// proactively before they expire and, when a TokenStore is configured, persists them between runs.
+// It is safe for concurrent use by all workers.
+type TokenManager struct {
+	service        *TokenService
+	store          *TokenStore
+	logger         *slog.Logger
+	enterpriseSlug string
+	pinnedID       int64
+
+	mu                     sync.Mutex
+	clientID               string
+	enterpriseInstallation *cachedInstallation
+	orgInstallations       map[string]*cachedInstallation
+	tokens                 map[int64]*InstallationToken
+
+	// minted records every token created during this run, including ones since refreshed,
+	// so that all of them can be revoked when the run ends
+	minted []mintedToken
+
+	// minting serialises token creation per installation so concurrent workers share one request
+	minting map[int64]*sync.Mutex
+	// lookups serialises installation lookups per account for the same reason
+	lookups map[string]*sync.Mutex
+}
+
+// NewTokenManager creates a TokenManager for service.
+// A cache that cannot be read is logged and ignored rather than failing the run.
+func NewTokenManager(service *TokenService, opts TokenManagerOptions) *TokenManager {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	m := &TokenManager{
+		service:          service,
+		store:            opts.Store,
+		logger:           logger,
+		enterpriseSlug:   opts.EnterpriseSlug,
+		pinnedID:         opts.EnterpriseInstallationID,
+		orgInstallations: make(map[string]*cachedInstallation),
+		tokens:           make(map[int64]*InstallationToken),
+		minting:          make(map[int64]*sync.Mutex),
+		lookups:          make(map[string]*sync.Mutex),
+	}
+
+	if m.store != nil {
+		state, err := m.store.load()
+		if err != nil {
+			logger.Warn("Ignoring unreadable token cache", slog.String("path", m.store.Path()), slog.Any("error", err))
+		} else if state.AppID == "" || state.AppID == service.AppID() {
+			m.clientID = state.ClientID
+			if state.EnterpriseSlug == m.enterpriseSlug {
+				m.enterpriseInstallation = state.EnterpriseInstallation
+			}
+			for login, installation := range state.OrgInstallations {
+				if installation != nil {
+					m.orgInstallations[login] = installation
+				}
+			}
+			for id, token := range state.Tokens {
+				if token != nil && isFresh(token) {
+					m.tokens[id] = token
+				}
+			}
+			logger.Info("Loaded token cache",
+				slog.String("path", m.store.Path()),
+				slog.Int("org_installations", len(m.orgInstallations)),
+				slog.Int("valid_tokens", len(m.tokens)))
+		}
+	}
+
+	return m
+}
+
+// Service returns the underlying TokenService
+func (m *TokenManager) Service() *TokenService {
+	return m.service
+}
+
+// ClientID returns the GitHub App's client ID, needed to install the app on new organizations
+func (m *TokenManager) ClientID(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	clientID := m.clientID
+	m.mu.Unlock()
+	if clientID != "" {
+		return clientID, nil
+	}
+
+	jwt, err := m.service.CreateJWT()
+	if err != nil {
+		return "", fmt.Errorf("failed to create JWT: %w", err)
+	}
+	app, err := m.service.GetApp(ctx, jwt)
+	if err != nil {
+		return "", fmt.Errorf("failed to get app: %w", err)
+	}
+	if app.ClientID == "" {
+		return "", fmt.Errorf("GitHub did not return a client ID for app %s", m.service.AppID())
+	}
+
+	m.mu.Lock()
+	m.clientID = app.ClientID
+	m.mu.Unlock()
+	m.persist()
+
+	return app.ClientID, nil
+}
+
+// TokenFor returns a valid token for the enterprise installation, or for the given organization's
+// installation when targetType is "Organization". A token that GitHub rejects because the
+// installation no longer exists (e.g. the org was recreated) triggers one fresh lookup.
+func (m *TokenManager) TokenFor(ctx context.Context, targetType string, orgLogin string) (_ string, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "auth.TokenFor", telemetry.AttrTargetType.String(targetType))
+	defer func() { telemetry.End(span, err) }()
+	if orgLogin != "" {
+		span.SetAttributes(telemetry.AttrOrg.String(orgLogin))
+	}
+
+	installation, err := m.installationFor(ctx, targetType, orgLogin, false)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := m.Token(ctx, installation.ID)
+	if errors.Is(err, ErrInstallationNotFound) && !(targetType == "Enterprise" && m.pinnedID != 0) {
+		m.logger.Info("Cached installation no longer exists, looking it up again",
+			slog.Int64("installation_id", installation.ID),
+			slog.String("target_type", targetType),
+			slog.String("org", orgLogin))
+		if installation, err = m.installationFor(ctx, targetType, orgLogin, true); err != nil {
+			return "", err
+		}
+		token, err = m.Token(ctx, installation.ID)
+	}
+	return token, err
+}
+
+// installationFor resolves the installation serving targetType, using orgLogin for organizations
+func (m *TokenManager) installationFor(ctx context.Context, targetType string, orgLogin string, refresh bool) (Installation, error) {
+	switch targetType {
+	case "Enterprise":
+		return m.EnterpriseInstallation(ctx, refresh)
+	case "Organization":
+		if orgLogin == "" {
+			return Installation{}, fmt.Errorf("an organization installation token was requested without an organization")
+		}
+		return m.OrgInstallation(ctx, orgLogin, refresh)
+	default:
+		return Installation{}, fmt.Errorf("unsupported installation target type %q", targetType)
+	}
+}
+
+// EnterpriseInstallation returns the app's installation on the configured enterprise. A pinned
+// installation ID is used as-is; otherwise GET /enterprises/{slug}/installation is tried, falling
+// back to the installation list only when that endpoint is unavailable.
+func (m *TokenManager) EnterpriseInstallation(ctx context.Context, refresh bool) (Installation, error) {
+	unlock := m.lockLookup("enterprise")
+	defer unlock()
+
+	m.mu.Lock()
+	cached := m.enterpriseInstallation
+	m.mu.Unlock()
+	if !refresh && isCurrent(cached) {
+		return cached.Installation, nil
+	}
+
+	jwt, err := m.service.CreateJWT()
+	if err != nil {
+		return Installation{}, fmt.Errorf("failed to create JWT: %w", err)
+	}
+
+	var installation *Installation
+	if m.pinnedID != 0 {
+		installation, err = m.service.GetInstallation(ctx, jwt, m.pinnedID)
+		if errors.Is(err, ErrInstallationNotFound) {
+			return Installation{}, fmt.Errorf("installation %d does not exist for app %s; check --installation-id", m.pinnedID, m.service.AppID())
+		}
+		if err != nil {
+			return Installation{}, fmt.Errorf("failed to get installation %d: %w", m.pinnedID, err)
+		}
+		if installation.TargetType != "Enterprise" {
+			return Installation{}, fmt.Errorf("installation %d is on %s %q, not an enterprise; check --installation-id",
+				m.pinnedID, strings.ToLower(installation.TargetType), installation.Account.Login)
+		}
+	} else {
+		if m.enterpriseSlug == "" {
+			return Installation{}, fmt.Errorf("an enterprise slug or --installation-id is required to find the enterprise installation")
+		}
+		installation, err = m.service.GetEnterpriseInstallation(ctx, jwt, m.enterpriseSlug)
+		if errors.Is(err, ErrInstallationNotFound) {
+			installation, err = m.findEnterpriseInstallation(ctx, jwt)
+		}
+		if err != nil {
+			return Installation{}, err
+		}
+	}
+
+	m.mu.Lock()
+	m.enterpriseInstallation = &cachedInstallation{Installation: *installation, FetchedAt: time.Now()}
+	m.rememberClientID(*installation)
+	m.mu.Unlock()
+	m.persist()
+
+	m.logger.Info("Resolved enterprise installation",
+		slog.Int64("installation_id", installation.ID),
+		slog.String("account", installation.Account.Login))
+
+	return *installation, nil
+}
+
+// findEnterpriseInstallation scans the installation list for enterprise installations. It is only
+// used when the enterprise installation endpoint is unavailable, and refuses to guess when the
+// slug does not single one out.
+func (m *TokenManager) findEnterpriseInstallation(ctx context.Context, jwt string) (*Installation, error) {
+	installations, err := m.service.GetInstallations(ctx, jwt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get installations: %w", err)
+	}
+
+	var enterprises []Installation
+	for _, installation := range installations {
+		if installation.TargetType != "Enterprise" {
+			continue
+		}
+		if strings.EqualFold(installation.Account.Login, m.enterpriseSlug) {
+			return &installation, nil
+		}
+		enterprises = append(enterprises, installation)
+	}
+
+	switch len(enterprises) {
+	case 0:
+		return nil, fmt.Errorf("GitHub App %s is not installed on enterprise %s", m.service.AppID(), m.enterpriseSlug)
+	case 1:
+		// Enterprise account logins do not always match the slug used in API paths
+		m.logger.Warn("Using the app's only enterprise installation, whose account does not match the enterprise slug",
+			slog.String("enterprise", m.enterpriseSlug),
+			slog.String("account", enterprises[0].Account.Login),
+			slog.Int64("installation_id", enterprises[0].ID))
+		return &enterprises[0], nil
+	default:
+		candidates := make([]string, 0, len(enterprises))
+		for _, installation := range enterprises {
+			candidates = append(candidates, fmt.Sprintf("%d (%s)", installation.ID, installation.Account.Login))
+		}
+		return nil, fmt.Errorf("GitHub App %s has %d enterprise installations and none matches enterprise %s: %s; pin one with --installation-id",
+			m.service.AppID(), len(enterprises), m.enterpriseSlug, strings.Join(candidates, ", "))
+	}
+}
+
+// OrgInstallation returns the app's installation on the given organization using GET /orgs/{org}/installation
+func (m *TokenManager) OrgInstallation(ctx context.Context, orgLogin string, refresh bool) (Installation, error) {
+	key := strings.ToLower(orgLogin)
+	unlock := m.lockLookup("org:" + key)
+	defer unlock()
+
+	m.mu.Lock()
+	cached := m.orgInstallations[key]
+	m.mu.Unlock()
+	if !refresh && isCurrent(cached) {
+		return cached.Installation, nil
+	}
+
+	jwt, err := m.service.CreateJWT()
+	if err != nil {
+		return Installation{}, fmt.Errorf("failed to create JWT: %w", err)
+	}
+	installation, err := m.service.GetOrgInstallation(ctx, jwt, orgLogin)
+	if errors.Is(err, ErrInstallationNotFound) {
+		return Installation{}, fmt.Errorf("%w for organization: %s", ErrInstallationNotFound, orgLogin)
+	}
+	if err != nil {
+		return Installation{}, fmt.Errorf("failed to get installation for organization %s: %w", orgLogin, err)
+	}
+
+	m.mu.Lock()
+	m.orgInstallations[key] = &cachedInstallation{Installation: *installation, FetchedAt: time.Now()}
+	m.rememberClientID(*installation)
+	m.mu.Unlock()
+	m.persist()
+
+	return *installation, nil
+}
+
+// Token returns a cached token for the installation, minting a new one when none is cached
+// or the cached token expires within tokenRefreshMargin
+func (m *TokenManager) Token(ctx context.Context, installationID int64) (string, error) {
+	if token := m.cachedToken(installationID); token != nil {
+		return token.Token, nil
+	}
+
+	// Only one worker mints a token for a given installation; the others wait and reuse it
+	m.mu.Lock()
+	lock, ok := m.minting[installationID]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.minting[installationID] = lock
+	}
+	m.mu.Unlock()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	if token := m.cachedToken(installationID); token != nil {
+		return token.Token, nil
+	}
+
+	jwt, err := m.service.CreateJWT()
+	if err != nil {
+		return "", fmt.Errorf("failed to create JWT: %w", err)
+	}
+	token, err := m.service.CreateInstallationToken(ctx, jwt, installationID)
+	if err != nil {
+		return "", fmt.Errorf("failed to create installation token: %w", err)
+	}
+
+	m.mu.Lock()
+	m.tokens[installationID] = token
+	m.minted = append(m.minted, mintedToken{installationID: installationID, token: token})
+	m.mu.Unlock()
+	m.persist()
+
+	m.logger.Info("Minted installation token",
+		slog.Int64("installation_id", installationID),
+		slog.Time("expires_at", token.ExpiresAt))
+
+	return token.Token, nil
+}
+
+// RevokeTokens revokes every token minted during this run and drops them from the cache.
+// It is safe to call more than once; tokens already revoked are skipped.
+func (m *TokenManager) RevokeTokens(ctx context.Context) (revoked int, err error) {
+	m.mu.Lock()
+	minted := m.minted
+	m.minted = nil
+	for _, t := range minted {
+		if m.tokens[t.installationID] == t.token {
+			delete(m.tokens, t.installationID)
+		}
+	}
+	m.mu.Unlock()
+
+	var errs []error
+	for _, t := range minted {
+		if time.Now().After(t.token.ExpiresAt) {
+			continue
+		}
+		if err := m.service.RevokeInstallationToken(ctx, t.token.Token); err != nil {
+			m.logger.Warn("Failed to revoke installation token",
+				slog.String("app_id", m.service.AppID()),
+				slog.Int64("installation_id", t.installationID),
+				slog.Any("error", err))
+			errs = append(errs, err)
+			continue
+		}
+		revoked++
+		m.logger.Info("Revoked installation token",
+			slog.String("app_id", m.service.AppID()),
+			slog.Int64("installation_id", t.installationID),
+			slog.Time("expires_at", t.token.ExpiresAt))
+	}
+	m.persist()
+
+	return revoked, errors.Join(errs...)
+}
+
+func (m *TokenManager) cachedToken(installationID int64) *InstallationToken {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	token, ok := m.tokens[installationID]
+	if !ok || !isFresh(token) {
+		return nil
+	}
+	return token
+}
+
+// lockLookup serialises lookups of the same account and returns the matching unlock function
+func (m *TokenManager) lockLookup(key string) func() {
+	m.mu.Lock()
+	lock, ok := m.lookups[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		m.lookups[key] = lock
+	}
+	m.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// rememberClientID records the app's client ID from an installation; callers must hold m.mu
+func (m *TokenManager) rememberClientID(installation Installation) {
+	if m.clientID == "" && installation.ClientID != "" {
+		m.clientID = installation.ClientID
+	}
+}
+
+// isFresh reports whether the token is still valid beyond the refresh margin.
+// Tokens without an expiry are treated as expired.
+func isFresh(token *InstallationToken) bool {
+	if token.ExpiresAt.IsZero() {
+		return false
+	}
+	return time.Until(token.ExpiresAt) > tokenRefreshMargin
+}
+
+// isCurrent reports whether a cached installation lookup can still be trusted
+func isCurrent(installation *cachedInstallation) bool {
+	return installation != nil && time.Since(installation.FetchedAt) < installationTTL
+}
+
+// persist writes the current state to the store, if one is configured. Failures only cost
+// a re-mint on the next run, so they are logged rather than returned.
+func (m *TokenManager) persist() {
+	if m.store == nil {
+		return
+	}
+
+	m.mu.Lock()
+	state := &persistedTokens{
+		AppID:                  m.service.AppID(),
+		ClientID:               m.clientID,
+		EnterpriseSlug:         m.enterpriseSlug,
+		EnterpriseInstallation: m.enterpriseInstallation,
+		OrgInstallations:       make(map[string]*cachedInstallation, len(m.orgInstallations)),
+		Tokens:                 make(map[int64]*InstallationToken, len(m.tokens)),
+	}
+	for login, installation := range m.orgInstallations {
+		state.OrgInstallations[login] = installation
+	}
+	for id, token := range m.tokens {
+		state.Tokens[id] = token
+	}
+
+	// Hold the lock while writing so concurrent saves cannot interleave
+	err := m.store.save(state)
+	m.mu.Unlock()
+
+	if err != nil {
+		m.logger.Warn("Failed to persist token cache", slog.String("path", m.store.Path()), slog.Any("error", err))
+	}
+}
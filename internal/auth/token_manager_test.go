@@ -0,0 +1,361 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testKeyPEM is one RSA key shared by every test, since generating keys is slow
+var testKeyPEM = sync.OnceValues(func() (string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, MinRSAKeyBits)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})), nil
+})
+
+// fakeApp stubs the GitHub endpoints one GitHub App uses to find installations and mint tokens
+type fakeApp struct {
+	name string
+
+	mu sync.Mutex
+	// installations maps "enterprises/<slug>" and "orgs/<login>" to installation IDs
+	installations map[string]int64
+	// gone lists installations that were deleted, whose tokens cannot be minted
+	gone map[int64]bool
+	// tokenTTL is how long minted tokens are valid for
+	tokenTTL time.Duration
+	lookups  int
+	minted   int
+	revoked  []string
+
+	server *httptest.Server
+}
+
+func newFakeApp(t *testing.T, name string) *fakeApp {
+	t.Helper()
+	app := &fakeApp{
+		name:          name,
+		installations: map[string]int64{},
+		gone:          map[int64]bool{},
+		tokenTTL:      time.Hour,
+	}
+
+	lookup := func(kind string, targetType string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			app.mu.Lock()
+			defer app.mu.Unlock()
+			app.lookups++
+			id, ok := app.installations[kind+"/"+r.PathValue("account")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{
+				"id":          id,
+				"account":     map[string]string{"login": r.PathValue("account")},
+				"target_type": targetType,
+				"client_id":   "Iv1." + name,
+			})
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /enterprises/{account}/installation", lookup("enterprises", "Enterprise"))
+	mux.HandleFunc("GET /orgs/{account}/installation", lookup("orgs", "Organization"))
+	mux.HandleFunc("POST /app/installations/{id}/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		app.mu.Lock()
+		defer app.mu.Unlock()
+		id, _ := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if app.gone[id] {
+			http.NotFound(w, r)
+			return
+		}
+		app.minted++
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(InstallationToken{
+			Token:     fmt.Sprintf("ghs_%s_%d_%d", name, id, app.minted),
+			ExpiresAt: time.Now().Add(app.tokenTTL).Truncate(time.Second),
+		})
+	})
+	mux.HandleFunc("DELETE /installation/token", func(w http.ResponseWriter, r *http.Request) {
+		app.mu.Lock()
+		defer app.mu.Unlock()
+		app.revoked = append(app.revoked, strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	app.server = httptest.NewServer(mux)
+	t.Cleanup(app.server.Close)
+
+	return app
+}
+
+// manager returns a token manager for the app, persisting to store when it is set
+func (a *fakeApp) manager(t *testing.T, appID string, store string) *TokenManager {
+	t.Helper()
+	key, err := testKeyPEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := NewTokenService(appID, key, a.server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := TokenManagerOptions{EnterpriseSlug: "octo-ent"}
+	if store != "" {
+		if opts.Store, err = NewTokenStore(store, service); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewTokenManager(service, opts)
+}
+
+func (a *fakeApp) counts() (lookups int, minted int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lookups, a.minted
+}
+
+func TestTokenManagerCachesTokens(t *testing.T) {
+	app := newFakeApp(t, "a")
+	app.installations["enterprises/octo-ent"] = 1
+	app.installations["orgs/lab"] = 2
+	manager := app.manager(t, "100", "")
+	ctx := context.Background()
+
+	enterprise, err := manager.TokenFor(ctx, "Enterprise", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	org, err := manager.TokenFor(ctx, "Organization", "lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := manager.TokenFor(ctx, "Organization", "LAB")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(enterprise, "ghs_a_1_") || !strings.HasPrefix(org, "ghs_a_2_") {
+		t.Errorf("enterprise token %s, org token %s", enterprise, org)
+	}
+	if again != org {
+		t.Errorf("second org token %s, want the cached %s", again, org)
+	}
+	if lookups, minted := app.counts(); lookups != 2 || minted != 2 {
+		t.Errorf("%d lookups and %d tokens minted, want 2 and 2", lookups, minted)
+	}
+
+	clientID, err := manager.ClientID(ctx)
+	if err != nil || clientID != "Iv1.a" {
+		t.Errorf("ClientID = %q, %v", clientID, err)
+	}
+}
+
+func TestTokenManagerRefresh(t *testing.T) {
+	tests := []struct {
+		name       string
+		ttl        time.Duration
+		wantMinted int
+	}{
+		{"valid for an hour", time.Hour, 1},
+		{"just outside the refresh margin", tokenRefreshMargin + time.Minute, 1},
+		{"inside the refresh margin", tokenRefreshMargin - time.Minute, 2},
+		{"already expired", -time.Minute, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newFakeApp(t, "a")
+			app.installations["orgs/lab"] = 2
+			app.tokenTTL = tt.ttl
+			manager := app.manager(t, "100", "")
+
+			first, err := manager.TokenFor(context.Background(), "Organization", "lab")
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, err := manager.TokenFor(context.Background(), "Organization", "lab")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, minted := app.counts(); minted != tt.wantMinted {
+				t.Errorf("minted %d tokens, want %d", minted, tt.wantMinted)
+			}
+			if (first == second) != (tt.wantMinted == 1) {
+				t.Errorf("tokens %s and %s", first, second)
+			}
+		})
+	}
+}
+
+func TestTokenManagerConcurrentMinting(t *testing.T) {
+	app := newFakeApp(t, "a")
+	app.installations["orgs/lab"] = 2
+	manager := app.manager(t, "100", "")
+
+	var wg sync.WaitGroup
+	tokens := make([]string, 10)
+	for i := range tokens {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tokens[i], _ = manager.TokenFor(context.Background(), "Organization", "lab")
+		}()
+	}
+	wg.Wait()
+
+	if lookups, minted := app.counts(); lookups != 1 || minted != 1 {
+		t.Errorf("%d lookups and %d tokens minted by concurrent workers, want 1 and 1", lookups, minted)
+	}
+	for _, token := range tokens {
+		if token != tokens[0] {
+			t.Fatalf("workers got different tokens: %v", tokens)
+		}
+	}
+}
+
+func TestTokenManagerRecreatedInstallation(t *testing.T) {
+	app := newFakeApp(t, "a")
+	app.installations["orgs/lab"] = 2
+	manager := app.manager(t, "100", "")
+	ctx := context.Background()
+
+	if _, err := manager.OrgInstallation(ctx, "lab", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The organization is deleted and recreated, so the cached installation no longer exists
+	app.mu.Lock()
+	app.gone[2] = true
+	app.installations["orgs/lab"] = 3
+	app.mu.Unlock()
+
+	token, err := manager.TokenFor(ctx, "Organization", "lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, "ghs_a_3_") {
+		t.Errorf("token %s is not for the new installation", token)
+	}
+	if lookups, _ := app.counts(); lookups != 2 {
+		t.Errorf("%d lookups, want 2", lookups)
+	}
+}
+
+func TestTokenManagerMissingInstallation(t *testing.T) {
+	app := newFakeApp(t, "a")
+	manager := app.manager(t, "100", "")
+
+	_, err := manager.TokenFor(context.Background(), "Organization", "lab")
+	if err == nil || !strings.Contains(err.Error(), "installation not found for organization: lab") {
+		t.Errorf("error = %v", err)
+	}
+	if _, err := manager.TokenFor(context.Background(), "Organization", ""); err == nil {
+		t.Errorf("expected an error for an organization token without an organization")
+	}
+}
+
+func TestTokenManagerRevokeTokens(t *testing.T) {
+	app := newFakeApp(t, "a")
+	app.installations["orgs/lab-1"] = 1
+	app.installations["orgs/lab-2"] = 2
+	manager := app.manager(t, "100", "")
+	ctx := context.Background()
+
+	var tokens []string
+	for _, org := range []string{"lab-1", "lab-2"} {
+		token, err := manager.TokenFor(ctx, "Organization", org)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	revoked, err := manager.RevokeTokens(ctx)
+	if err != nil || revoked != 2 {
+		t.Fatalf("RevokeTokens = %d, %v; want 2", revoked, err)
+	}
+	if strings.Join(app.revoked, ",") != strings.Join(tokens, ",") {
+		t.Errorf("revoked %v, want %v", app.revoked, tokens)
+	}
+	if revoked, err := manager.RevokeTokens(ctx); err != nil || revoked != 0 {
+		t.Errorf("second RevokeTokens = %d, %v; want 0", revoked, err)
+	}
+
+	// Revoked tokens are dropped from the cache
+	token, err := manager.TokenFor(ctx, "Organization", "lab-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == tokens[0] {
+		t.Errorf("revoked token %s was reused", token)
+	}
+}
+
+func TestTokenManagerPersistsTokens(t *testing.T) {
+	tests := []struct {
+		name       string
+		ttl        time.Duration
+		wantMinted int
+	}{
+		{"fresh token is reused by the next run", time.Hour, 1},
+		{"token about to expire is replaced", tokenRefreshMargin - time.Minute, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newFakeApp(t, "a")
+			app.installations["orgs/lab"] = 2
+			app.tokenTTL = tt.ttl
+			store := filepath.Join(t.TempDir(), "tokens.bin")
+			ctx := context.Background()
+
+			first, err := app.manager(t, "100", store).TokenFor(ctx, "Organization", "lab")
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, err := app.manager(t, "100", store).TokenFor(ctx, "Organization", "lab")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lookups, minted := app.counts()
+			if lookups != 1 {
+				t.Errorf("%d lookups, want the installation to come from the cache", lookups)
+			}
+			if minted != tt.wantMinted || (first == second) != (tt.wantMinted == 1) {
+				t.Errorf("minted %d tokens (%s, %s), want %d", minted, first, second, tt.wantMinted)
+			}
+		})
+	}
+}
+
+func TestTokenManagerIgnoresOtherAppsCache(t *testing.T) {
+	app := newFakeApp(t, "a")
+	app.installations["orgs/lab"] = 2
+	store := filepath.Join(t.TempDir(), "tokens.bin")
+	ctx := context.Background()
+
+	if _, err := app.manager(t, "100", store).TokenFor(ctx, "Organization", "lab"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.manager(t, "200", store).TokenFor(ctx, "Organization", "lab"); err != nil {
+		t.Fatal(err)
+	}
+	if lookups, minted := app.counts(); lookups != 2 || minted != 2 {
+		t.Errorf("%d lookups and %d tokens minted, want the second app to ignore the cache", lookups, minted)
+	}
+}
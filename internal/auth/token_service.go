@@ -1,30 +1,32 @@
 package auth
 
 import (
+	"context"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/json"
-	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"time"
 
 	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/s-samadi/ghas-lab-builder/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-type InstallationTokenInfo struct {
-	Token     string `json:"token"`
-	ExpiresAt string `json:"expires_at"`
-	ClientID  string `json:"client_id"`
-	AppID     string `json:"app_id"`
-}
+// tokenRequestTimeout bounds each call the token service makes to GitHub
+const tokenRequestTimeout = 30 * time.Second
 
 // TokenService handles GitHub App authentication
 type TokenService struct {
 	appID      string
-	privateKey string // Changed from privateKeyPath
+	privateKey *rsa.PrivateKey
 	baseURL    string
+	client     *http.Client
 }
 
 // Installation represents a GitHub App installation
@@ -33,8 +35,9 @@ type Installation struct {
 	Account struct {
 		Login string `json:"login"`
 	} `json:"account"`
-	TargetType string `json:"target_type"`
-	ClientID   string `json:"client_id"`
+	TargetType  string            `json:"target_type"`
+	ClientID    string            `json:"client_id"`
+	Permissions map[string]string `json:"permissions,omitempty"`
 }
 
 // InstallationToken represents the response from the installation token endpoint
@@ -43,48 +46,58 @@ type InstallationToken struct {
 	ExpiresAt time.Time `json:"expires_at"`
 }
 
-// NewTokenService creates a new TokenService
-func NewTokenService(appID, privateKey, baseURL string) *TokenService {
+// ErrInstallationNotFound is returned when the app is not installed on the requested account
+var ErrInstallationNotFound = errors.New("GitHub App installation not found")
+
+// App represents the authenticated GitHub App as returned by GET /app
+type App struct {
+	ID          int64             `json:"id"`
+	Slug        string            `json:"slug"`
+	Name        string            `json:"name"`
+	ClientID    string            `json:"client_id"`
+	Permissions map[string]string `json:"permissions"`
+}
+
+// NewTokenService creates a new TokenService, validating the app ID and private key up front
+// so that bad credentials are reported before any provisioning begins
+func NewTokenService(appID, privateKey, baseURL string) (*TokenService, error) {
+	if err := ValidateAppID(appID); err != nil {
+		return nil, err
+	}
+
+	key, err := ParsePrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
 	return &TokenService{
 		appID:      appID,
-		privateKey: privateKey, // Changed
+		privateKey: key,
 		baseURL:    baseURL,
-	}
+		client:     &http.Client{Timeout: tokenRequestTimeout},
+	}, nil
 }
 
-// CreateJWT generates a JWT for GitHub App authentication
-func (ts *TokenService) CreateJWT() (string, error) {
-	// Use the private key content directly
-	privateKeyData := []byte(ts.privateKey) // Changed
-
-	// Parse the PEM encoded private key
-	block, _ := pem.Decode(privateKeyData)
-	if block == nil {
-		return "", fmt.Errorf("failed to decode PEM block from private key")
-	}
+// SetTransport routes the service's requests, including token minting, through rt so they
+// share the CA bundle, proxy and client certificate of the other GitHub calls
+func (ts *TokenService) SetTransport(rt http.RoundTripper) {
+	ts.client = &http.Client{Transport: rt, Timeout: tokenRequestTimeout}
+}
 
-	// Parse the RSA private key
-	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
-	if err != nil {
-		// Try parsing as PKCS8
+// AppID returns the GitHub App ID this service authenticates as
+func (ts *TokenService) AppID() string {
+	return ts.appID
+}
 
-		type InstallationTokenInfo struct {
-			Token     string `json:"token"`
-			ExpiresAt string `json:"expires_at"`
-			ClientID  string `json:"client_id"`
-			AppID     string `json:"app_id"`
-		}
-		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
-		if err != nil {
-			return "", fmt.Errorf("failed to parse private key: %w", err)
-		}
-		var ok bool
-		privateKey, ok = key.(*rsa.PrivateKey)
-		if !ok {
-			return "", fmt.Errorf("not an RSA private key")
-		}
-	}
+// cacheSecret derives a stable secret from the private key, used to encrypt the on-disk token cache.
+// Only holders of the private key (who could mint tokens anyway) can read the cache.
+func (ts *TokenService) cacheSecret() []byte {
+	sum := sha256.Sum256(append([]byte("ghas-lab-builder token cache:"), x509.MarshalPKCS1PrivateKey(ts.privateKey)...))
+	return sum[:]
+}
 
+// CreateJWT generates a JWT for GitHub App authentication
+func (ts *TokenService) CreateJWT() (string, error) {
 	// Create the JWT claims
 	now := time.Now()
 	claims := jwt.RegisteredClaims{
@@ -97,7 +110,7 @@ func (ts *TokenService) CreateJWT() (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
 
 	// Sign the token with the private key
-	tokenString, err := token.SignedString(privateKey)
+	tokenString, err := token.SignedString(ts.privateKey)
 	if err != nil {
 		return "", fmt.Errorf("failed to sign JWT: %w", err)
 	}
@@ -106,15 +119,21 @@ func (ts *TokenService) CreateJWT() (string, error) {
 }
 
 // GetInstallations retrieves all installations for the GitHub App
-func (ts *TokenService) GetInstallations(jwt string) ([]Installation, error) {
+func (ts *TokenService) GetInstallations(ctx context.Context, jwt string) (installations []Installation, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "auth.GetInstallations")
+	defer func() {
+		span.SetAttributes(attribute.Int("ghas.installation_count", len(installations)))
+		telemetry.End(span, err)
+	}()
+
 	var allInstallations []Installation
 	page := 1
 	perPage := 100
 
 	for {
-		url := fmt.Sprintf("%s/app/installations?per_page=%d&page=%d", ts.baseURL, perPage, page)
+		listURL := fmt.Sprintf("%s/app/installations?per_page=%d&page=%d", ts.baseURL, perPage, page)
 
-		req, err := http.NewRequest("GET", url, nil)
+		req, err := http.NewRequestWithContext(ctx, "GET", listURL, nil)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create request: %w", err)
 		}
@@ -123,8 +142,7 @@ func (ts *TokenService) GetInstallations(jwt string) ([]Installation, error) {
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", jwt))
 		req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
 
-		client := &http.Client{Timeout: 30 * time.Second}
-		resp, err := client.Do(req)
+		resp, err := ts.client.Do(req)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get installations: %w", err)
 		}
@@ -160,11 +178,34 @@ func (ts *TokenService) GetInstallations(jwt string) ([]Installation, error) {
 	return allInstallations, nil
 }
 
-// CreateInstallationToken creates an installation access token
-func (ts *TokenService) CreateInstallationToken(jwt string, installationID int64) (*InstallationToken, error) {
-	url := fmt.Sprintf("%s/app/installations/%d/access_tokens", ts.baseURL, installationID)
+// GetInstallation retrieves a single installation by ID
+func (ts *TokenService) GetInstallation(ctx context.Context, jwt string, installationID int64) (_ *Installation, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "auth.GetInstallation",
+		telemetry.AttrInstallationID.Int64(installationID))
+	defer func() { telemetry.End(span, err) }()
+
+	return ts.getInstallation(ctx, jwt, fmt.Sprintf("/app/installations/%d", installationID))
+}
+
+// GetOrgInstallation retrieves the app's installation on an organization without listing every installation
+func (ts *TokenService) GetOrgInstallation(ctx context.Context, jwt string, orgLogin string) (_ *Installation, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "auth.GetOrgInstallation", telemetry.AttrOrg.String(orgLogin))
+	defer func() { telemetry.End(span, err) }()
+
+	return ts.getInstallation(ctx, jwt, fmt.Sprintf("/orgs/%s/installation", url.PathEscape(orgLogin)))
+}
+
+// GetEnterpriseInstallation retrieves the app's installation on an enterprise account
+func (ts *TokenService) GetEnterpriseInstallation(ctx context.Context, jwt string, enterpriseSlug string) (_ *Installation, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "auth.GetEnterpriseInstallation", telemetry.AttrEnterpriseSlug.String(enterpriseSlug))
+	defer func() { telemetry.End(span, err) }()
+
+	return ts.getInstallation(ctx, jwt, fmt.Sprintf("/enterprises/%s/installation", url.PathEscape(enterpriseSlug)))
+}
 
-	req, err := http.NewRequest("POST", url, nil)
+// getInstallation fetches a single installation from path, mapping 404 to ErrInstallationNotFound
+func (ts *TokenService) getInstallation(ctx context.Context, jwt string, path string) (*Installation, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", ts.baseURL+path, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -173,107 +214,125 @@ func (ts *TokenService) CreateInstallationToken(jwt string, installationID int64
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", jwt))
 	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := ts.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create installation token: %w", err)
+		return nil, fmt.Errorf("failed to get installation: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusCreated {
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrInstallationNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
 	}
 
-	var token InstallationToken
-	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
-		return nil, fmt.Errorf("failed to decode installation token response: %w", err)
+	var installation Installation
+	if err := json.NewDecoder(resp.Body).Decode(&installation); err != nil {
+		return nil, fmt.Errorf("failed to decode installation response: %w", err)
 	}
 
-	return &token, nil
+	return &installation, nil
 }
 
-// GetInstallationToken is a convenience method that creates a JWT and exchanges it for an installation token
-func (ts *TokenService) GetInstallationToken(tokenType string) (InstallationTokenInfo, error) {
-	// Create JWT
-	jwt, err := ts.CreateJWT()
+// GetApp retrieves the authenticated GitHub App, including its client ID
+func (ts *TokenService) GetApp(ctx context.Context, jwt string) (_ *App, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "auth.GetApp")
+	defer func() { telemetry.End(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", ts.baseURL+"/app", nil)
 	if err != nil {
-		return InstallationTokenInfo{}, fmt.Errorf("failed to create JWT: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	// Get installations
-	installations, err := ts.GetInstallations(jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", jwt))
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+	resp, err := ts.client.Do(req)
 	if err != nil {
-		return InstallationTokenInfo{}, fmt.Errorf("failed to get installations: %w", err)
+		return nil, fmt.Errorf("failed to get app: %w", err)
 	}
+	defer resp.Body.Close()
 
-	if len(installations) == 0 {
-		return InstallationTokenInfo{}, fmt.Errorf("no installations found for this GitHub App")
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
 	}
 
-	// Use the installation with token_type "Enterprise"
-	var installationID int64
-	var clientID string
-
-	for _, installation := range installations {
-		if installation.TargetType == tokenType {
-			installationID = installation.ID
-			clientID = installation.ClientID
-			break
-		}
+	var app App
+	if err := json.NewDecoder(resp.Body).Decode(&app); err != nil {
+		return nil, fmt.Errorf("failed to decode app response: %w", err)
 	}
 
-	if installationID == 0 { // Changed from "" to 0
-		return InstallationTokenInfo{}, fmt.Errorf("no suitable installation found for this GitHub App")
+	return &app, nil
+}
+
+// CreateInstallationToken creates an installation access token
+func (ts *TokenService) CreateInstallationToken(ctx context.Context, jwt string, installationID int64) (_ *InstallationToken, err error) {
+	ctx, span := telemetry.StartSpan(ctx, "auth.CreateInstallationToken",
+		telemetry.AttrInstallationID.Int64(installationID))
+	defer func() { telemetry.End(span, err) }()
+
+	tokenURL := fmt.Sprintf("%s/app/installations/%d/access_tokens", ts.baseURL, installationID)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
-	// Create installation token
-	token, err := ts.CreateInstallationToken(jwt, installationID)
+
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", jwt))
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+	resp, err := ts.client.Do(req)
 	if err != nil {
-		return InstallationTokenInfo{}, fmt.Errorf("failed to create installation token: %w", err)
+		return nil, fmt.Errorf("failed to create installation token: %w", err)
 	}
+	defer resp.Body.Close()
 
-	installationToken := InstallationTokenInfo{
-		Token:     token.Token,
-		ExpiresAt: token.ExpiresAt.Format(time.RFC3339),
-		ClientID:  clientID,
-		AppID:     fmt.Sprintf("%d", installationID), // Convert int64 to string
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrInstallationNotFound
+	}
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
 	}
 
-	return installationToken, nil
+	var token InstallationToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("failed to decode installation token response: %w", err)
+	}
+
+	return &token, nil
 }
 
-// GetInstallationTokenForOrg gets an installation token for a specific organization
-func (ts *TokenService) GetInstallationTokenForOrg(orgLogin string) (string, error) {
-	// Create JWT
-	jwt, err := ts.CreateJWT()
-	if err != nil {
-		return "", fmt.Errorf("failed to create JWT: %w", err)
-	}
+// RevokeInstallationToken revokes an installation token so it cannot be used after the run.
+// Tokens that have already expired or been revoked are treated as revoked.
+func (ts *TokenService) RevokeInstallationToken(ctx context.Context, token string) (err error) {
+	ctx, span := telemetry.StartSpan(ctx, "auth.RevokeInstallationToken")
+	defer func() { telemetry.End(span, err) }()
 
-	// Get installations
-	installations, err := ts.GetInstallations(jwt)
+	req, err := http.NewRequestWithContext(ctx, "DELETE", ts.baseURL+"/installation/token", nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to get installations: %w", err)
+		return fmt.Errorf("failed to create request: %w", err)
 	}
 
-	// Find the installation for the specified organization
-	var installationID int64 // Changed from string to int64
-	for _, installation := range installations {
-		if installation.Account.Login == orgLogin {
-			installationID = installation.ID
-			break
-		}
-	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
 
-	if installationID == 0 { // Changed from "" to 0
-		return "", fmt.Errorf("no installation found for organization: %s", orgLogin)
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to revoke installation token: %w", err)
 	}
+	defer resp.Body.Close()
 
-	// Create installation token
-	token, err := ts.CreateInstallationToken(jwt, installationID)
-	if err != nil {
-		return "", fmt.Errorf("failed to create installation token: %w", err)
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusUnauthorized {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
 	}
 
-	return token.Token, nil
+	return nil
 }
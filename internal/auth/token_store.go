@@ -0,0 +1,139 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// tokenStoreVersion is bumped whenever the persisted format changes; older files are ignored
+const tokenStoreVersion = 2
+
+// persistedTokens is the plaintext content of the on-disk token cache
+type persistedTokens struct {
+	Version                int                            `json:"version"`
+	AppID                  string                         `json:"app_id"`
+	ClientID               string                         `json:"client_id,omitempty"`
+	EnterpriseSlug         string                         `json:"enterprise_slug,omitempty"`
+	EnterpriseInstallation *cachedInstallation            `json:"enterprise_installation,omitempty"`
+	OrgInstallations       map[string]*cachedInstallation `json:"org_installations,omitempty"`
+	Tokens                 map[int64]*InstallationToken   `json:"tokens,omitempty"`
+}
+
+// TokenStore persists the token manager's state to an AES-GCM encrypted file so that
+// back-to-back commands can reuse installation tokens instead of minting new ones
+type TokenStore struct {
+	path string
+	aead cipher.AEAD
+}
+
+// DefaultTokenCachePath returns the per-user cache file for an app and GitHub host
+func DefaultTokenCachePath(appID string, baseURL string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate user cache directory: %w", err)
+	}
+	host := sha256.Sum256([]byte(baseURL))
+	return filepath.Join(dir, "ghas-lab-builder", fmt.Sprintf("tokens-%s-%s.bin", appID, hex.EncodeToString(host[:4]))), nil
+}
+
+// NewTokenStore creates a store at path encrypted with a key derived from the service's private key
+func NewTokenStore(path string, service *TokenService) (*TokenStore, error) {
+	block, err := aes.NewCipher(service.cacheSecret())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token cache cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token cache cipher: %w", err)
+	}
+	return &TokenStore{path: path, aead: aead}, nil
+}
+
+// Path returns the location of the cache file
+func (s *TokenStore) Path() string {
+	return s.path
+}
+
+// load reads and decrypts the cache. A missing file returns an empty state and no error.
+func (s *TokenStore) load() (*persistedTokens, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &persistedTokens{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token cache: %w", err)
+	}
+
+	nonceSize := s.aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("token cache is corrupt")
+	}
+	plaintext, err := s.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		// Usually means the cache was written with a different private key
+		return nil, fmt.Errorf("failed to decrypt token cache: %w", err)
+	}
+
+	var state persistedTokens
+	if err := json.Unmarshal(plaintext, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse token cache: %w", err)
+	}
+	if state.Version != tokenStoreVersion {
+		return &persistedTokens{}, nil
+	}
+
+	return &state, nil
+}
+
+// save encrypts and atomically replaces the cache file, readable only by the current user
+func (s *TokenStore) save(state *persistedTokens) error {
+	state.Version = tokenStoreVersion
+
+	plaintext, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode token cache: %w", err)
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("failed to generate token cache nonce: %w", err)
+	}
+	ciphertext := s.aead.Seal(nonce, nonce, plaintext, nil)
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create token cache directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".tokens-*")
+	if err != nil {
+		return fmt.Errorf("failed to write token cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write token cache: %w", err)
+	}
+	if _, err := tmp.Write(ciphertext); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write token cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write token cache: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write token cache: %w", err)
+	}
+
+	return nil
+}
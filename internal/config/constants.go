@@ -3,17 +3,29 @@ package config
 type contextKey string
 
 const (
-	TokenKey          contextKey = "token"
-	AppIDKey          contextKey = "app-id"
-	PrivateKeyKey     contextKey = "private-key"
-	BaseURLKey        contextKey = "base-url"
-	EnterpriseSlugKey contextKey = "enterprise-slug"
-	LabDateKey        contextKey = "lab-date"
-	FacilitatorsKey   contextKey = "facilitators"
-	LoggerKey         contextKey = "logger"
-	OrgKey            contextKey = "org"
+	TokenKey               contextKey = "token"
+	AppIDKey               contextKey = "app-id"
+	PrivateKeyKey          contextKey = "private-key"
+	BaseURLKey             contextKey = "base-url"
+	EnterpriseSlugKey      contextKey = "enterprise-slug"
+	LabDateKey             contextKey = "lab-date"
+	FacilitatorsKey        contextKey = "facilitators"
+	ObserversKey           contextKey = "observers"
+	LoggerKey              contextKey = "logger"
+	OrgKey                 contextKey = "org"
+	ProgressKey            contextKey = "progress"
+	MaxBodyLogBytesKey     contextKey = "max-body-log-bytes"
+	AppPoolKey             contextKey = "app-pool"
+	HostKey                contextKey = "host"
+	TransportKey           contextKey = "transport"
+	EMUShortcodeKey        contextKey = "emu-shortcode"
+	SCIMURLKey             contextKey = "scim-url"
+	AllowLicenseOverageKey contextKey = "allow-license-overage"
 )
 
+// ProgressAnnotation marks commands that render the live terminal progress view
+const ProgressAnnotation string = "ghas-lab-builder/progress"
+
 const (
 	DefaultBaseURL   string = "https://api.github.com"
 	EnterpriseType   string = "Enterprise"
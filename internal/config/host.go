@@ -0,0 +1,226 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// HostType identifies the kind of GitHub deployment being targeted
+type HostType string
+
+const (
+	// HostDotcom is GitHub Enterprise Cloud on github.com
+	HostDotcom HostType = "dotcom"
+	// HostGHECom is GitHub Enterprise Cloud with data residency on a <subdomain>.ghe.com host
+	HostGHECom HostType = "ghe.com"
+	// HostGHES is a self-hosted GitHub Enterprise Server
+	HostGHES HostType = "ghes"
+)
+
+// MinGHESVersion is the oldest GitHub Enterprise Server release supported, the first to accept
+// the X-GitHub-Api-Version header this tool sends
+const MinGHESVersion = "3.9"
+
+// Feature is a capability that is not available on every host type
+type Feature string
+
+const (
+	// FeatureEnterpriseOrgCreation is creating organizations with the createEnterpriseOrganization mutation
+	FeatureEnterpriseOrgCreation Feature = "creating organizations in the enterprise"
+	// FeatureEnterpriseOrgRemoval is deleting organizations with the removeEnterpriseOrganization mutation
+	FeatureEnterpriseOrgRemoval Feature = "removing organizations from the enterprise"
+	// FeatureEnterpriseAppInstallation is installing GitHub Apps on organizations through the enterprise installation
+	FeatureEnterpriseAppInstallation Feature = "installing GitHub Apps on organizations through the enterprise"
+	// FeatureManagedUserProvisioning is provisioning Enterprise Managed Users through the enterprise SCIM API
+	FeatureManagedUserProvisioning Feature = "provisioning Enterprise Managed Users"
+	// FeatureLicenseReporting is reading consumed seats and GHAS committers from the enterprise billing API
+	FeatureLicenseReporting Feature = "reading enterprise license usage"
+)
+
+// Host describes the GitHub deployment and derives its REST and GraphQL API roots
+type Host struct {
+	Type     HostType
+	Hostname string
+	// Version is the GHES release (e.g. "3.14"); empty when unknown or not GHES
+	Version string
+
+	restURL string
+}
+
+// ParseHost builds a Host from a hostname such as github.com, octocorp.ghe.com or github.example.com.
+// hostType may be empty to infer it from the hostname.
+func ParseHost(hostname string, hostType string, version string) (*Host, error) {
+	hostname = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(hostname, "https://"), "http://"), "/")
+	if hostname == "" {
+		return nil, fmt.Errorf("host is empty")
+	}
+
+	inferred := HostGHES
+	switch {
+	case hostname == "github.com":
+		inferred = HostDotcom
+	case strings.HasSuffix(hostname, ".ghe.com"):
+		inferred = HostGHECom
+	}
+
+	if hostType == "" {
+		hostType = string(inferred)
+	}
+
+	host := &Host{Type: HostType(hostType), Hostname: hostname, Version: version}
+	switch host.Type {
+	case HostDotcom:
+		if hostname != "github.com" {
+			return nil, fmt.Errorf("host type %s requires --host github.com, got %s", HostDotcom, hostname)
+		}
+		host.restURL = DefaultBaseURL
+	case HostGHECom:
+		if !strings.HasSuffix(hostname, ".ghe.com") {
+			return nil, fmt.Errorf("host type %s requires a <subdomain>.ghe.com host, got %s", HostGHECom, hostname)
+		}
+		host.restURL = "https://api." + hostname
+	case HostGHES:
+		host.restURL = "https://" + hostname + "/api/v3"
+	default:
+		return nil, fmt.Errorf("unknown host type %q (expected %s, %s or %s)", hostType, HostDotcom, HostGHECom, HostGHES)
+	}
+
+	if err := host.validate(); err != nil {
+		return nil, err
+	}
+	return host, nil
+}
+
+// HostFromBaseURL builds a Host from an explicit REST API root such as https://api.github.com or
+// https://github.example.com/api/v3. The root is used as given. Roots that match no known layout,
+// such as a local stand-in server, are treated like github.com unless hostType says otherwise.
+func HostFromBaseURL(baseURL string, hostType string, version string) (*Host, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid --base-url %q: expected an absolute URL such as %s", baseURL, DefaultBaseURL)
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	inferred := HostDotcom
+	hostname := u.Host
+	switch {
+	case u.Host == "api.github.com":
+		hostname = "github.com"
+	case strings.HasPrefix(u.Host, "api.") && strings.HasSuffix(u.Host, ".ghe.com"):
+		inferred = HostGHECom
+		hostname = strings.TrimPrefix(u.Host, "api.")
+	case strings.HasSuffix(u.Path, "/api/v3") || strings.HasSuffix(u.Path, "/api/v3/"):
+		inferred = HostGHES
+	}
+
+	if hostType == "" {
+		hostType = string(inferred)
+	}
+
+	host := &Host{Type: HostType(hostType), Hostname: hostname, Version: version, restURL: baseURL}
+	switch host.Type {
+	case HostDotcom, HostGHECom, HostGHES:
+	default:
+		return nil, fmt.Errorf("unknown host type %q (expected %s, %s or %s)", hostType, HostDotcom, HostGHECom, HostGHES)
+	}
+
+	if err := host.validate(); err != nil {
+		return nil, err
+	}
+	return host, nil
+}
+
+func (h *Host) validate() error {
+	if h.Version == "" {
+		return nil
+	}
+	if h.Type != HostGHES {
+		return fmt.Errorf("--ghes-version only applies to GitHub Enterprise Server hosts")
+	}
+	if _, _, err := parseVersion(h.Version); err != nil {
+		return err
+	}
+	if !h.atLeast(MinGHESVersion) {
+		return fmt.Errorf("GitHub Enterprise Server %s is not supported; version %s or later is required", h.Version, MinGHESVersion)
+	}
+	return nil
+}
+
+// RESTURL returns the REST API root, without a trailing slash
+func (h *Host) RESTURL() string {
+	return h.restURL
+}
+
+// GraphQLURL returns the GraphQL endpoint: /graphql under the API host on GitHub Enterprise Cloud,
+// and /api/graphql on GitHub Enterprise Server
+func (h *Host) GraphQLURL() string {
+	if h.Type == HostGHES {
+		return strings.TrimSuffix(h.restURL, "/api/v3") + "/api/graphql"
+	}
+	return h.restURL + "/graphql"
+}
+
+// WebURL returns the root of the host's web pages and git remotes, without a trailing slash. A stand-in
+// server at another root serves both from its REST root.
+func (h *Host) WebURL() string {
+	switch {
+	case h.Type == HostGHES:
+		return strings.TrimSuffix(h.restURL, "/api/v3")
+	case strings.HasPrefix(h.restURL, "https://api."):
+		return "https://" + h.Hostname
+	default:
+		return h.restURL
+	}
+}
+
+// Supports returns nil if the feature is available on this host, or an error explaining why not
+func (h *Host) Supports(feature Feature) error {
+	if h.Type == HostGHES {
+		switch feature {
+		case FeatureEnterpriseOrgCreation, FeatureEnterpriseOrgRemoval, FeatureEnterpriseAppInstallation, FeatureManagedUserProvisioning,
+			FeatureLicenseReporting:
+			return fmt.Errorf("%s is not available on GitHub Enterprise Server", feature)
+		}
+	}
+	return nil
+}
+
+// String describes the host for logs and reports
+func (h *Host) String() string {
+	if h.Type == HostGHES && h.Version != "" {
+		return fmt.Sprintf("%s (GHES %s)", h.Hostname, h.Version)
+	}
+	return fmt.Sprintf("%s (%s)", h.Hostname, h.Type)
+}
+
+// atLeast reports whether the host's version is at least min; an unknown version is assumed current
+func (h *Host) atLeast(min string) bool {
+	if h.Version == "" {
+		return true
+	}
+	major, minor, err := parseVersion(h.Version)
+	if err != nil {
+		return true
+	}
+	minMajor, minMinor, _ := parseVersion(min)
+	return major > minMajor || (major == minMajor && minor >= minMinor)
+}
+
+// parseVersion parses a "major.minor" or "major.minor.patch" version
+func parseVersion(version string) (int, int, error) {
+	parts := strings.Split(version, ".")
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("invalid GHES version %q: expected major.minor, e.g. 3.14", version)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid GHES version %q: expected major.minor, e.g. 3.14", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid GHES version %q: expected major.minor, e.g. 3.14", version)
+	}
+	return major, minor, nil
+}
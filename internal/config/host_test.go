@@ -0,0 +1,109 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseHost(t *testing.T) {
+	tests := []struct {
+		name     string
+		hostname string
+		hostType string
+		version  string
+		wantType HostType
+		rest     string
+		graphql  string
+		web      string
+		wantErr  string
+	}{
+		{"dotcom", "github.com", "", "", HostDotcom, "https://api.github.com", "https://api.github.com/graphql", "https://github.com", ""},
+		{"dotcom with scheme", "https://github.com/", "", "", HostDotcom, "https://api.github.com", "https://api.github.com/graphql", "https://github.com", ""},
+		{"data residency", "octocorp.ghe.com", "", "", HostGHECom, "https://api.octocorp.ghe.com", "https://api.octocorp.ghe.com/graphql", "https://octocorp.ghe.com", ""},
+		{"server", "github.example.com", "", "3.14", HostGHES, "https://github.example.com/api/v3", "https://github.example.com/api/graphql", "https://github.example.com", ""},
+		{"server named explicitly", "octocorp.ghe.com", "ghes", "", HostGHES, "https://octocorp.ghe.com/api/v3", "https://octocorp.ghe.com/api/graphql", "https://octocorp.ghe.com", ""},
+		{"empty", "", "", "", "", "", "", "", "host is empty"},
+		{"dotcom type on another host", "github.example.com", "dotcom", "", "", "", "", "", "requires --host github.com"},
+		{"ghe.com type on another host", "github.example.com", "ghe.com", "", "", "", "", "", "<subdomain>.ghe.com"},
+		{"unknown type", "github.com", "cloud", "", "", "", "", "", "unknown host type"},
+		{"version on dotcom", "github.com", "", "3.14", "", "", "", "", "only applies to GitHub Enterprise Server"},
+		{"unsupported server", "github.example.com", "", "3.8", "", "", "", "", "is not supported"},
+		{"invalid version", "github.example.com", "", "three", "", "", "", "", "invalid GHES version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, err := ParseHost(tt.hostname, tt.hostType, tt.version)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if host.Type != tt.wantType || host.RESTURL() != tt.rest || host.GraphQLURL() != tt.graphql || host.WebURL() != tt.web {
+				t.Errorf("got %s %s %s %s, want %s %s %s %s",
+					host.Type, host.RESTURL(), host.GraphQLURL(), host.WebURL(), tt.wantType, tt.rest, tt.graphql, tt.web)
+			}
+		})
+	}
+}
+
+func TestHostFromBaseURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		baseURL  string
+		hostType string
+		wantType HostType
+		hostname string
+		graphql  string
+		web      string
+		wantErr  string
+	}{
+		{"dotcom", "https://api.github.com", "", HostDotcom, "github.com", "https://api.github.com/graphql", "https://github.com", ""},
+		{"trailing slash", "https://api.github.com/", "", HostDotcom, "github.com", "https://api.github.com/graphql", "https://github.com", ""},
+		{"data residency", "https://api.octocorp.ghe.com", "", HostGHECom, "octocorp.ghe.com", "https://api.octocorp.ghe.com/graphql", "https://octocorp.ghe.com", ""},
+		{"server", "https://github.example.com/api/v3", "", HostGHES, "github.example.com", "https://github.example.com/api/graphql", "https://github.example.com", ""},
+		{"server with trailing slash", "https://github.example.com/api/v3/", "", HostGHES, "github.example.com", "https://github.example.com/api/graphql", "https://github.example.com", ""},
+		{"stand-in server", "http://127.0.0.1:8080", "", HostDotcom, "127.0.0.1:8080", "http://127.0.0.1:8080/graphql", "http://127.0.0.1:8080", ""},
+		{"stand-in server as ghes", "http://127.0.0.1:8080/api/v3", "ghes", HostGHES, "127.0.0.1:8080", "http://127.0.0.1:8080/api/graphql", "http://127.0.0.1:8080", ""},
+		{"relative", "api.github.com", "", "", "", "", "", "invalid --base-url"},
+		{"unknown type", "https://api.github.com", "cloud", "", "", "", "", "unknown host type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, err := HostFromBaseURL(tt.baseURL, tt.hostType, "")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if host.Type != tt.wantType || host.Hostname != tt.hostname || host.GraphQLURL() != tt.graphql || host.WebURL() != tt.web {
+				t.Errorf("got %s %s %s %s, want %s %s %s %s",
+					host.Type, host.Hostname, host.GraphQLURL(), host.WebURL(), tt.wantType, tt.hostname, tt.graphql, tt.web)
+			}
+			if want := strings.TrimSuffix(tt.baseURL, "/"); host.RESTURL() != want {
+				t.Errorf("REST URL %s, want %s", host.RESTURL(), want)
+			}
+		})
+	}
+}
+
+func TestHostSupports(t *testing.T) {
+	dotcom, _ := ParseHost("github.com", "", "")
+	server, _ := ParseHost("github.example.com", "", "")
+
+	for _, feature := range []Feature{FeatureEnterpriseOrgCreation, FeatureEnterpriseOrgRemoval, FeatureEnterpriseAppInstallation, FeatureManagedUserProvisioning, FeatureLicenseReporting} {
+		if err := dotcom.Supports(feature); err != nil {
+			t.Errorf("github.com does not support %s: %v", feature, err)
+		}
+		if err := server.Supports(feature); err == nil {
+			t.Errorf("GitHub Enterprise Server supports %s", feature)
+		}
+	}
+}
@@ -1,14 +1,22 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
-	"sync"
+	"strings"
 	"time"
 
 	"github.com/s-samadi/ghas-lab-builder/internal/auth"
 	"github.com/s-samadi/ghas-lab-builder/internal/config"
+	"github.com/s-samadi/ghas-lab-builder/internal/telemetry"
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // AuthProvider fetches an Authorization header value (e.g. "Bearer <token>") for a request.
@@ -34,21 +42,10 @@ type Options struct {
 	// Maximum number of bytes to log for request and response bodies.
 	// Set to 0 to disable body logging.
 	MaxBodyLogBytes int64
-}
-
-// tokenCache holds cached tokens by target type
-type tokenCache struct {
-	sync.RWMutex
-	tokens map[string]cachedToken
-}
 
-type cachedToken struct {
-	token   string
-	expires time.Time
-}
-
-var globalTokenCache = &tokenCache{
-	tokens: make(map[string]cachedToken),
+	// Optional function called with every response, e.g. to track rate limits.
+	// The request passed is the one sent, including the injected headers.
+	OnResponse func(req *http.Request, resp *http.Response)
 }
 
 // CustomRoundTripper implements http.RoundTripper
@@ -58,6 +55,7 @@ type CustomRoundTripper struct {
 	authProvider    AuthProvider
 	logger          *slog.Logger
 	maxBodyLogBytes int64
+	onResponse      func(req *http.Request, resp *http.Response)
 }
 
 // NewCustomRoundTripper constructs a CustomRoundTripper with sane defaults.
@@ -84,26 +82,50 @@ func NewCustomRoundTripper(opts Options) *CustomRoundTripper {
 		authProvider:    opts.AuthProvider,
 		logger:          logger,
 		maxBodyLogBytes: opts.MaxBodyLogBytes,
+		onResponse:      opts.OnResponse,
 	}
 }
 
 // RoundTrip implements the http.RoundTripper interface.
-func (c *CustomRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+func (c *CustomRoundTripper) RoundTrip(req *http.Request) (resp *http.Response, err error) {
 	start := time.Now()
 
+	// Trace every GitHub call; token acquisition by the auth provider becomes a child span
+	ctx, span := telemetry.Tracer().Start(req.Context(), "HTTP "+req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.full", util.Redact(req.URL.String())),
+		))
+	if org, ok := ctx.Value(config.OrgKey).(string); ok && org != "" {
+		span.SetAttributes(telemetry.AttrOrg.String(org))
+	}
+	defer func() {
+		if resp != nil {
+			span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+			if resp.StatusCode >= 400 && err == nil {
+				span.SetStatus(codes.Error, resp.Status)
+			}
+		}
+		telemetry.End(span, err)
+	}()
+
 	// Create a shallow clone of request to avoid mutating caller's request headers/body
-	req2 := req.Clone(req.Context())
+	req2 := req.Clone(ctx)
 
 	// Inject static headers (e.g., GitHub headers)
 	for k, v := range c.staticHeaders {
 		req2.Header.Set(k, v)
 	}
 
+	// Never log credentials, even if the logger was not created with util.NewLogger
+	logURL := util.Redact(req2.URL.String())
+
 	// Inject auth header if provider present
 	if c.authProvider != nil {
 		val, err := c.authProvider(req2)
 		if err != nil {
-			c.logger.Error("auth provider error", slog.String("method", req2.Method), slog.String("url", req2.URL.String()), slog.Any("error", err))
+			c.logger.Error("auth provider error", slog.String("method", req2.Method), slog.String("url", logURL), slog.String("error", util.Redact(err.Error())))
 			return nil, err
 		}
 		if val != "" {
@@ -111,35 +133,83 @@ func (c *CustomRoundTripper) RoundTrip(req *http.Request) (*http.Response, error
 		}
 	}
 
-	c.logger.Info("HTTP Request",
+	requestAttrs := []any{
 		slog.String("method", req2.Method),
-		slog.String("url", req2.URL.String()),
-	)
+		slog.String("url", logURL),
+	}
+	if c.maxBodyLogBytes > 0 && req2.Body != nil && req2.Body != http.NoBody {
+		body, err := io.ReadAll(req2.Body)
+		req2.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req2.Body = io.NopCloser(bytes.NewReader(body))
+		requestAttrs = append(requestAttrs, slog.String("request_body", truncateBody(body, c.maxBodyLogBytes)))
+	}
+	c.logger.Info("HTTP Request", requestAttrs...)
 
 	// Perform the actual request
-	resp, err := c.base.RoundTrip(req2)
+	resp, err = c.base.RoundTrip(req2)
 	duration := time.Since(start)
 
 	if err != nil {
 		c.logger.Error("HTTP Error",
 			slog.String("method", req2.Method),
-			slog.String("url", req2.URL.String()),
-			slog.Any("error", err),
+			slog.String("url", logURL),
+			slog.String("error", util.Redact(err.Error())),
 			slog.Duration("took", duration),
 		)
 		return nil, err
 	}
 
-	c.logger.Info("HTTP Response",
+	if c.onResponse != nil {
+		c.onResponse(req2, resp)
+	}
+
+	responseAttrs := []any{
 		slog.Int("status", resp.StatusCode),
 		slog.String("method", req2.Method),
-		slog.String("url", req2.URL.String()),
+		slog.String("url", logURL),
 		slog.Duration("took", duration),
-	)
+	}
+	if c.maxBodyLogBytes > 0 && resp.Body != nil {
+		// Buffer the body so it can be logged and still be read by the caller
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		responseAttrs = append(responseAttrs, slog.String("response_body", truncateBody(body, c.maxBodyLogBytes)))
+	}
+	c.logger.Info("HTTP Response", responseAttrs...)
 
 	return resp, nil
 }
 
+// truncateBody returns at most limit bytes of body for logging, noting how much was cut off.
+// Secrets are redacted before truncating so a token split at the limit cannot leak partially.
+func truncateBody(body []byte, limit int64) string {
+	redacted := util.Redact(string(body))
+	if int64(len(redacted)) <= limit {
+		return redacted
+	}
+	return redacted[:limit] + fmt.Sprintf("... (%d bytes truncated)", int64(len(redacted))-limit)
+}
+
+// AccessToken returns the token that calls for the organization or the enterprise are made with, for
+// clients other than the API, such as git: the Personal Access Token, or an installation token of a pooled app
+func AccessToken(ctx context.Context, targetType string, orgLogin string) (string, error) {
+	if token, ok := ctx.Value(config.TokenKey).(string); ok && token != "" {
+		return token, nil
+	}
+	pool, _ := ctx.Value(config.AppPoolKey).(*auth.AppPool)
+	if pool == nil {
+		return "", fmt.Errorf("no GitHub credentials configured: provide --token or GitHub App credentials")
+	}
+	return pool.TokenFor(ctx, targetType, orgLogin)
+}
+
 // Helper for simple API: create a transport that injects GitHub headers and acquires token automatically
 // Accepts a context with app credentials or PAT token, logger, and installation target type.
 func NewGithubStyleTransport(ctx context.Context, logger *slog.Logger, targetType string) *CustomRoundTripper {
@@ -148,6 +218,8 @@ func NewGithubStyleTransport(ctx context.Context, logger *slog.Logger, targetTyp
 		"X-GitHub-Api-Version": "2022-11-28",
 	}
 
+	pool, _ := ctx.Value(config.AppPoolKey).(*auth.AppPool)
+
 	authProv := func(req *http.Request) (string, error) {
 		// Check if using PAT token
 		if token, ok := ctx.Value(config.TokenKey).(string); ok && token != "" {
@@ -155,79 +227,37 @@ func NewGithubStyleTransport(ctx context.Context, logger *slog.Logger, targetTyp
 			return "Bearer " + token, nil
 		}
 
-		// Using GitHub App authentication
-		// Build cache key based on target type and organization
-		cacheKey := targetType
-		if targetType == config.OrganizationType {
-			if orgName, ok := ctx.Value(config.OrgKey).(string); ok && orgName != "" {
-				cacheKey = targetType + ":" + orgName
-			}
-		}
-
-		// Check if we have a cached token for this cache key
-		globalTokenCache.RLock()
-		if cached, ok := globalTokenCache.tokens[cacheKey]; ok && time.Now().Before(cached.expires) {
-			token := cached.token
-			globalTokenCache.RUnlock()
-			return "Bearer " + token, nil
+		// Using GitHub App authentication; the pool shares tokens across all workers and
+		// routes each request to the app with the most rate limit remaining
+		if pool == nil {
+			return "", fmt.Errorf("no GitHub credentials configured: provide --token or GitHub App credentials")
 		}
-		globalTokenCache.RUnlock()
-
-		// Need to get a new token
-		globalTokenCache.Lock()
-		defer globalTokenCache.Unlock()
 
-		// Double-check after acquiring write lock to deal with race condition
-		if cached, ok := globalTokenCache.tokens[cacheKey]; ok && time.Now().Before(cached.expires) {
-			return "Bearer " + cached.token, nil
+		orgName, _ := ctx.Value(config.OrgKey).(string)
+		tokenStr, err := pool.TokenFor(req.Context(), targetType, orgName)
+		if err != nil {
+			return "", err
 		}
 
-		// Acquire new installation token for this target type
-		ts := auth.NewTokenService(
-			ctx.Value(config.AppIDKey).(string),
-			ctx.Value(config.PrivateKeyKey).(string), // Changed from PrivateKeyPathKey
-			ctx.Value(config.BaseURLKey).(string),
-		)
+		return "Bearer " + tokenStr, nil
+	}
 
-		var tokenStr string
-		var err error
-
-		// For organization-specific requests, get org-specific token
-		if targetType == config.OrganizationType {
-			if orgName, ok := ctx.Value(config.OrgKey).(string); ok && orgName != "" {
-				tokenStr, err = ts.GetInstallationTokenForOrg(orgName)
-				if err != nil {
-					return "", err
-				}
-			} else {
-				// Fall back to general installation token
-				token, err := ts.GetInstallationToken(targetType)
-				if err != nil {
-					return "", err
-				}
-				tokenStr = token.Token
-			}
-		} else {
-			token, err := ts.GetInstallationToken(targetType)
-			if err != nil {
-				return "", err
-			}
-			tokenStr = token.Token
-		}
+	// Body logging is opt-in via --log-http-bodies
+	maxBodyLogBytes, _ := ctx.Value(config.MaxBodyLogBytesKey).(int64)
 
-		// Cache the token for 55 minutes
-		globalTokenCache.tokens[cacheKey] = cachedToken{
-			token:   tokenStr,
-			expires: time.Now().Add(55 * time.Minute),
+	var onResponse func(req *http.Request, resp *http.Response)
+	if pool != nil {
+		onResponse = func(req *http.Request, resp *http.Response) {
+			pool.RecordRateLimit(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "), resp.Header)
 		}
-
-		return "Bearer " + tokenStr, nil
 	}
 
 	return NewCustomRoundTripper(Options{
-		Base:          http.DefaultTransport,
-		StaticHeaders: static,
-		AuthProvider:  authProv,
-		Logger:        logger,
+		Base:            baseTransport(ctx),
+		StaticHeaders:   static,
+		AuthProvider:    authProv,
+		Logger:          logger,
+		MaxBodyLogBytes: maxBodyLogBytes,
+		OnResponse:      onResponse,
 	})
 }
@@ -25,8 +25,7 @@ func GetEnterprise(ctx context.Context, logger *slog.Logger, enterpriseSlug stri
 		Transport: rt,
 	}
 
-	baseURL := ctx.Value(config.BaseURLKey).(string)
-	graphqlURL := baseURL + "/graphql"
+	graphqlURL := graphQLURL(ctx)
 
 	// GraphQL query to fetch enterprise by slug
 	query := `
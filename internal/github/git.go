@@ -0,0 +1,247 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+)
+
+// GitCommit is a commit of the Git database of a repository
+type GitCommit struct {
+	SHA  string `json:"sha"`
+	Tree struct {
+		SHA string `json:"sha"`
+	} `json:"tree"`
+}
+
+// TreeEntry is a file, directory or submodule of a Git tree
+type TreeEntry struct {
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+	// Type is blob, tree or commit (a submodule)
+	Type string `json:"type"`
+	SHA  string `json:"sha"`
+}
+
+// ResolveRef returns the commit a branch, tag or commit SHA of a repository given as owner/repo points to
+func ResolveRef(ctx context.Context, logger *slog.Logger, fullName string, ref string) (string, error) {
+	logger.Info("Resolving ref", slog.String("repo", fullName), slog.String("ref", ref))
+
+	var commit struct {
+		SHA string `json:"sha"`
+	}
+	if err := getRepoResource(ctx, logger, fullName, "commits/"+ref, &commit); err != nil {
+		return "", fmt.Errorf("failed to resolve %s of %s: %w", ref, fullName, err)
+	}
+	return commit.SHA, nil
+}
+
+// GetGitCommit returns a commit of a repository given as owner/repo
+func GetGitCommit(ctx context.Context, logger *slog.Logger, fullName string, sha string) (*GitCommit, error) {
+	var commit GitCommit
+	if err := getRepoResource(ctx, logger, fullName, "git/commits/"+sha, &commit); err != nil {
+		return nil, fmt.Errorf("failed to get commit %s of %s: %w", sha, fullName, err)
+	}
+	return &commit, nil
+}
+
+// GetTree lists every entry of a tree of a repository given as owner/repo, including those of its subtrees
+func GetTree(ctx context.Context, logger *slog.Logger, fullName string, treeSHA string) ([]TreeEntry, error) {
+	var tree struct {
+		Tree      []TreeEntry `json:"tree"`
+		Truncated bool        `json:"truncated"`
+	}
+	if err := getRepoResource(ctx, logger, fullName, "git/trees/"+treeSHA+"?recursive=1", &tree); err != nil {
+		return nil, fmt.Errorf("failed to get tree %s of %s: %w", treeSHA, fullName, err)
+	}
+	if tree.Truncated {
+		return nil, fmt.Errorf("tree %s of %s has too many entries to be listed", treeSHA, fullName)
+	}
+	return tree.Tree, nil
+}
+
+// GetBlob returns the base64 encoded content of a blob of a repository given as owner/repo
+func GetBlob(ctx context.Context, logger *slog.Logger, fullName string, sha string) (string, error) {
+	var blob struct {
+		Content  string `json:"content"`
+		Encoding string `json:"encoding"`
+	}
+	if err := getRepoResource(ctx, logger, fullName, "git/blobs/"+sha, &blob); err != nil {
+		return "", fmt.Errorf("failed to get blob %s of %s: %w", sha, fullName, err)
+	}
+	if blob.Encoding != "base64" {
+		return "", fmt.Errorf("blob %s of %s has unexpected encoding %q", sha, fullName, blob.Encoding)
+	}
+	return blob.Content, nil
+}
+
+// getRepoResource fetches a resource of a repository given as owner/repo, with credentials for its owner
+func getRepoResource(ctx context.Context, logger *slog.Logger, fullName string, path string, out any) error {
+	owner, repoName, ok := strings.Cut(fullName, "/")
+	if !ok || owner == "" || repoName == "" {
+		return fmt.Errorf("invalid repository format, expected 'owner/repo', got: %s", fullName)
+	}
+
+	ctx = context.WithValue(ctx, config.OrgKey, owner)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/%s", baseURL, owner, repoName, path)
+
+	rt := NewGithubStyleTransport(ctx, logger, config.OrganizationType)
+	client := &http.Client{
+		Transport: rt,
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		logger.Error("Failed to create request", slog.Any("error", err))
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Error("Failed to execute request", slog.Any("error", err))
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error("Failed to read response body", slog.Any("error", err))
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusUnprocessableEntity {
+		return ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("Repository request failed",
+			slog.String("url", apiURL),
+			slog.Int("status_code", resp.StatusCode),
+			slog.String("response", string(body)))
+		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		logger.Error("Failed to parse response", slog.Any("error", err))
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// CreateBlob stores base64 encoded content in the Git database of a repository of the organization
+// and returns the blob's SHA
+func (org *Organization) CreateBlob(ctx context.Context, logger *slog.Logger, repoName string, content string) (string, error) {
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/git/blobs", baseURL, org.Login, repoName)
+
+	status, body, err := org.orgRequest(ctx, logger, http.MethodPost, apiURL, map[string]string{
+		"content":  content,
+		"encoding": "base64",
+	})
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusCreated {
+		logger.Error("Failed to create blob",
+			slog.String("repo", repoName),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return "", fmt.Errorf("failed to create blob in %s with status %d: %s", repoName, status, string(body))
+	}
+	return parseSHA(logger, body)
+}
+
+// CreateTree creates a tree from entries whose paths may name files in subdirectories, in a repository
+// of the organization, and returns the tree's SHA
+func (org *Organization) CreateTree(ctx context.Context, logger *slog.Logger, repoName string, entries []TreeEntry) (string, error) {
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/git/trees", baseURL, org.Login, repoName)
+
+	status, body, err := org.orgRequest(ctx, logger, http.MethodPost, apiURL, map[string][]TreeEntry{"tree": entries})
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusCreated {
+		logger.Error("Failed to create tree",
+			slog.String("repo", repoName),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return "", fmt.Errorf("failed to create tree in %s with status %d: %s", repoName, status, string(body))
+	}
+	return parseSHA(logger, body)
+}
+
+// CreateCommit creates a commit of a tree in a repository of the organization and returns its SHA
+func (org *Organization) CreateCommit(ctx context.Context, logger *slog.Logger, repoName string, message string, treeSHA string, parents []string) (string, error) {
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/git/commits", baseURL, org.Login, repoName)
+
+	status, body, err := org.orgRequest(ctx, logger, http.MethodPost, apiURL, map[string]any{
+		"message": message,
+		"tree":    treeSHA,
+		"parents": parents,
+	})
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusCreated {
+		logger.Error("Failed to create commit",
+			slog.String("repo", repoName),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return "", fmt.Errorf("failed to create commit in %s with status %d: %s", repoName, status, string(body))
+	}
+	return parseSHA(logger, body)
+}
+
+// UpdateBranch points a branch of a repository of the organization at a commit; without force the
+// commit must descend from the branch's current commit
+func (org *Organization) UpdateBranch(ctx context.Context, logger *slog.Logger, repoName string, branch string, sha string, force bool) error {
+	logger.Info("Updating branch",
+		slog.String("org", org.Login),
+		slog.String("repo", repoName),
+		slog.String("branch", branch),
+		slog.String("sha", sha))
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/git/refs/heads/%s", baseURL, org.Login, repoName, branch)
+
+	status, body, err := org.orgRequest(ctx, logger, http.MethodPatch, apiURL, map[string]any{
+		"sha":   sha,
+		"force": force,
+	})
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		logger.Error("Failed to update branch",
+			slog.String("repo", repoName),
+			slog.String("branch", branch),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return fmt.Errorf("failed to update branch %s of %s with status %d: %s", branch, repoName, status, string(body))
+	}
+	return nil
+}
+
+// parseSHA reads the SHA of a created Git object
+func parseSHA(logger *slog.Logger, body []byte) (string, error) {
+	var object struct {
+		SHA string `json:"sha"`
+	}
+	if err := json.Unmarshal(body, &object); err != nil {
+		logger.Error("Failed to parse response", slog.Any("error", err))
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	return object.SHA, nil
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/auth"
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+)
+
+// hostFromContext returns the configured GitHub host, falling back to one derived from the base URL
+func hostFromContext(ctx context.Context) *config.Host {
+	if host, ok := ctx.Value(config.HostKey).(*config.Host); ok && host != nil {
+		return host
+	}
+	host, err := config.HostFromBaseURL(ctx.Value(config.BaseURLKey).(string), "", "")
+	if err != nil {
+		host, _ = config.ParseHost("github.com", "", "")
+	}
+	return host
+}
+
+// graphQLURL returns the GraphQL endpoint of the configured host
+func graphQLURL(ctx context.Context) string {
+	return hostFromContext(ctx).GraphQLURL()
+}
+
+// UsesAppAuth reports whether requests are authenticated as GitHub Apps rather than with a PAT.
+// Only then does the app need to be installed on each lab organization.
+func UsesAppAuth(ctx context.Context) bool {
+	pool, ok := ctx.Value(config.AppPoolKey).(*auth.AppPool)
+	return ok && pool != nil
+}
+
+// baseTransport returns the shared transport configured with --ca-file, --proxy and the client
+// certificate flags, or http.DefaultTransport when none was set
+func baseTransport(ctx context.Context) http.RoundTripper {
+	if rt, ok := ctx.Value(config.TransportKey).(http.RoundTripper); ok && rt != nil {
+		return rt
+	}
+	return http.DefaultTransport
+}
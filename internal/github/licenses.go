@@ -0,0 +1,191 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+)
+
+// licensePageSize is the page size of the enterprise billing endpoints
+const licensePageSize = 100
+
+// LicensedUser is an account that consumes a seat in the enterprise
+type LicensedUser struct {
+	Login string `json:"github_com_login"`
+	// EnterpriseRoles are e.g. "owner", "member" or "outside collaborator"
+	EnterpriseRoles []string `json:"github_com_enterprise_roles"`
+}
+
+// IsOutsideCollaborator reports whether the user only has access to enterprise repositories as an
+// outside collaborator; adding them to a lab organization makes them a member
+func (u LicensedUser) IsOutsideCollaborator() bool {
+	for _, role := range u.EnterpriseRoles {
+		switch strings.ToLower(role) {
+		case "owner", "member":
+			return false
+		}
+	}
+	for _, role := range u.EnterpriseRoles {
+		if strings.Contains(strings.ToLower(role), "outside") {
+			return true
+		}
+	}
+	return false
+}
+
+// ConsumedLicenses is the seat usage of an enterprise
+type ConsumedLicenses struct {
+	SeatsConsumed  int
+	SeatsPurchased int
+	// Users maps the lowercased login of every licensed github.com account to its license
+	Users map[string]LicensedUser
+}
+
+// AdvancedSecurityCommitters is the GitHub Advanced Security committer usage of an enterprise
+type AdvancedSecurityCommitters struct {
+	Active    int
+	Purchased int
+	// Logins holds the lowercased login of every active committer
+	Logins map[string]bool
+}
+
+// GetConsumedLicenses lists the seats consumed in the enterprise and the accounts consuming them
+func GetConsumedLicenses(ctx context.Context, logger *slog.Logger, enterpriseSlug string) (*ConsumedLicenses, error) {
+	logger.Info("Fetching consumed licenses", slog.String("enterprise", enterpriseSlug))
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+
+	rt := NewGithubStyleTransport(ctx, logger, config.EnterpriseType)
+	client := &http.Client{
+		Transport: rt,
+	}
+
+	licenses := &ConsumedLicenses{Users: map[string]LicensedUser{}}
+	for page := 1; ; page++ {
+		apiURL := fmt.Sprintf("%s/enterprises/%s/consumed-licenses?per_page=%d&page=%d", baseURL, enterpriseSlug, licensePageSize, page)
+
+		var result struct {
+			SeatsConsumed  int            `json:"total_seats_consumed"`
+			SeatsPurchased int            `json:"total_seats_purchased"`
+			Users          []LicensedUser `json:"users"`
+		}
+		if err := getBillingPage(ctx, logger, client, apiURL, &result); err != nil {
+			return nil, fmt.Errorf("failed to get consumed licenses: %w", err)
+		}
+
+		licenses.SeatsConsumed = result.SeatsConsumed
+		licenses.SeatsPurchased = result.SeatsPurchased
+		for _, user := range result.Users {
+			if user.Login != "" {
+				licenses.Users[strings.ToLower(user.Login)] = user
+			}
+		}
+
+		if len(result.Users) < licensePageSize {
+			break
+		}
+	}
+
+	logger.Info("Consumed licenses retrieved",
+		slog.Int("seats_consumed", licenses.SeatsConsumed),
+		slog.Int("seats_purchased", licenses.SeatsPurchased))
+
+	return licenses, nil
+}
+
+// GetAdvancedSecurityCommitters lists the active GitHub Advanced Security committers of the enterprise
+func GetAdvancedSecurityCommitters(ctx context.Context, logger *slog.Logger, enterpriseSlug string) (*AdvancedSecurityCommitters, error) {
+	logger.Info("Fetching GitHub Advanced Security committers", slog.String("enterprise", enterpriseSlug))
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+
+	rt := NewGithubStyleTransport(ctx, logger, config.EnterpriseType)
+	client := &http.Client{
+		Transport: rt,
+	}
+
+	committers := &AdvancedSecurityCommitters{Logins: map[string]bool{}}
+	for page := 1; ; page++ {
+		apiURL := fmt.Sprintf("%s/enterprises/%s/settings/billing/advanced-security?per_page=%d&page=%d", baseURL, enterpriseSlug, licensePageSize, page)
+
+		var result struct {
+			Active       int `json:"total_advanced_security_committers"`
+			Purchased    int `json:"purchased_advanced_security_committers"`
+			Repositories []struct {
+				Breakdown []struct {
+					Login string `json:"user_login"`
+				} `json:"advanced_security_committers_breakdown"`
+			} `json:"repositories"`
+		}
+		if err := getBillingPage(ctx, logger, client, apiURL, &result); err != nil {
+			return nil, fmt.Errorf("failed to get GitHub Advanced Security committers: %w", err)
+		}
+
+		committers.Active = result.Active
+		committers.Purchased = result.Purchased
+		for _, repo := range result.Repositories {
+			for _, committer := range repo.Breakdown {
+				committers.Logins[strings.ToLower(committer.Login)] = true
+			}
+		}
+
+		if len(result.Repositories) < licensePageSize {
+			break
+		}
+	}
+
+	logger.Info("GitHub Advanced Security committers retrieved",
+		slog.Int("active", committers.Active),
+		slog.Int("purchased", committers.Purchased))
+
+	return committers, nil
+}
+
+// getBillingPage fetches one page of an enterprise billing endpoint into out
+func getBillingPage(ctx context.Context, logger *slog.Logger, client *http.Client, apiURL string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		logger.Error("Failed to create request", slog.Any("error", err))
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Error("Failed to execute request", slog.Any("error", err))
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error("Failed to read response body", slog.Any("error", err))
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("Enterprise billing request failed",
+			slog.String("url", apiURL),
+			slog.Int("status_code", resp.StatusCode),
+			slog.String("response", string(body)))
+		return fmt.Errorf("request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	if err := json.Unmarshal(body, out); err != nil {
+		logger.Error("Failed to parse response", slog.Any("error", err))
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
@@ -8,26 +8,69 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/s-samadi/ghas-lab-builder/internal/auth"
 	"github.com/s-samadi/ghas-lab-builder/internal/config"
 )
 
+// LabOrgName returns the organization name of a lab user. Underscores, such as the one before
+// the shortcode of a managed user login, are not allowed in organization names and become dashes.
+func LabOrgName(labDate string, user string) string {
+	return "ghas-labs-" + labDate + "-" + strings.ReplaceAll(user, "_", "-")
+}
+
+// LabTeamOrgName returns the organization name of a team in an org-per-team lab. The "team-" prefix
+// keeps it apart from the organization of a user with the same login.
+func LabTeamOrgName(labDate string, team string) string {
+	return LabOrgName(labDate, "team-"+TeamSlug(team))
+}
+
+// LabSharedOrgName returns the name of the one organization of a single-org lab
+func LabSharedOrgName(labDate string) string {
+	return "ghas-labs-" + labDate
+}
+
+// TeamSlug returns the slug GitHub derives from a team name
+func TeamSlug(name string) string {
+	return strings.Trim(nonAlphanumeric.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// CreateOrg creates the lab organization of a user
 func (enterprise *Enterprise) CreateOrg(ctx context.Context, logger *slog.Logger, user string) (*Organization, error) {
-	orgName := "ghas-labs-" + ctx.Value(config.LabDateKey).(string) + "-" + user
-	logger.Info("Creating organization", slog.String("org", orgName), slog.String("user", user))
+	orgName := LabOrgName(ctx.Value(config.LabDateKey).(string), user)
+
+	// Managed users only exist inside the enterprise, so their provisioned login is made an owner
+	// of the org as it is created
+	var owners []string
+	facilitators := ctx.Value(config.FacilitatorsKey).([]string)
+	if shortcode, _ := ctx.Value(config.EMUShortcodeKey).(string); shortcode != "" && !slices.Contains(facilitators, user) {
+		owners = []string{user}
+	}
+
+	return enterprise.CreateLabOrg(ctx, logger, orgName, owners)
+}
+
+// CreateLabOrg creates an organization owned by the facilitators and the given additional owners
+func (enterprise *Enterprise) CreateLabOrg(ctx context.Context, logger *slog.Logger, orgName string, owners []string) (*Organization, error) {
+	logger.Info("Creating organization", slog.String("org", orgName), slog.Any("owners", owners))
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
+	// GitHub Enterprise Server has no enterprise organization mutation; use the site admin API instead
+	if err := hostFromContext(ctx).Supports(config.FeatureEnterpriseOrgCreation); err != nil {
+		return createServerOrg(ctx, logger, orgName, err)
+	}
+
 	rt := NewGithubStyleTransport(ctx, logger, config.EnterpriseType)
 
 	client := &http.Client{
 		Transport: rt,
 	}
 
-	baseURL := ctx.Value(config.BaseURLKey).(string)
-	graphqlURL := baseURL + "/graphql"
+	graphqlURL := graphQLURL(ctx)
 
 	// GraphQL mutation for creating an enterprise organization
 	mutation := `
@@ -54,13 +97,15 @@ func (enterprise *Enterprise) CreateOrg(ctx context.Context, logger *slog.Logger
 		billingEmail = facilitators[0] + "@github.com"
 	}
 
+	adminLogins := append(slices.Clone(owners), facilitators...)
+
 	payload := map[string]interface{}{
 		"query": mutation,
 		"variables": map[string]interface{}{
 			"enterpriseId": enterprise.ID,
 			"login":        orgName,
 			"profileName":  orgName,
-			"adminLogins":  facilitators, //append([]string{user}, facilitators...),
+			"adminLogins":  adminLogins,
 			"billingEmail": billingEmail,
 		},
 	}
@@ -123,7 +168,6 @@ func (enterprise *Enterprise) CreateOrg(ctx context.Context, logger *slog.Logger
 
 	logger.Info("Successfully created organization",
 		slog.String("org", orgName),
-		slog.String("user", user),
 		slog.Any("response", result))
 
 	return &result.Data.CreateEnterpriseOrganization.Organization, nil
@@ -134,14 +178,18 @@ func (enterprise *Enterprise) DeleteOrg(ctx context.Context, logger *slog.Logger
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
+	// GitHub Enterprise Server cannot remove organizations from the enterprise; delete them directly
+	if err := hostFromContext(ctx).Supports(config.FeatureEnterpriseOrgRemoval); err != nil {
+		return deleteServerOrg(ctx, logger, orgLogin)
+	}
+
 	rt := NewGithubStyleTransport(ctx, logger, config.EnterpriseType)
 
 	client := &http.Client{
 		Transport: rt,
 	}
 
-	baseURL := ctx.Value(config.BaseURLKey).(string)
-	graphqlURL := baseURL + "/graphql"
+	graphqlURL := graphQLURL(ctx)
 
 	// First, get the organization ID
 	queryOrg := `
@@ -315,6 +363,165 @@ func (enterprise *Enterprise) DeleteOrg(ctx context.Context, logger *slog.Logger
 	return nil
 }
 
+// createServerOrg creates an organization on GitHub Enterprise Server with the site admin API. The first
+// facilitator becomes the organization's admin and the other facilitators are added as owners.
+func createServerOrg(ctx context.Context, logger *slog.Logger, orgName string, unsupported error) (*Organization, error) {
+	if UsesAppAuth(ctx) {
+		return nil, fmt.Errorf("%w; organizations are created through the site admin API, which requires --token for a site administrator", unsupported)
+	}
+
+	facilitators := ctx.Value(config.FacilitatorsKey).([]string)
+	if len(facilitators) == 0 || facilitators[0] == "" {
+		return nil, fmt.Errorf("at least one facilitator is required to administer %s", orgName)
+	}
+
+	rt := NewGithubStyleTransport(ctx, logger, config.EnterpriseType)
+	client := &http.Client{
+		Transport: rt,
+	}
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+
+	payload := map[string]interface{}{
+		"login":        orgName,
+		"admin":        facilitators[0],
+		"profile_name": orgName,
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		logger.Error("Failed to marshal request payload", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to marshal request payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/admin/organizations", bytes.NewBuffer(jsonData))
+	if err != nil {
+		logger.Error("Failed to create request", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Error("Failed to execute request", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error("Failed to read response body", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		logger.Error("Failed to create organization",
+			slog.Int("status_code", resp.StatusCode),
+			slog.String("response", string(body)))
+		return nil, fmt.Errorf("failed to create organization with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var org struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+	}
+	if err := json.Unmarshal(body, &org); err != nil {
+		logger.Error("Failed to parse response", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	for _, facilitator := range facilitators[1:] {
+		if err := setOrgMembership(ctx, logger, client, org.Login, facilitator, "admin"); err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Info("Successfully created organization",
+		slog.String("org", org.Login),
+		slog.Int64("id", org.ID))
+
+	return &Organization{
+		ID:    fmt.Sprintf("%d", org.ID),
+		Login: org.Login,
+		Name:  orgName,
+	}, nil
+}
+
+// setOrgMembership adds a user to an organization with the given role ("admin" or "member")
+func setOrgMembership(ctx context.Context, logger *slog.Logger, client *http.Client, orgLogin string, username string, role string) error {
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/orgs/%s/memberships/%s", baseURL, orgLogin, username)
+
+	jsonData, err := json.Marshal(map[string]string{"role": role})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, apiURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		logger.Error("Failed to create request", slog.Any("error", err))
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Error("Failed to execute request", slog.Any("error", err))
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		logger.Error("Failed to set organization membership",
+			slog.String("org", orgLogin),
+			slog.String("user", username),
+			slog.Int("status_code", resp.StatusCode),
+			slog.String("response", string(body)))
+		return fmt.Errorf("failed to add %s to %s as %s with status %d: %s", username, orgLogin, role, resp.StatusCode, string(body))
+	}
+
+	logger.Info("Set organization membership",
+		slog.String("org", orgLogin),
+		slog.String("user", username),
+		slog.String("role", role))
+
+	return nil
+}
+
+// deleteServerOrg deletes an organization on GitHub Enterprise Server with the REST API
+func deleteServerOrg(ctx context.Context, logger *slog.Logger, orgLogin string) error {
+	rt := NewGithubStyleTransport(ctx, logger, config.EnterpriseType)
+	client := &http.Client{
+		Transport: rt,
+	}
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, fmt.Sprintf("%s/orgs/%s", baseURL, orgLogin), nil)
+	if err != nil {
+		logger.Error("Failed to create request", slog.Any("error", err))
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Error("Failed to execute request", slog.Any("error", err))
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(resp.Body)
+		logger.Error("Failed to delete organization",
+			slog.Int("status_code", resp.StatusCode),
+			slog.String("response", string(body)))
+		return fmt.Errorf("failed to delete organization with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	logger.Info("Successfully deleted organization", slog.String("org", orgLogin))
+
+	return nil
+}
+
 // GetOrganization retrieves an organization by name using REST API
 // Note: This returns the numeric ID from REST API, not the GraphQL node ID
 func GetOrganization(ctx context.Context, logger *slog.Logger, orgName string) (*Organization, error) {
@@ -380,17 +587,49 @@ func GetOrganization(ctx context.Context, logger *slog.Logger, orgName string) (
 	}, nil
 }
 
-// InstallAppOnOrg installs a GitHub App on an organization using REST API
+// InstallAppOnOrg installs every GitHub App in the pool on an organization using REST API.
+// The primary app's installation is returned; failures of additional apps are logged, since the
+// pool routes that organization's requests to the apps that are installed.
 func (enterprise *Enterprise) InstallAppOnOrg(ctx context.Context, logger *slog.Logger, orgName string) (*AppInstallation, error) {
-	logger.Info("Installing app on organization",
-		slog.String("org", orgName))
+	if err := hostFromContext(ctx).Supports(config.FeatureEnterpriseAppInstallation); err != nil {
+		return nil, fmt.Errorf("%w; use --token, or install the app on %s manually", err, orgName)
+	}
+
+	// Installing an app on an organization is only possible when authenticated as that app
+	pool, ok := ctx.Value(config.AppPoolKey).(*auth.AppPool)
+	if !ok || pool == nil {
+		return nil, fmt.Errorf("installing the GitHub App on %s requires GitHub App authentication (--app-id and --private-key-file)", orgName)
+	}
 
-	//I don't love this but to get the ClientID we need to get an enterprise installation token again. Consider refactoring later.
-	ts := auth.NewTokenService(ctx.Value(config.AppIDKey).(string), ctx.Value(config.PrivateKeyKey).(string), ctx.Value(config.BaseURLKey).(string))
-	token, err := ts.GetInstallationToken(config.EnterpriseType)
+	var primary *AppInstallation
+	for i, manager := range pool.Managers() {
+		installation, err := enterprise.installAppOnOrg(ctx, logger, orgName, manager)
+		if i == 0 {
+			if err != nil {
+				return nil, err
+			}
+			primary = installation
+			continue
+		}
+		if err != nil {
+			logger.Warn("Failed to install additional app on organization",
+				slog.String("org", orgName),
+				slog.String("app_id", manager.Service().AppID()),
+				slog.Any("error", err))
+		}
+	}
+
+	return primary, nil
+}
 
+func (enterprise *Enterprise) installAppOnOrg(ctx context.Context, logger *slog.Logger, orgName string, manager *auth.TokenManager) (*AppInstallation, error) {
+	logger.Info("Installing app on organization",
+		slog.String("org", orgName),
+		slog.String("app_id", manager.Service().AppID()))
+
+	clientID, err := manager.ClientID(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get installation token: %w", err)
+		return nil, fmt.Errorf("failed to get app client ID: %w", err)
 	}
 
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
@@ -407,7 +646,7 @@ func (enterprise *Enterprise) InstallAppOnOrg(ctx context.Context, logger *slog.
 
 	// Prepare request body
 	payload := map[string]interface{}{
-		"client_id":            token.ClientID,
+		"client_id":            clientID,
 		"repository_selection": "all",
 	}
 
@@ -451,8 +690,66 @@ func (enterprise *Enterprise) InstallAppOnOrg(ctx context.Context, logger *slog.
 
 	logger.Info("Successfully installed app on organization",
 		slog.String("org", orgName),
-		slog.String("app_id", token.AppID),
+		slog.String("app_id", manager.Service().AppID()),
 		slog.Int64("installation_id", installation.ID))
 
 	return &installation, nil
 }
+
+// ListInstallableOrganizations lists the enterprise-owned organizations that GitHub Apps can be
+// installed on. It succeeds only for credentials allowed to manage enterprise app installations.
+func (enterprise *Enterprise) ListInstallableOrganizations(ctx context.Context, logger *slog.Logger) ([]string, error) {
+	logger.Info("Listing installable organizations", slog.String("enterprise", enterprise.Slug))
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	rt := NewGithubStyleTransport(ctx, logger, config.EnterpriseType)
+	client := &http.Client{
+		Transport: rt,
+	}
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/enterprises/%s/apps/installable_organizations?per_page=100", baseURL, enterprise.Slug)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		logger.Error("Failed to create request", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Error("Failed to execute request", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error("Failed to read response body", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("Failed to list installable organizations",
+			slog.Int("status_code", resp.StatusCode),
+			slog.String("response", string(body)))
+		return nil, fmt.Errorf("failed to list installable organizations with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var orgs []struct {
+		Login string `json:"login"`
+	}
+	if err := json.Unmarshal(body, &orgs); err != nil {
+		logger.Error("Failed to parse response", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	logins := make([]string, 0, len(orgs))
+	for _, org := range orgs {
+		logins = append(logins, org.Login)
+	}
+
+	return logins, nil
+}
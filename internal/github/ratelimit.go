@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+)
+
+// RateLimit is one resource's rate limit as returned by GET /rate_limit
+type RateLimit struct {
+	Limit     int   `json:"limit"`
+	Remaining int   `json:"remaining"`
+	Used      int   `json:"used"`
+	Reset     int64 `json:"reset"`
+}
+
+// ResetAt returns when the rate limit window resets
+func (r RateLimit) ResetAt() time.Time {
+	return time.Unix(r.Reset, 0)
+}
+
+// RateLimits holds the REST and GraphQL rate limits of the credentials used for targetType
+type RateLimits struct {
+	Core    RateLimit `json:"core"`
+	GraphQL RateLimit `json:"graphql"`
+}
+
+// GetRateLimit retrieves the current rate limits. Calling this endpoint does not count against them.
+func GetRateLimit(ctx context.Context, logger *slog.Logger, targetType string) (*RateLimits, error) {
+	logger.Info("Fetching rate limit", slog.String("target_type", targetType))
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	rt := NewGithubStyleTransport(ctx, logger, targetType)
+	client := &http.Client{
+		Transport: rt,
+	}
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/rate_limit", nil)
+	if err != nil {
+		logger.Error("Failed to create request", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Error("Failed to execute request", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error("Failed to read response body", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("Failed to get rate limit",
+			slog.Int("status_code", resp.StatusCode),
+			slog.String("response", string(body)))
+		return nil, fmt.Errorf("failed to get rate limit with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		Resources RateLimits `json:"resources"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		logger.Error("Failed to parse response", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return &result.Resources, nil
+}
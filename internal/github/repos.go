@@ -4,26 +4,59 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/s-samadi/ghas-lab-builder/internal/config"
 )
 
+// A repository generated from a template exists before its contents are copied, so it is polled until its
+// branches appear; variables so tests can shorten them
+var (
+	repoReadyTimeout     = 3 * time.Minute
+	repoReadyInterval    = time.Second
+	repoReadyMaxInterval = 10 * time.Second
+)
+
 func (org *Organization) CreateRepoFromTemplate(ctx context.Context, logger *slog.Logger, templateRepo string, includeAllBranches bool) (*Repository, error) {
+	return org.CreateRepoFromTemplateAs(ctx, logger, templateRepo, "", includeAllBranches)
+}
+
+// CreateRepoFromTemplateAs generates a repository from a template under the given name; an empty
+// name keeps the template's name
+func (org *Organization) CreateRepoFromTemplateAs(ctx context.Context, logger *slog.Logger, templateRepo string, repoName string, includeAllBranches bool) (*Repository, error) {
+	return org.CreateRepoFromTemplateWith(ctx, logger, templateRepo, GenerateOptions{Name: repoName, IncludeAllBranches: includeAllBranches})
+}
+
+// GenerateOptions are the settings a repository is generated from a template with
+type GenerateOptions struct {
+	// Name defaults to the template's name
+	Name string
+	// Description defaults to naming the template
+	Description string
+	// Public generates a public repository; generated repositories are private otherwise
+	Public             bool
+	IncludeAllBranches bool
+}
+
+// CreateRepoFromTemplateWith generates a repository from a template with the given options
+func (org *Organization) CreateRepoFromTemplateWith(ctx context.Context, logger *slog.Logger, templateRepo string, opts GenerateOptions) (*Repository, error) {
 	// Enrich context with org-specific information for auth scoping
 	ctx = context.WithValue(ctx, config.OrgKey, org.Login)
-	return org.createRepoFromTemplateWithRetry(ctx, logger, templateRepo, includeAllBranches, 0)
+	return org.createRepoFromTemplateWithRetry(ctx, logger, templateRepo, opts, 0)
 }
 
-func (org *Organization) createRepoFromTemplateWithRetry(ctx context.Context, logger *slog.Logger, templateRepo string, includeAllBranches bool, retryCount int) (*Repository, error) {
+func (org *Organization) createRepoFromTemplateWithRetry(ctx context.Context, logger *slog.Logger, templateRepo string, opts GenerateOptions, retryCount int) (*Repository, error) {
 	logger.Info("Creating repository from template",
 		slog.String("template", templateRepo),
-		slog.Bool("include_all_branches", includeAllBranches))
+		slog.String("name", opts.Name),
+		slog.Bool("include_all_branches", opts.IncludeAllBranches))
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
 	defer cancel()
 
@@ -34,6 +67,14 @@ func (org *Organization) createRepoFromTemplateWithRetry(ctx context.Context, lo
 	}
 	templateOwner := parts[0]
 	templateRepoName := parts[1]
+	repoName := opts.Name
+	if repoName == "" {
+		repoName = templateRepoName
+	}
+	description := opts.Description
+	if description == "" {
+		description = fmt.Sprintf("Repository created from template %s", templateRepo)
+	}
 
 	baseURL := ctx.Value(config.BaseURLKey).(string)
 	apiURL := fmt.Sprintf("%s/repos/%s/%s/generate", baseURL, templateOwner, templateRepoName)
@@ -41,10 +82,10 @@ func (org *Organization) createRepoFromTemplateWithRetry(ctx context.Context, lo
 	// Request payload for creating a repo from template
 	payload := map[string]interface{}{
 		"owner":                org.Login,
-		"name":                 templateRepoName,
-		"description":          fmt.Sprintf("Repository created from template %s", templateRepo),
-		"include_all_branches": includeAllBranches,
-		"private":              true,
+		"name":                 repoName,
+		"description":          description,
+		"include_all_branches": opts.IncludeAllBranches,
+		"private":              !opts.Public,
 	}
 
 	jsonData, err := json.Marshal(payload)
@@ -91,7 +132,7 @@ func (org *Organization) createRepoFromTemplateWithRetry(ctx context.Context, lo
 
 				logger.Debug("Sleeping for 60 seconds before retry")
 				time.Sleep(60 * time.Second)
-				return org.createRepoFromTemplateWithRetry(ctx, logger, templateRepo, includeAllBranches, retryCount)
+				return org.createRepoFromTemplateWithRetry(ctx, logger, templateRepo, opts, retryCount)
 			}
 		}
 		logger.Error("Failed to create repository from template",
@@ -114,6 +155,260 @@ func (org *Organization) createRepoFromTemplateWithRetry(ctx context.Context, lo
 	return &result, nil
 }
 
+// RepoOptions are the settings a repository is created with
+type RepoOptions struct {
+	Name        string
+	Description string
+	// Visibility is private, internal or public
+	Visibility string
+	// AutoInit gives the repository an initial commit, and so a default branch; the Git database of an
+	// empty repository cannot be written to through the API, only pushed to
+	AutoInit bool
+}
+
+// CreateRepository creates a repository in the organization
+func (org *Organization) CreateRepository(ctx context.Context, logger *slog.Logger, opts RepoOptions) (*Repository, error) {
+	logger.Info("Creating repository",
+		slog.String("org", org.Login),
+		slog.String("name", opts.Name))
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/orgs/%s/repos", baseURL, org.Login)
+
+	status, body, err := org.orgRequest(ctx, logger, http.MethodPost, apiURL, map[string]any{
+		"name":        opts.Name,
+		"description": opts.Description,
+		"visibility":  opts.Visibility,
+		"auto_init":   opts.AutoInit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusCreated {
+		logger.Error("Failed to create repository",
+			slog.String("name", opts.Name),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return nil, fmt.Errorf("failed to create repository %s with status %d: %s", opts.Name, status, string(body))
+	}
+
+	var result Repository
+	if err := json.Unmarshal(body, &result); err != nil {
+		logger.Error("Failed to parse response", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	logger.Info("Successfully created repository",
+		slog.String("repository", result.FullName),
+		slog.String("url", result.HTMLURL))
+
+	return &result, nil
+}
+
+// ListBranches lists the branch names of a repository given as owner/repo. A repository without any
+// commits has no branches.
+func ListBranches(ctx context.Context, logger *slog.Logger, fullName string) ([]string, error) {
+	owner, repoName, ok := strings.Cut(fullName, "/")
+	if !ok || owner == "" || repoName == "" {
+		return nil, fmt.Errorf("invalid repository format, expected 'owner/repo', got: %s", fullName)
+	}
+
+	ctx = context.WithValue(ctx, config.OrgKey, owner)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+
+	rt := NewGithubStyleTransport(ctx, logger, config.OrganizationType)
+	client := &http.Client{
+		Transport: rt,
+	}
+
+	var branches []string
+	for page := 1; ; page++ {
+		apiURL := fmt.Sprintf("%s/repos/%s/%s/branches?per_page=100&page=%d", baseURL, owner, repoName, page)
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+		if err != nil {
+			logger.Error("Failed to create request", slog.Any("error", err))
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			logger.Error("Failed to execute request", slog.Any("error", err))
+			return nil, fmt.Errorf("failed to execute request: %w", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			logger.Error("Failed to read response body", slog.Any("error", err))
+			return nil, fmt.Errorf("failed to read response body: %w", err)
+		}
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+		case http.StatusNotFound:
+			return nil, fmt.Errorf("repository %s: %w", fullName, ErrNotFound)
+		case http.StatusConflict:
+			// The Git repository is still empty
+			return nil, nil
+		default:
+			logger.Error("Failed to list branches",
+				slog.String("repo", fullName),
+				slog.Int("status_code", resp.StatusCode),
+				slog.String("response", string(body)))
+			return nil, fmt.Errorf("failed to list branches of %s with status %d: %s", fullName, resp.StatusCode, string(body))
+		}
+
+		var result []struct {
+			Name string `json:"name"`
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			logger.Error("Failed to parse response", slog.Any("error", err))
+			return nil, fmt.Errorf("failed to parse response: %w", err)
+		}
+		for _, branch := range result {
+			branches = append(branches, branch.Name)
+		}
+
+		if len(result) < 100 {
+			return branches, nil
+		}
+	}
+}
+
+// WaitForBranches polls a repository of the organization until every expected branch exists, or any branch
+// when none is expected, and returns how long that took
+func (org *Organization) WaitForBranches(ctx context.Context, logger *slog.Logger, repoName string, expected []string) (time.Duration, error) {
+	logger.Info("Waiting for repository contents",
+		slog.String("org", org.Login),
+		slog.String("repo", repoName),
+		slog.Any("branches", expected))
+
+	started := time.Now()
+	deadline := started.Add(repoReadyTimeout)
+	wait := repoReadyInterval
+	for {
+		branches, err := ListBranches(ctx, logger, org.Login+"/"+repoName)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return time.Since(started), err
+		}
+
+		var missing []string
+		for _, branch := range expected {
+			if !slices.Contains(branches, branch) {
+				missing = append(missing, branch)
+			}
+		}
+		if len(missing) == 0 && len(branches) > 0 {
+			ready := time.Since(started)
+			logger.Info("Repository is ready", slog.String("repo", repoName), slog.Duration("ready_after", ready))
+			return ready, nil
+		}
+
+		if time.Now().Add(wait).After(deadline) {
+			logger.Error("Timed out waiting for repository contents",
+				slog.String("repo", repoName),
+				slog.Any("missing_branches", missing))
+			detail := "it has no branches"
+			if len(missing) > 0 {
+				detail = "missing branches: " + strings.Join(missing, ", ")
+			}
+			return time.Since(started), fmt.Errorf("repository %s was not ready after %s; %s", repoName, repoReadyTimeout, detail)
+		}
+		logger.Debug("Repository is not ready yet",
+			slog.String("repo", repoName),
+			slog.Any("missing_branches", missing),
+			slog.Duration("wait", wait))
+		select {
+		case <-ctx.Done():
+			return time.Since(started), ctx.Err()
+		case <-time.After(wait):
+		}
+		wait = min(wait*2, repoReadyMaxInterval)
+	}
+}
+
+// UpdateRepository changes the settings of a repository of the organization, e.g. its visibility,
+// default branch or features, and returns the updated repository
+func (org *Organization) UpdateRepository(ctx context.Context, logger *slog.Logger, repoName string, settings map[string]any) (*Repository, error) {
+	logger.Info("Updating repository settings",
+		slog.String("org", org.Login),
+		slog.String("repo", repoName),
+		slog.Any("settings", settings))
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/repos/%s/%s", baseURL, org.Login, repoName)
+
+	status, body, err := org.orgRequest(ctx, logger, http.MethodPatch, apiURL, settings)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		logger.Error("Failed to update repository",
+			slog.String("repo", repoName),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return nil, fmt.Errorf("failed to update repository %s with status %d: %s", repoName, status, string(body))
+	}
+
+	var repo Repository
+	if err := json.Unmarshal(body, &repo); err != nil {
+		logger.Error("Failed to parse response", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return &repo, nil
+}
+
+// ReplaceTopics replaces the topics of a repository of the organization
+func (org *Organization) ReplaceTopics(ctx context.Context, logger *slog.Logger, repoName string, topics []string) error {
+	logger.Info("Setting repository topics",
+		slog.String("org", org.Login),
+		slog.String("repo", repoName),
+		slog.Any("topics", topics))
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/topics", baseURL, org.Login, repoName)
+
+	status, body, err := org.orgRequest(ctx, logger, http.MethodPut, apiURL, map[string][]string{"names": topics})
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		logger.Error("Failed to set repository topics",
+			slog.String("repo", repoName),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return fmt.Errorf("failed to set topics of %s with status %d: %s", repoName, status, string(body))
+	}
+	return nil
+}
+
+// SetActionsEnabled turns GitHub Actions on or off for a repository of the organization
+func (org *Organization) SetActionsEnabled(ctx context.Context, logger *slog.Logger, repoName string, enabled bool) error {
+	logger.Info("Setting GitHub Actions permissions",
+		slog.String("org", org.Login),
+		slog.String("repo", repoName),
+		slog.Bool("enabled", enabled))
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/actions/permissions", baseURL, org.Login, repoName)
+
+	status, body, err := org.orgRequest(ctx, logger, http.MethodPut, apiURL, map[string]bool{"enabled": enabled})
+	if err != nil {
+		return err
+	}
+	if status != http.StatusNoContent {
+		logger.Error("Failed to set GitHub Actions permissions",
+			slog.String("repo", repoName),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return fmt.Errorf("failed to set GitHub Actions permissions of %s with status %d: %s", repoName, status, string(body))
+	}
+	return nil
+}
+
 // DeleteRepository deletes a repository in the organization
 func (org *Organization) DeleteRepository(ctx context.Context, logger *slog.Logger, repoName string) error {
 	logger.Info("Deleting repository",
@@ -238,3 +533,63 @@ func (org *Organization) ListRepositories(ctx context.Context, logger *slog.Logg
 
 	return allRepos, nil
 }
+
+// GetRepository retrieves a repository by its "owner/repo" name. With GitHub App authentication
+// the owner's installation is used, so the app must be installed on the owning organization.
+func GetRepository(ctx context.Context, logger *slog.Logger, fullName string) (*Repository, error) {
+	parts := strings.Split(fullName, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid repository format, expected 'owner/repo', got: %s", fullName)
+	}
+
+	logger.Info("Fetching repository", slog.String("repo", fullName))
+
+	ctx = context.WithValue(ctx, config.OrgKey, parts[0])
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/repos/%s/%s", baseURL, parts[0], parts[1])
+
+	rt := NewGithubStyleTransport(ctx, logger, config.OrganizationType)
+	client := &http.Client{
+		Transport: rt,
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		logger.Error("Failed to create request", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Error("Failed to execute request", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error("Failed to read response body", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("Failed to get repository",
+			slog.Int("status_code", resp.StatusCode),
+			slog.String("response", string(body)))
+		return nil, fmt.Errorf("failed to get repository with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result Repository
+	if err := json.Unmarshal(body, &result); err != nil {
+		logger.Error("Failed to parse response", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,71 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWaitForBranches(t *testing.T) {
+	timeout, interval, maxInterval := repoReadyTimeout, repoReadyInterval, repoReadyMaxInterval
+	repoReadyTimeout, repoReadyInterval, repoReadyMaxInterval = 50*time.Millisecond, time.Millisecond, 5*time.Millisecond
+	t.Cleanup(func() { repoReadyTimeout, repoReadyInterval, repoReadyMaxInterval = timeout, interval, maxInterval })
+
+	tests := []struct {
+		name     string
+		expected []string
+		// polls answers each poll in turn with the branches of the repository, nil for a 404; the last
+		// answer repeats
+		polls   [][]string
+		wantErr string
+	}{
+		{"ready", []string{"main"}, [][]string{{"main"}}, ""},
+		{"any branch when none is expected", nil, [][]string{{"trunk"}}, ""},
+		{"not found while it is created", []string{"main"}, [][]string{nil, nil, {"main"}}, ""},
+		{"branches copied one by one", []string{"main", "feature"}, [][]string{{}, {"main"}, {"feature", "main"}}, ""},
+		{"timeout with missing branches", []string{"main", "feature"}, [][]string{{"main"}}, "missing branches: feature"},
+		{"timeout without branches", nil, [][]string{{}}, "it has no branches"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			poll := 0
+			ctx := testContext(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/repos/lab/demo/branches" {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				mu.Lock()
+				branches := tt.polls[min(poll, len(tt.polls)-1)]
+				poll++
+				mu.Unlock()
+				if branches == nil {
+					http.NotFound(w, r)
+					return
+				}
+				result := []map[string]string{}
+				for _, branch := range branches {
+					result = append(result, map[string]string{"name": branch})
+				}
+				json.NewEncoder(w).Encode(result)
+			}))
+
+			organization := &Organization{Login: "lab"}
+			_, err := organization.WaitForBranches(ctx, testLogger(), "demo", tt.expected)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if poll < len(tt.polls) {
+				t.Errorf("ready after %d polls, want %d", poll, len(tt.polls))
+			}
+		})
+	}
+}
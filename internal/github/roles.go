@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+)
+
+// ListOrganizationRoles lists the predefined and custom roles of the organization
+func (org *Organization) ListOrganizationRoles(ctx context.Context, logger *slog.Logger) ([]OrganizationRole, error) {
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/orgs/%s/organization-roles", baseURL, org.Login)
+
+	status, body, err := org.orgRequest(ctx, logger, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		logger.Error("Failed to list organization roles",
+			slog.String("org", org.Login),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return nil, fmt.Errorf("failed to list organization roles of %s with status %d: %s", org.Login, status, string(body))
+	}
+
+	var result struct {
+		Roles []OrganizationRole `json:"roles"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		logger.Error("Failed to parse response", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return result.Roles, nil
+}
+
+// AssignOrganizationRole grants a member of the organization the organization role with the given name,
+// e.g. "security_manager", "all_repo_read" or a custom role
+func (org *Organization) AssignOrganizationRole(ctx context.Context, logger *slog.Logger, username string, roleName string) error {
+	logger.Info("Assigning organization role",
+		slog.String("org", org.Login),
+		slog.String("user", username),
+		slog.String("role", roleName))
+
+	roles, err := org.ListOrganizationRoles(ctx, logger)
+	if err != nil {
+		return err
+	}
+	var role *OrganizationRole
+	for i := range roles {
+		if roles[i].Name == roleName {
+			role = &roles[i]
+			break
+		}
+	}
+	if role == nil {
+		return fmt.Errorf("organization role %s in %s: %w", roleName, org.Login, ErrNotFound)
+	}
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/orgs/%s/organization-roles/users/%s/%d", baseURL, org.Login, username, role.ID)
+
+	status, body, err := org.orgRequest(ctx, logger, http.MethodPut, apiURL, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusNoContent {
+		logger.Error("Failed to assign organization role",
+			slog.String("user", username),
+			slog.String("role", roleName),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return fmt.Errorf("failed to assign %s the %s role in %s with status %d: %s", username, roleName, org.Login, status, string(body))
+	}
+	return nil
+}
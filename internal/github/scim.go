@@ -0,0 +1,269 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
+)
+
+const (
+	scimUserSchema     = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimPatchOpSchema  = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	scimContentType    = "application/scim+json"
+	scimRequestTimeout = 30 * time.Second
+)
+
+// ScimUser is a user resource of the enterprise SCIM API
+type ScimUser struct {
+	Schemas     []string      `json:"schemas"`
+	ID          string        `json:"id,omitempty"`
+	ExternalID  string        `json:"externalId,omitempty"`
+	UserName    string        `json:"userName"`
+	DisplayName string        `json:"displayName,omitempty"`
+	Name        *ScimUserName `json:"name,omitempty"`
+	Emails      []ScimEmail   `json:"emails,omitempty"`
+	Active      bool          `json:"active"`
+}
+
+type ScimUserName struct {
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+	Formatted  string `json:"formatted,omitempty"`
+}
+
+type ScimEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary"`
+}
+
+// nonAlphanumeric matches the runs of characters GitHub replaces with a dash when normalizing usernames
+var nonAlphanumeric = regexp.MustCompile(`[^a-z0-9]+`)
+
+// ManagedUserLogin derives the GitHub login of a managed user the way GitHub normalizes SCIM
+// usernames: the domain of an email address is dropped, other characters than letters and
+// digits become dashes, and the enterprise shortcode is appended after an underscore
+func ManagedUserLogin(userName string, shortcode string) string {
+	handle, _, _ := strings.Cut(strings.ToLower(userName), "@")
+	handle = strings.Trim(nonAlphanumeric.ReplaceAllString(handle, "-"), "-")
+	return handle + "_" + shortcode
+}
+
+// scimUsersURL returns the SCIM Users endpoint of the enterprise, or the --scim-url override
+func scimUsersURL(ctx context.Context) string {
+	if override, ok := ctx.Value(config.SCIMURLKey).(string); ok && override != "" {
+		return strings.TrimSuffix(override, "/") + "/Users"
+	}
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	enterpriseSlug := ctx.Value(config.EnterpriseSlugKey).(string)
+	return fmt.Sprintf("%s/scim/v2/enterprises/%s/Users", baseURL, enterpriseSlug)
+}
+
+// scimRequest sends a SCIM request with the enterprise credentials and returns the status and body
+func scimRequest(ctx context.Context, logger *slog.Logger, method string, requestURL string, payload any) (int, []byte, error) {
+	var reqBody io.Reader
+	if payload != nil {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			logger.Error("Failed to marshal SCIM payload", slog.Any("error", err))
+			return 0, nil, fmt.Errorf("failed to marshal SCIM payload: %w", err)
+		}
+		reqBody = bytes.NewBuffer(jsonData)
+	}
+
+	rt := NewGithubStyleTransport(ctx, logger, config.EnterpriseType)
+	client := &http.Client{
+		Transport: rt,
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, reqBody)
+	if err != nil {
+		logger.Error("Failed to create request", slog.Any("error", err))
+		return 0, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", scimContentType)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Error("Failed to execute request", slog.Any("error", err))
+		return 0, nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error("Failed to read response body", slog.Any("error", err))
+		return 0, nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	return resp.StatusCode, body, nil
+}
+
+// ProvisionManagedUser creates the user through the enterprise SCIM API. A user that already
+// exists is reused, and reactivated if it was suspended, so provisioning can be rerun.
+func ProvisionManagedUser(ctx context.Context, logger *slog.Logger, user util.LabUser) (*ScimUser, error) {
+	logger.Info("Provisioning managed user", slog.String("user_name", user.UserName))
+
+	ctx, cancel := context.WithTimeout(ctx, scimRequestTimeout)
+	defer cancel()
+
+	displayName := user.Name()
+	if displayName == "" {
+		displayName = user.UserName
+	}
+
+	payload := ScimUser{
+		Schemas:     []string{scimUserSchema},
+		ExternalID:  user.UserName,
+		UserName:    user.UserName,
+		DisplayName: displayName,
+		Name: &ScimUserName{
+			GivenName:  user.GivenName,
+			FamilyName: user.FamilyName,
+			Formatted:  displayName,
+		},
+		Emails: []ScimEmail{{Value: user.Email, Type: "work", Primary: true}},
+		Active: true,
+	}
+
+	status, body, err := scimRequest(ctx, logger, http.MethodPost, scimUsersURL(ctx), payload)
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case http.StatusCreated, http.StatusOK:
+	case http.StatusConflict:
+		logger.Info("Managed user already provisioned", slog.String("user_name", user.UserName))
+		existing, err := FindManagedUser(ctx, logger, user.UserName)
+		if err != nil {
+			return nil, err
+		}
+		if !existing.Active {
+			if err := setManagedUserActive(ctx, logger, existing.ID, true); err != nil {
+				return nil, err
+			}
+			existing.Active = true
+		}
+		return existing, nil
+	default:
+		logger.Error("Failed to provision managed user",
+			slog.String("user_name", user.UserName),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return nil, fmt.Errorf("failed to provision managed user %s with status %d: %s", user.UserName, status, string(body))
+	}
+
+	var created ScimUser
+	if err := json.Unmarshal(body, &created); err != nil {
+		logger.Error("Failed to parse response", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	logger.Info("Provisioned managed user",
+		slog.String("user_name", created.UserName),
+		slog.String("scim_id", created.ID))
+
+	return &created, nil
+}
+
+// FindManagedUser looks up a SCIM user by userName, returning ErrNotFound if there is none
+func FindManagedUser(ctx context.Context, logger *slog.Logger, userName string) (*ScimUser, error) {
+	filter := url.QueryEscape(fmt.Sprintf("userName eq %q", userName))
+	status, body, err := scimRequest(ctx, logger, http.MethodGet, scimUsersURL(ctx)+"?filter="+filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		logger.Error("Failed to look up managed user",
+			slog.String("user_name", userName),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return nil, fmt.Errorf("failed to look up managed user %s with status %d: %s", userName, status, string(body))
+	}
+
+	var result struct {
+		Resources []ScimUser `json:"Resources"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		logger.Error("Failed to parse response", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	for _, user := range result.Resources {
+		if strings.EqualFold(user.UserName, userName) {
+			return &user, nil
+		}
+	}
+	return nil, fmt.Errorf("managed user %s: %w", userName, ErrNotFound)
+}
+
+// setManagedUserActive suspends or reactivates a SCIM user
+func setManagedUserActive(ctx context.Context, logger *slog.Logger, id string, active bool) error {
+	payload := map[string]any{
+		"schemas": []string{scimPatchOpSchema},
+		"Operations": []map[string]any{
+			{"op": "replace", "path": "active", "value": active},
+		},
+	}
+
+	status, body, err := scimRequest(ctx, logger, http.MethodPatch, scimUsersURL(ctx)+"/"+url.PathEscape(id), payload)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		logger.Error("Failed to update managed user",
+			slog.String("scim_id", id),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return fmt.Errorf("failed to update managed user %s with status %d: %s", id, status, string(body))
+	}
+	return nil
+}
+
+// DeprovisionManagedUser deletes the SCIM user, which suspends the managed user on GitHub.
+// A user that no longer exists is treated as already deprovisioned.
+func DeprovisionManagedUser(ctx context.Context, logger *slog.Logger, userName string) error {
+	logger.Info("Deprovisioning managed user", slog.String("user_name", userName))
+
+	ctx, cancel := context.WithTimeout(ctx, scimRequestTimeout)
+	defer cancel()
+
+	user, err := FindManagedUser(ctx, logger, userName)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			logger.Info("Managed user already deprovisioned", slog.String("user_name", userName))
+			return nil
+		}
+		return err
+	}
+
+	status, body, err := scimRequest(ctx, logger, http.MethodDelete, scimUsersURL(ctx)+"/"+url.PathEscape(user.ID), nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusNoContent && status != http.StatusOK && status != http.StatusNotFound {
+		logger.Error("Failed to deprovision managed user",
+			slog.String("user_name", userName),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return fmt.Errorf("failed to deprovision managed user %s with status %d: %s", userName, status, string(body))
+	}
+
+	logger.Info("Deprovisioned managed user", slog.String("user_name", userName), slog.String("scim_id", user.ID))
+	return nil
+}
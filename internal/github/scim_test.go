@@ -0,0 +1,279 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
+)
+
+// testContext returns a context that sends API calls, authenticated with a PAT, to a test server
+func testContext(t *testing.T, handler http.Handler) context.Context {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	ctx := context.WithValue(context.Background(), config.TokenKey, "test-token")
+	ctx = context.WithValue(ctx, config.BaseURLKey, server.URL)
+	return ctx
+}
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// fakeSCIM is an in-memory enterprise SCIM Users endpoint
+type fakeSCIM struct {
+	t *testing.T
+
+	mu      sync.Mutex
+	users   map[string]*ScimUser
+	next    int
+	patches []string
+	deleted []string
+}
+
+func (s *fakeSCIM) handler(prefix string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+prefix+"/Users", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Content-Type"); got != scimContentType {
+			s.t.Errorf("Content-Type = %q", got)
+		}
+		var user ScimUser
+		if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+			s.t.Fatalf("decoding user: %v", err)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, existing := range s.users {
+			if strings.EqualFold(existing.UserName, user.UserName) {
+				w.WriteHeader(http.StatusConflict)
+				fmt.Fprint(w, `{"detail":"User already exists"}`)
+				return
+			}
+		}
+		s.next++
+		user.ID = fmt.Sprintf("scim-%d", s.next)
+		s.users[user.ID] = &user
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(user)
+	})
+	mux.HandleFunc("GET "+prefix+"/Users", func(w http.ResponseWriter, r *http.Request) {
+		filter := r.URL.Query().Get("filter")
+		userName, ok := strings.CutPrefix(filter, "userName eq ")
+		if !ok {
+			s.t.Errorf("unexpected filter %q", filter)
+		}
+		userName = strings.Trim(userName, `"`)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		resources := []ScimUser{}
+		for _, user := range s.users {
+			// GitHub matches userName case-insensitively, and may return near matches
+			if strings.Contains(strings.ToLower(user.UserName), strings.ToLower(userName)) {
+				resources = append(resources, *user)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"totalResults": len(resources), "Resources": resources})
+	})
+	mux.HandleFunc("PATCH "+prefix+"/Users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var patch struct {
+			Operations []struct {
+				Op    string `json:"op"`
+				Path  string `json:"path"`
+				Value bool   `json:"value"`
+			} `json:"Operations"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			s.t.Fatalf("decoding patch: %v", err)
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		user, ok := s.users[r.PathValue("id")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		for _, op := range patch.Operations {
+			if op.Op == "replace" && op.Path == "active" {
+				user.Active = op.Value
+			}
+		}
+		s.patches = append(s.patches, r.PathValue("id"))
+		json.NewEncoder(w).Encode(user)
+	})
+	mux.HandleFunc("DELETE "+prefix+"/Users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.users[r.PathValue("id")]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		delete(s.users, r.PathValue("id"))
+		s.deleted = append(s.deleted, r.PathValue("id"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// newFakeSCIM serves the SCIM API of enterprise octo-ent and returns a context that targets it
+func newFakeSCIM(t *testing.T) (*fakeSCIM, context.Context) {
+	t.Helper()
+	scim := &fakeSCIM{t: t, users: map[string]*ScimUser{}}
+	ctx := testContext(t, scim.handler("/scim/v2/enterprises/octo-ent"))
+	return scim, context.WithValue(ctx, config.EnterpriseSlugKey, "octo-ent")
+}
+
+func TestProvisionManagedUser(t *testing.T) {
+	scim, ctx := newFakeSCIM(t)
+	user := util.LabUser{Email: "alice@example.com", UserName: "alice@example.com", GivenName: "Alice", FamilyName: "Liddell"}
+
+	created, err := ProvisionManagedUser(ctx, testLogger(), user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID != "scim-1" || !created.Active {
+		t.Errorf("created %+v", created)
+	}
+	stored := scim.users["scim-1"]
+	if stored.UserName != "alice@example.com" || stored.ExternalID != "alice@example.com" || stored.DisplayName != "Alice Liddell" ||
+		stored.Name.GivenName != "Alice" || len(stored.Emails) != 1 || stored.Emails[0].Value != "alice@example.com" {
+		t.Errorf("sent %+v", stored)
+	}
+}
+
+func TestProvisionManagedUserConflict(t *testing.T) {
+	tests := []struct {
+		name        string
+		active      bool
+		wantPatches int
+	}{
+		{"active user is reused", true, 0},
+		{"suspended user is reactivated", false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scim, ctx := newFakeSCIM(t)
+			// A near match comes first, so the lookup has to compare userNames
+			scim.users["scim-7"] = &ScimUser{ID: "scim-7", UserName: "alice@example.com.old", Active: false}
+			scim.users["scim-8"] = &ScimUser{ID: "scim-8", UserName: "Alice@example.com", Active: tt.active}
+
+			user := util.LabUser{Email: "alice@example.com", UserName: "alice@example.com"}
+			existing, err := ProvisionManagedUser(ctx, testLogger(), user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if existing.ID != "scim-8" || !existing.Active {
+				t.Errorf("got %+v, want the active existing user scim-8", existing)
+			}
+			if len(scim.patches) != tt.wantPatches || !scim.users["scim-8"].Active || scim.users["scim-7"].Active {
+				t.Errorf("patched %v; scim-8 active %v, scim-7 active %v", scim.patches, scim.users["scim-8"].Active, scim.users["scim-7"].Active)
+			}
+		})
+	}
+}
+
+func TestProvisionManagedUserFailure(t *testing.T) {
+	ctx := testContext(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"detail":"userName is invalid"}`)
+	}))
+	ctx = context.WithValue(ctx, config.EnterpriseSlugKey, "octo-ent")
+
+	_, err := ProvisionManagedUser(ctx, testLogger(), util.LabUser{UserName: "not valid"})
+	if err == nil || !strings.Contains(err.Error(), "status 400") || !strings.Contains(err.Error(), "userName is invalid") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestFindManagedUser(t *testing.T) {
+	scim, ctx := newFakeSCIM(t)
+	scim.users["scim-1"] = &ScimUser{ID: "scim-1", UserName: "bob@example.com.old"}
+	scim.users["scim-2"] = &ScimUser{ID: "scim-2", UserName: "Bob@Example.com"}
+
+	tests := []struct {
+		userName string
+		wantID   string
+		wantErr  error
+	}{
+		{"bob@example.com", "scim-2", nil},
+		{"BOB@EXAMPLE.COM", "scim-2", nil},
+		{"bob@example", "", ErrNotFound},
+		{"carol@example.com", "", ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.userName, func(t *testing.T) {
+			user, err := FindManagedUser(ctx, testLogger(), tt.userName)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || user.ID != tt.wantID {
+				t.Errorf("got %+v, %v; want %s", user, err, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestDeprovisionManagedUser(t *testing.T) {
+	scim, ctx := newFakeSCIM(t)
+	scim.users["scim-1"] = &ScimUser{ID: "scim-1", UserName: "alice@example.com", Active: true}
+
+	if err := DeprovisionManagedUser(ctx, testLogger(), "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(scim.deleted) != 1 || scim.deleted[0] != "scim-1" {
+		t.Errorf("deleted %v, want scim-1", scim.deleted)
+	}
+
+	// Deprovisioning again finds no user and succeeds without deleting anything
+	if err := DeprovisionManagedUser(ctx, testLogger(), "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(scim.deleted) != 1 {
+		t.Errorf("deleted %v on the second run", scim.deleted)
+	}
+}
+
+func TestSCIMURLOverride(t *testing.T) {
+	scim := &fakeSCIM{t: t, users: map[string]*ScimUser{}}
+	ctx := testContext(t, scim.handler("/custom/scim"))
+	ctx = context.WithValue(ctx, config.EnterpriseSlugKey, "octo-ent")
+	ctx = context.WithValue(ctx, config.SCIMURLKey, ctx.Value(config.BaseURLKey).(string)+"/custom/scim/")
+
+	if _, err := ProvisionManagedUser(ctx, testLogger(), util.LabUser{UserName: "alice@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(scim.users) != 1 {
+		t.Errorf("the override endpoint has %d users, want 1", len(scim.users))
+	}
+}
+
+func TestManagedUserLogin(t *testing.T) {
+	tests := []struct {
+		userName string
+		want     string
+	}{
+		{"alice@example.com", "alice_octo"},
+		{"Alice.Liddell@example.com", "alice-liddell_octo"},
+		{"bob_smith", "bob-smith_octo"},
+		{"--carol--", "carol_octo"},
+	}
+	for _, tt := range tests {
+		if got := ManagedUserLogin(tt.userName, "octo"); got != tt.want {
+			t.Errorf("ManagedUserLogin(%q) = %q, want %q", tt.userName, got, tt.want)
+		}
+	}
+}
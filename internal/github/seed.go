@@ -0,0 +1,262 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+)
+
+// seedPageSize is the page size used when listing issues and pull requests
+const seedPageSize = 100
+
+// CreateLabel creates a label in a repository of the organization. It reports false when the label already exists.
+func (org *Organization) CreateLabel(ctx context.Context, logger *slog.Logger, repoName string, label Label) (bool, error) {
+	logger.Info("Creating label",
+		slog.String("org", org.Login),
+		slog.String("repo", repoName),
+		slog.String("label", label.Name))
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/labels", baseURL, org.Login, repoName)
+
+	status, body, err := org.orgRequest(ctx, logger, http.MethodPost, apiURL, label)
+	if err != nil {
+		return false, err
+	}
+	if status == http.StatusUnprocessableEntity {
+		logger.Info("Label already exists", slog.String("repo", repoName), slog.String("label", label.Name))
+		return false, nil
+	}
+	if status != http.StatusCreated {
+		logger.Error("Failed to create label",
+			slog.String("repo", repoName),
+			slog.String("label", label.Name),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return false, fmt.Errorf("failed to create label %s in %s with status %d: %s", label.Name, repoName, status, string(body))
+	}
+	return true, nil
+}
+
+// GetBranchSHA returns the commit a branch of a repository of the organization points to
+func (org *Organization) GetBranchSHA(ctx context.Context, logger *slog.Logger, repoName string, branch string) (string, error) {
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/git/ref/heads/%s", baseURL, org.Login, repoName, branch)
+
+	status, body, err := org.orgRequest(ctx, logger, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return "", err
+	}
+	if status == http.StatusNotFound {
+		return "", fmt.Errorf("branch %s of %s: %w", branch, repoName, ErrNotFound)
+	}
+	if status != http.StatusOK {
+		logger.Error("Failed to get branch",
+			slog.String("repo", repoName),
+			slog.String("branch", branch),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return "", fmt.Errorf("failed to get branch %s of %s with status %d: %s", branch, repoName, status, string(body))
+	}
+
+	var ref struct {
+		Object struct {
+			SHA string `json:"sha"`
+		} `json:"object"`
+	}
+	if err := json.Unmarshal(body, &ref); err != nil {
+		logger.Error("Failed to parse response", slog.Any("error", err))
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	return ref.Object.SHA, nil
+}
+
+// CreateBranch creates a branch pointing at a commit in a repository of the organization
+func (org *Organization) CreateBranch(ctx context.Context, logger *slog.Logger, repoName string, branch string, sha string) error {
+	logger.Info("Creating branch",
+		slog.String("org", org.Login),
+		slog.String("repo", repoName),
+		slog.String("branch", branch),
+		slog.String("sha", sha))
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/git/refs", baseURL, org.Login, repoName)
+
+	status, body, err := org.orgRequest(ctx, logger, http.MethodPost, apiURL, map[string]string{
+		"ref": "refs/heads/" + branch,
+		"sha": sha,
+	})
+	if err != nil {
+		return err
+	}
+	if status != http.StatusCreated {
+		logger.Error("Failed to create branch",
+			slog.String("repo", repoName),
+			slog.String("branch", branch),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return fmt.Errorf("failed to create branch %s in %s with status %d: %s", branch, repoName, status, string(body))
+	}
+	return nil
+}
+
+// ListIssues lists the open and closed issues, including pull requests, of a repository of the organization
+func (org *Organization) ListIssues(ctx context.Context, logger *slog.Logger, repoName string) ([]Issue, error) {
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+
+	var issues []Issue
+	for page := 1; ; page++ {
+		apiURL := fmt.Sprintf("%s/repos/%s/%s/issues?state=all&per_page=%d&page=%d", baseURL, org.Login, repoName, seedPageSize, page)
+
+		status, body, err := org.orgRequest(ctx, logger, http.MethodGet, apiURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		if status != http.StatusOK {
+			logger.Error("Failed to list issues",
+				slog.String("repo", repoName),
+				slog.Int("status_code", status),
+				slog.String("response", string(body)))
+			return nil, fmt.Errorf("failed to list issues of %s with status %d: %s", repoName, status, string(body))
+		}
+
+		var pageIssues []Issue
+		if err := json.Unmarshal(body, &pageIssues); err != nil {
+			logger.Error("Failed to parse response", slog.Any("error", err))
+			return nil, fmt.Errorf("failed to parse response: %w", err)
+		}
+		issues = append(issues, pageIssues...)
+
+		if len(pageIssues) < seedPageSize {
+			return issues, nil
+		}
+	}
+}
+
+// CreateIssue opens an issue in a repository of the organization
+func (org *Organization) CreateIssue(ctx context.Context, logger *slog.Logger, repoName string, title string, body string, labels []string) (*Issue, error) {
+	logger.Info("Creating issue",
+		slog.String("org", org.Login),
+		slog.String("repo", repoName),
+		slog.String("title", title))
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/issues", baseURL, org.Login, repoName)
+
+	payload := map[string]any{
+		"title": title,
+		"body":  body,
+	}
+	if len(labels) > 0 {
+		payload["labels"] = labels
+	}
+
+	status, respBody, err := org.orgRequest(ctx, logger, http.MethodPost, apiURL, payload)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusCreated {
+		logger.Error("Failed to create issue",
+			slog.String("repo", repoName),
+			slog.String("title", title),
+			slog.Int("status_code", status),
+			slog.String("response", string(respBody)))
+		return nil, fmt.Errorf("failed to create issue %q in %s with status %d: %s", title, repoName, status, string(respBody))
+	}
+
+	var issue Issue
+	if err := json.Unmarshal(respBody, &issue); err != nil {
+		logger.Error("Failed to parse response", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return &issue, nil
+}
+
+// ListPullRequests lists the open and closed pull requests from a branch of a repository of the organization
+func (org *Organization) ListPullRequests(ctx context.Context, logger *slog.Logger, repoName string, head string) ([]PullRequest, error) {
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/pulls?state=all&per_page=%d&head=%s", baseURL, org.Login, repoName, seedPageSize, url.QueryEscape(org.Login+":"+head))
+
+	status, body, err := org.orgRequest(ctx, logger, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		logger.Error("Failed to list pull requests",
+			slog.String("repo", repoName),
+			slog.String("head", head),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return nil, fmt.Errorf("failed to list pull requests of %s with status %d: %s", repoName, status, string(body))
+	}
+
+	var pulls []PullRequest
+	if err := json.Unmarshal(body, &pulls); err != nil {
+		logger.Error("Failed to parse response", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return pulls, nil
+}
+
+// CreatePullRequest opens a pull request from head into base in a repository of the organization
+func (org *Organization) CreatePullRequest(ctx context.Context, logger *slog.Logger, repoName string, title string, body string, head string, base string, draft bool) (*PullRequest, error) {
+	logger.Info("Creating pull request",
+		slog.String("org", org.Login),
+		slog.String("repo", repoName),
+		slog.String("head", head),
+		slog.String("base", base))
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/pulls", baseURL, org.Login, repoName)
+
+	status, respBody, err := org.orgRequest(ctx, logger, http.MethodPost, apiURL, map[string]any{
+		"title": title,
+		"body":  body,
+		"head":  head,
+		"base":  base,
+		"draft": draft,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusCreated {
+		logger.Error("Failed to create pull request",
+			slog.String("repo", repoName),
+			slog.String("head", head),
+			slog.Int("status_code", status),
+			slog.String("response", string(respBody)))
+		return nil, fmt.Errorf("failed to create pull request from %s in %s with status %d: %s", head, repoName, status, string(respBody))
+	}
+
+	var pull PullRequest
+	if err := json.Unmarshal(respBody, &pull); err != nil {
+		logger.Error("Failed to parse response", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return &pull, nil
+}
+
+// AddIssueLabels adds labels to an issue or pull request of a repository of the organization
+func (org *Organization) AddIssueLabels(ctx context.Context, logger *slog.Logger, repoName string, number int, labels []string) error {
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/issues/%d/labels", baseURL, org.Login, repoName, number)
+
+	status, body, err := org.orgRequest(ctx, logger, http.MethodPost, apiURL, map[string][]string{"labels": labels})
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		logger.Error("Failed to add labels",
+			slog.String("repo", repoName),
+			slog.Int("number", number),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return fmt.Errorf("failed to add labels to #%d in %s with status %d: %s", number, repoName, status, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+)
+
+// orgRequest sends a JSON request scoped to the organization and returns the status code and body
+func (org *Organization) orgRequest(ctx context.Context, logger *slog.Logger, method string, apiURL string, payload any) (int, []byte, error) {
+	ctx = context.WithValue(ctx, config.OrgKey, org.Login)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	rt := NewGithubStyleTransport(ctx, logger, config.OrganizationType)
+	client := &http.Client{
+		Transport: rt,
+	}
+
+	var reqBody io.Reader
+	if payload != nil {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			logger.Error("Failed to marshal request payload", slog.Any("error", err))
+			return 0, nil, fmt.Errorf("failed to marshal request payload: %w", err)
+		}
+		reqBody = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, apiURL, reqBody)
+	if err != nil {
+		logger.Error("Failed to create request", slog.Any("error", err))
+		return 0, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Error("Failed to execute request", slog.Any("error", err))
+		return 0, nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error("Failed to read response body", slog.Any("error", err))
+		return 0, nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	return resp.StatusCode, body, nil
+}
+
+// AddMember adds a user to the organization with the given role ("admin" or "member")
+func (org *Organization) AddMember(ctx context.Context, logger *slog.Logger, username string, role string) error {
+	logger.Info("Adding organization member",
+		slog.String("org", org.Login),
+		slog.String("user", username),
+		slog.String("role", role))
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/orgs/%s/memberships/%s", baseURL, org.Login, username)
+
+	status, body, err := org.orgRequest(ctx, logger, http.MethodPut, apiURL, map[string]string{"role": role})
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		logger.Error("Failed to set organization membership",
+			slog.String("org", org.Login),
+			slog.String("user", username),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return fmt.Errorf("failed to add %s to %s as %s with status %d: %s", username, org.Login, role, status, string(body))
+	}
+	return nil
+}
+
+// CreateTeam creates a closed team in the organization, returning the existing team if there is one
+func (org *Organization) CreateTeam(ctx context.Context, logger *slog.Logger, name string) (*Team, error) {
+	logger.Info("Creating team", slog.String("org", org.Login), slog.String("team", name))
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/orgs/%s/teams", baseURL, org.Login)
+
+	status, body, err := org.orgRequest(ctx, logger, http.MethodPost, apiURL, map[string]string{
+		"name":    name,
+		"privacy": "closed",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A team left over from an earlier run is reused
+	if status == http.StatusUnprocessableEntity {
+		logger.Info("Team already exists", slog.String("org", org.Login), slog.String("team", name))
+		return org.GetTeam(ctx, logger, TeamSlug(name))
+	}
+	if status != http.StatusCreated {
+		logger.Error("Failed to create team",
+			slog.String("team", name),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return nil, fmt.Errorf("failed to create team %s with status %d: %s", name, status, string(body))
+	}
+
+	var team Team
+	if err := json.Unmarshal(body, &team); err != nil {
+		logger.Error("Failed to parse response", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	logger.Info("Successfully created team",
+		slog.String("org", org.Login),
+		slog.String("team", team.Slug))
+
+	return &team, nil
+}
+
+// GetTeam retrieves a team of the organization by its slug
+func (org *Organization) GetTeam(ctx context.Context, logger *slog.Logger, slug string) (*Team, error) {
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/orgs/%s/teams/%s", baseURL, org.Login, slug)
+
+	status, body, err := org.orgRequest(ctx, logger, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, fmt.Errorf("team %s in %s: %w", slug, org.Login, ErrNotFound)
+	}
+	if status != http.StatusOK {
+		logger.Error("Failed to get team",
+			slog.String("team", slug),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return nil, fmt.Errorf("failed to get team %s with status %d: %s", slug, status, string(body))
+	}
+
+	var team Team
+	if err := json.Unmarshal(body, &team); err != nil {
+		logger.Error("Failed to parse response", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return &team, nil
+}
+
+// AddTeamMember adds a user to a team of the organization
+func (org *Organization) AddTeamMember(ctx context.Context, logger *slog.Logger, teamSlug string, username string) error {
+	logger.Info("Adding team member",
+		slog.String("org", org.Login),
+		slog.String("team", teamSlug),
+		slog.String("user", username))
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/orgs/%s/teams/%s/memberships/%s", baseURL, org.Login, teamSlug, username)
+
+	status, body, err := org.orgRequest(ctx, logger, http.MethodPut, apiURL, map[string]string{"role": "member"})
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		logger.Error("Failed to add team member",
+			slog.String("team", teamSlug),
+			slog.String("user", username),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return fmt.Errorf("failed to add %s to team %s with status %d: %s", username, teamSlug, status, string(body))
+	}
+	return nil
+}
+
+// SetTeamRepoPermission grants a team a permission ("pull", "triage", "push", "maintain" or "admin")
+// on a repository of the organization
+func (org *Organization) SetTeamRepoPermission(ctx context.Context, logger *slog.Logger, teamSlug string, repoName string, permission string) error {
+	logger.Info("Granting team repository permission",
+		slog.String("org", org.Login),
+		slog.String("team", teamSlug),
+		slog.String("repo", repoName),
+		slog.String("permission", permission))
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/orgs/%s/teams/%s/repos/%s/%s", baseURL, org.Login, teamSlug, org.Login, repoName)
+
+	status, body, err := org.orgRequest(ctx, logger, http.MethodPut, apiURL, map[string]string{"permission": permission})
+	if err != nil {
+		return err
+	}
+	if status != http.StatusNoContent {
+		logger.Error("Failed to grant team repository permission",
+			slog.String("team", teamSlug),
+			slog.String("repo", repoName),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return fmt.Errorf("failed to grant team %s %s on %s with status %d: %s", teamSlug, permission, repoName, status, string(body))
+	}
+	return nil
+}
+
+// AddRepoCollaborator grants a user a permission ("pull", "triage", "push", "maintain" or "admin")
+// on a repository of the organization
+func (org *Organization) AddRepoCollaborator(ctx context.Context, logger *slog.Logger, repoName string, username string, permission string) error {
+	logger.Info("Adding repository collaborator",
+		slog.String("org", org.Login),
+		slog.String("repo", repoName),
+		slog.String("user", username),
+		slog.String("permission", permission))
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/collaborators/%s", baseURL, org.Login, repoName, username)
+
+	status, body, err := org.orgRequest(ctx, logger, http.MethodPut, apiURL, map[string]string{"permission": permission})
+	if err != nil {
+		return err
+	}
+	// 201 means an invitation was sent, 204 that the user already had access through the organization
+	if status != http.StatusCreated && status != http.StatusNoContent {
+		logger.Error("Failed to add repository collaborator",
+			slog.String("repo", repoName),
+			slog.String("user", username),
+			slog.Int("status_code", status),
+			slog.String("response", string(body)))
+		return fmt.Errorf("failed to grant %s %s on %s with status %d: %s", username, permission, repoName, status, string(body))
+	}
+	return nil
+}
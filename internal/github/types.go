@@ -1,5 +1,10 @@
 package api
 
+import "errors"
+
+// ErrNotFound is returned when GitHub responds 404 to a lookup
+var ErrNotFound = errors.New("not found")
+
 // Enterprise represents the enterprise information returned from GitHub GraphQL API
 type Enterprise struct {
 	ID           string `json:"id"`
@@ -13,10 +18,65 @@ type Organization struct {
 	Name  string `json:"name"`
 }
 
+// Team is a team of an organization
+type Team struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+// OrganizationRole is a predefined or custom role that can be granted to members of an organization
+type OrganizationRole struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
 type Repository struct {
-	ID       int64  `json:"id"`
-	FullName string `json:"full_name"`
-	HTMLURL  string `json:"html_url"`
+	ID            int64  `json:"id"`
+	FullName      string `json:"full_name"`
+	HTMLURL       string `json:"html_url"`
+	Description   string `json:"description,omitempty"`
+	IsTemplate    bool   `json:"is_template"`
+	Visibility    string `json:"visibility,omitempty"`
+	DefaultBranch string `json:"default_branch,omitempty"`
+}
+
+// Label is an issue and pull request label of a repository
+type Label struct {
+	Name        string `json:"name"`
+	Color       string `json:"color,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Issue is an issue of a repository; pull requests are listed as issues too
+type Issue struct {
+	Number      int       `json:"number"`
+	Title       string    `json:"title"`
+	HTMLURL     string    `json:"html_url"`
+	PullRequest *struct{} `json:"pull_request,omitempty"`
+}
+
+// PullRequest is a pull request of a repository
+type PullRequest struct {
+	Number  int    `json:"number"`
+	Title   string `json:"title"`
+	HTMLURL string `json:"html_url"`
+	Head    struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+	Labels []Label `json:"labels,omitempty"`
+}
+
+// TokenInfo describes the user behind a Personal Access Token
+type TokenInfo struct {
+	Login string
+	// Scopes lists the OAuth scopes of a classic token
+	Scopes []string
+	// Classic is false for fine-grained tokens, which do not report scopes
+	Classic bool
 }
 
 type AppInstallation struct {
@@ -1,35 +1,338 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
-	"sync"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/s-samadi/ghas-lab-builder/internal/config"
 )
 
+// userValidationBatchSize is the number of logins looked up in one aliased GraphQL query
+const userValidationBatchSize = 50
+
+// Transient lookup failures are retried with exponential backoff
+const userValidationAttempts = 4
+
+// userValidationBackoff is the wait before the first retry; a variable so tests can shorten it
+var userValidationBackoff = 2 * time.Second
+
+// InvalidReason explains why a user cannot be added to a lab
+type InvalidReason string
+
+const (
+	// ReasonNotFound means no account has the login
+	ReasonNotFound InvalidReason = "not_found"
+	// ReasonSuspended means the account exists but is suspended
+	ReasonSuspended InvalidReason = "suspended"
+	// ReasonRenamed means the account with the expected ID now has a different login
+	ReasonRenamed InvalidReason = "renamed"
+	// ReasonBot means the login belongs to a bot or GitHub App
+	ReasonBot InvalidReason = "bot"
+	// ReasonOrganization means the login belongs to an organization, not a user
+	ReasonOrganization InvalidReason = "organization"
+	// ReasonLookupFailed means the user could not be checked, even after retries
+	ReasonLookupFailed InvalidReason = "lookup_failed"
+	// ReasonProvisioningFailed means an Enterprise Managed User could not be provisioned through SCIM
+	ReasonProvisioningFailed InvalidReason = "provisioning_failed"
+	// ReasonMissingLogin means the user is listed by email only, outside EMU mode
+	ReasonMissingLogin InvalidReason = "missing_login"
+	// ReasonNotEnterpriseMember means a managed user enterprise has no account with the login
+	ReasonNotEnterpriseMember InvalidReason = "not_enterprise_member"
+)
+
+// UserLookup identifies a user to validate. ID is optional; when set, a login that now belongs
+// to another account, or an account whose login changed, is reported as renamed.
+type UserLookup struct {
+	Login string
+	ID    int64
+}
+
+// InvalidUser is a user that failed validation, with the reason and a human readable detail
+type InvalidUser struct {
+	Login  string        `json:"login"`
+	Reason InvalidReason `json:"reason"`
+	Detail string        `json:"detail,omitempty"`
+}
+
 // UserValidationResult contains the results of user validation
 type UserValidationResult struct {
 	ValidUsers   []string
 	InvalidUsers []string
+	// Invalid gives the reason each of InvalidUsers was rejected, in the same order
+	Invalid []InvalidUser
+}
+
+func (r *UserValidationResult) reject(login string, reason InvalidReason, detail string) {
+	r.InvalidUsers = append(r.InvalidUsers, login)
+	r.Invalid = append(r.Invalid, InvalidUser{Login: login, Reason: reason, Detail: detail})
 }
 
-// ValidateAndFilterUsers checks if all provided usernames exist in GitHub Enterprise
-// Returns a UserValidationResult with valid and invalid user lists
+// ValidateAndFilterUsers checks that the provided usernames are users that can join the lab
 func ValidateAndFilterUsers(ctx context.Context, logger *slog.Logger, usernames []string) (*UserValidationResult, error) {
-	if len(usernames) == 0 {
-		return &UserValidationResult{
-			ValidUsers:   []string{},
-			InvalidUsers: []string{},
-		}, nil
+	users := make([]UserLookup, len(usernames))
+	for i, username := range usernames {
+		users[i] = UserLookup{Login: username}
+	}
+	return ValidateUsers(ctx, logger, users)
+}
+
+// ValidateUsers looks users up in batches with aliased GraphQL queries and sorts them into valid
+// and invalid users, keeping the input order. Transient failures such as 5xx responses and rate
+// limits are retried; users that still cannot be checked are reported with ReasonLookupFailed.
+// An empty list of valid users is not an error; callers decide whether the lab can go ahead.
+func ValidateUsers(ctx context.Context, logger *slog.Logger, users []UserLookup) (*UserValidationResult, error) {
+	result := &UserValidationResult{
+		ValidUsers:   []string{},
+		InvalidUsers: []string{},
+	}
+	if len(users) == 0 {
+		return result, nil
+	}
+
+	logger.Info("Validating users", slog.Int("count", len(users)))
+
+	owners := make(map[string]*userOwner, len(users))
+	lookupErrors := make(map[string]error)
+
+	var toQuery []string
+	for _, user := range users {
+		if !strings.HasSuffix(strings.ToLower(user.Login), "[bot]") {
+			toQuery = append(toQuery, user.Login)
+		}
+	}
+
+	for start := 0; start < len(toQuery); start += userValidationBatchSize {
+		end := min(start+userValidationBatchSize, len(toQuery))
+		batch := toQuery[start:end]
+
+		found, failed, err := lookupUserBatch(ctx, logger, batch)
+		if err != nil {
+			// The whole batch could not be checked; the users are reported rather than aborting the lab
+			logger.Error("User lookup failed after retries",
+				slog.Int("batch_size", len(batch)),
+				slog.Any("error", err))
+			for _, login := range batch {
+				lookupErrors[login] = err
+			}
+			continue
+		}
+		for login, owner := range found {
+			owners[login] = owner
+		}
+		for login, err := range failed {
+			lookupErrors[login] = err
+		}
+	}
+
+	for _, user := range users {
+		login := user.Login
+		owner := owners[login]
+		lookupErr := lookupErrors[login]
+
+		switch {
+		case strings.HasSuffix(strings.ToLower(login), "[bot]"):
+			result.reject(login, ReasonBot, "bot accounts cannot join lab organizations")
+		case errors.Is(lookupErr, errUserSuspended):
+			result.reject(login, ReasonSuspended, "the account is suspended")
+		case lookupErr != nil && !errors.Is(lookupErr, ErrNotFound):
+			result.reject(login, ReasonLookupFailed, lookupErr.Error())
+		case owner == nil:
+			detail := "no GitHub account has this login"
+			if user.ID != 0 {
+				if current, err := getUserLoginByID(ctx, logger, user.ID); err == nil {
+					result.reject(login, ReasonRenamed, fmt.Sprintf("account %d is now @%s", user.ID, current))
+					continue
+				}
+			}
+			result.reject(login, ReasonNotFound, detail)
+		case owner.Typename == "Organization":
+			result.reject(login, ReasonOrganization, "the login belongs to an organization")
+		case owner.Typename == "Bot":
+			result.reject(login, ReasonBot, "bot accounts cannot join lab organizations")
+		case user.ID != 0 && owner.DatabaseID != user.ID:
+			detail := fmt.Sprintf("the login now belongs to account %d, not %d", owner.DatabaseID, user.ID)
+			if current, err := getUserLoginByID(ctx, logger, user.ID); err == nil {
+				detail += fmt.Sprintf("; account %d is now @%s", user.ID, current)
+			}
+			result.reject(login, ReasonRenamed, detail)
+		default:
+			result.ValidUsers = append(result.ValidUsers, login)
+		}
+	}
+
+	for _, invalid := range result.Invalid {
+		logger.Warn("User will be skipped",
+			slog.String("username", invalid.Login),
+			slog.String("reason", string(invalid.Reason)),
+			slog.String("detail", invalid.Detail))
+	}
+
+	logger.Info("User validation complete",
+		slog.Int("valid_count", len(result.ValidUsers)),
+		slog.Int("invalid_count", len(result.InvalidUsers)))
+
+	return result, nil
+}
+
+// errUserSuspended marks a lookup GitHub rejected because the account is suspended
+var errUserSuspended = errors.New("user is suspended")
+
+// errTransient marks a failure worth retrying: a network error, 5xx response or rate limit
+var errTransient = errors.New("transient failure")
+
+// userOwner is the account returned for one alias of the batch query
+type userOwner struct {
+	Typename   string `json:"__typename"`
+	Login      string `json:"login"`
+	DatabaseID int64  `json:"databaseId"`
+}
+
+// lookupUserBatch resolves up to userValidationBatchSize logins with one aliased GraphQL query,
+// retrying transient failures: of the whole query, or of single logins whose error is neither
+// NOT_FOUND nor a suspension, such as RATE_LIMITED or a timeout. It returns the accounts found and
+// the per-login lookup errors left after the last attempt.
+func lookupUserBatch(ctx context.Context, logger *slog.Logger, logins []string) (map[string]*userOwner, map[string]error, error) {
+	found := make(map[string]*userOwner, len(logins))
+	failed := make(map[string]error)
+
+	pending := logins
+	for attempt := 1; ; attempt++ {
+		batchFound, batchFailed, retryAfter, err := queryUserBatch(ctx, logger, pending)
+		if err != nil && (!errors.Is(err, errTransient) || attempt == userValidationAttempts) {
+			return nil, nil, err
+		}
+
+		var retry []string
+		if err == nil {
+			for _, login := range pending {
+				delete(failed, login)
+				if owner, ok := batchFound[login]; ok {
+					found[login] = owner
+				}
+				lookupErr, ok := batchFailed[login]
+				if !ok {
+					continue
+				}
+				failed[login] = lookupErr
+				if !errors.Is(lookupErr, ErrNotFound) && !errors.Is(lookupErr, errUserSuspended) {
+					retry = append(retry, login)
+				}
+			}
+			if len(retry) == 0 || attempt == userValidationAttempts {
+				return found, failed, nil
+			}
+			pending = retry
+			err = fmt.Errorf("lookup of %d logins failed: %w", len(retry), batchFailed[retry[0]])
+		}
+
+		wait := userValidationBackoff << (attempt - 1)
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		logger.Warn("Retrying user lookup",
+			slog.Int("attempt", attempt),
+			slog.Int("logins", len(pending)),
+			slog.Duration("wait", wait),
+			slog.Any("error", err))
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// queryUserBatch sends one aliased GraphQL query for the logins and sorts its answer into the accounts
+// found and the per-login errors. Failures of the whole query that are worth retrying wrap errTransient,
+// with the Retry-After delay GitHub asked for, if any.
+func queryUserBatch(ctx context.Context, logger *slog.Logger, logins []string) (map[string]*userOwner, map[string]error, time.Duration, error) {
+	var query strings.Builder
+	var params []string
+	variables := make(map[string]interface{}, len(logins))
+	for i, login := range logins {
+		params = append(params, fmt.Sprintf("$l%d: String!", i))
+		variables[fmt.Sprintf("l%d", i)] = login
+		fmt.Fprintf(&query, "\t\t\tu%d: repositoryOwner(login: $l%d) { __typename login ... on User { databaseId } ... on Organization { databaseId } }\n", i, i)
+	}
+	payload := map[string]interface{}{
+		"query":     fmt.Sprintf("query(%s) {\n%s\t\t}", strings.Join(params, ", "), query.String()),
+		"variables": variables,
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		logger.Error("Failed to marshal GraphQL payload", slog.Any("error", err))
+		return nil, nil, 0, fmt.Errorf("failed to marshal GraphQL payload: %w", err)
+	}
+
+	body, retryAfter, err := postUserBatch(ctx, logger, jsonData)
+	if err != nil {
+		return nil, nil, retryAfter, err
+	}
+
+	var result struct {
+		Data   map[string]*userOwner `json:"data"`
+		Errors []struct {
+			Type    string        `json:"type"`
+			Message string        `json:"message"`
+			Path    []interface{} `json:"path"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		logger.Error("Failed to parse response", slog.Any("error", err))
+		return nil, nil, 0, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	found := make(map[string]*userOwner, len(logins))
+	failed := make(map[string]error)
+	for i, login := range logins {
+		if owner := result.Data[fmt.Sprintf("u%d", i)]; owner != nil {
+			found[login] = owner
+		}
+	}
+
+	// Errors name the alias they belong to; a missing account is reported as NOT_FOUND. A rate limited
+	// query answers 200 with a RATE_LIMITED error for the whole query.
+	for _, gqlErr := range result.Errors {
+		if len(gqlErr.Path) == 0 && gqlErr.Type == "RATE_LIMITED" {
+			logger.Warn("GraphQL rate limit reached looking up users")
+			return nil, nil, retryAfter, fmt.Errorf("%w: GraphQL rate limit exceeded", errTransient)
+		}
+		if len(gqlErr.Path) == 0 {
+			return nil, nil, 0, fmt.Errorf("GraphQL errors: %s", gqlErr.Message)
+		}
+		alias, _ := gqlErr.Path[0].(string)
+		index, convErr := strconv.Atoi(strings.TrimPrefix(alias, "u"))
+		if convErr != nil || index < 0 || index >= len(logins) {
+			continue
+		}
+		login := logins[index]
+		switch {
+		case gqlErr.Type == "NOT_FOUND":
+			failed[login] = ErrNotFound
+		case strings.Contains(strings.ToLower(gqlErr.Message), "suspended"):
+			failed[login] = errUserSuspended
+		default:
+			failed[login] = fmt.Errorf("%s", gqlErr.Message)
+		}
 	}
 
-	logger.Info("Validating users", slog.Int("count", len(usernames)))
+	return found, failed, 0, nil
+}
 
-	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+// postUserBatch sends one batch query and returns its body with the Retry-After delay GitHub asked
+// for, if any. Failures worth retrying wrap errTransient.
+func postUserBatch(ctx context.Context, logger *slog.Logger, jsonData []byte) ([]byte, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
 	// Use the existing roundtripper infrastructure
@@ -38,111 +341,149 @@ func ValidateAndFilterUsers(ctx context.Context, logger *slog.Logger, usernames
 		Transport: rt,
 	}
 
-	baseURL := ctx.Value(config.BaseURLKey).(string)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, graphQLURL(ctx), bytes.NewBuffer(jsonData))
+	if err != nil {
+		logger.Error("Failed to create request", slog.Any("error", err))
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
 
-	// Use channels and goroutines for concurrent validation
-	type validationResult struct {
-		username string
-		valid    bool
-		err      error
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Error("Failed to execute request", slog.Any("error", err))
+		return nil, 0, fmt.Errorf("%w: failed to execute request: %v", errTransient, err)
 	}
+	defer resp.Body.Close()
 
-	resultChan := make(chan validationResult, len(usernames))
-	var wg sync.WaitGroup
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error("Failed to read response body", slog.Any("error", err))
+		return nil, 0, fmt.Errorf("%w: failed to read response body: %v", errTransient, err)
+	}
 
-	// Validate users concurrently (max 10 at a time to avoid rate limits)
-	semaphore := make(chan struct{}, 10)
+	retryAfter := time.Duration(0)
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
 
-	for _, username := range usernames {
-		wg.Add(1)
-		go func(user string) {
-			defer wg.Done()
+	rateLimited := resp.StatusCode == http.StatusTooManyRequests ||
+		(resp.StatusCode == http.StatusForbidden && (resp.Header.Get("X-RateLimit-Remaining") == "0" || retryAfter > 0))
+	if resp.StatusCode >= 500 || rateLimited {
+		logger.Warn("Transient failure looking up users",
+			slog.Int("status_code", resp.StatusCode),
+			slog.String("response", string(body)))
+		return nil, retryAfter, fmt.Errorf("%w: GraphQL request failed with status %d: %s", errTransient, resp.StatusCode, string(body))
+	}
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("GraphQL request failed",
+			slog.Int("status_code", resp.StatusCode),
+			slog.String("response", string(body)))
+		return nil, 0, fmt.Errorf("GraphQL request failed with status %d: %s", resp.StatusCode, string(body))
+	}
 
-			// Acquire semaphore
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }()
+	return body, retryAfter, nil
+}
 
-			// Check if context is cancelled
-			select {
-			case <-ctx.Done():
-				resultChan <- validationResult{username: user, valid: false, err: ctx.Err()}
-				return
-			default:
-			}
+// getUserLoginByID returns the current login of the account with the given ID
+func getUserLoginByID(ctx context.Context, logger *slog.Logger, id int64) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
 
-			userURL := fmt.Sprintf("%s/users/%s", baseURL, user)
-			req, err := http.NewRequestWithContext(ctx, http.MethodGet, userURL, nil)
-			if err != nil {
-				resultChan <- validationResult{username: user, valid: false, err: err}
-				return
-			}
+	rt := NewGithubStyleTransport(ctx, logger, config.EnterpriseType)
+	client := &http.Client{
+		Transport: rt,
+	}
 
-			resp, err := client.Do(req)
-			if err != nil {
-				resultChan <- validationResult{username: user, valid: false, err: err}
-				return
-			}
-			resp.Body.Close()
-
-			if resp.StatusCode == http.StatusNotFound {
-				logger.Warn("User not found - will be skipped", slog.String("username", user))
-				resultChan <- validationResult{username: user, valid: false, err: nil}
-			} else if resp.StatusCode != http.StatusOK {
-				logger.Warn("Unexpected status for user - will be skipped",
-					slog.String("username", user),
-					slog.Int("status", resp.StatusCode))
-				resultChan <- validationResult{username: user, valid: false, err: fmt.Errorf("unexpected status: %d", resp.StatusCode)}
-			} else {
-				logger.Info("User validated", slog.String("username", user))
-				resultChan <- validationResult{username: user, valid: true, err: nil}
-			}
-		}(username)
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/user/%d", baseURL, id), nil)
+	if err != nil {
+		logger.Error("Failed to create request", slog.Any("error", err))
+		return "", fmt.Errorf("failed to create request: %w", err)
 	}
 
-	// Close channel when all goroutines complete
-	go func() {
-		wg.Wait()
-		close(resultChan)
-	}()
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Error("Failed to execute request", slog.Any("error", err))
+		return "", fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
 
-	// Collect results - preserve original order
-	validationMap := make(map[string]bool)
-	invalidUsers := []string{}
+	if resp.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("account %d: %w", id, ErrNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("failed to get account %d with status %d: %s", id, resp.StatusCode, string(body))
+	}
 
-	for result := range resultChan {
-		if result.valid {
-			validationMap[result.username] = true
-		} else {
-			invalidUsers = append(invalidUsers, result.username)
-		}
+	var user struct {
+		Login string `json:"login"`
 	}
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		logger.Error("Failed to parse response", slog.Any("error", err))
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	return user.Login, nil
+}
 
-	// Build valid users list maintaining original order
-	validUsers := make([]string, 0, len(usernames))
-	for _, username := range usernames {
-		if validationMap[username] {
-			validUsers = append(validUsers, username)
-		}
+// GetTokenInfo retrieves the user behind the Personal Access Token and, for classic tokens,
+// the OAuth scopes GitHub reports in the X-OAuth-Scopes header
+func GetTokenInfo(ctx context.Context, logger *slog.Logger) (*TokenInfo, error) {
+	logger.Info("Fetching token owner and scopes")
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	rt := NewGithubStyleTransport(ctx, logger, config.EnterpriseType)
+	client := &http.Client{
+		Transport: rt,
+	}
+
+	baseURL := ctx.Value(config.BaseURLKey).(string)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/user", nil)
+	if err != nil {
+		logger.Error("Failed to create request", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	if len(invalidUsers) > 0 {
-		logger.Warn("Invalid users found and removed",
-			slog.Any("invalid_users", invalidUsers),
-			slog.Int("invalid_count", len(invalidUsers)),
-			slog.Int("valid_count", len(validUsers)),
-			slog.Int("total_count", len(usernames)))
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Error("Failed to execute request", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
+	defer resp.Body.Close()
 
-	if len(validUsers) == 0 {
-		return nil, fmt.Errorf("no valid users found after validation")
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logger.Error("Failed to read response body", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	logger.Info("User validation complete",
-		slog.Int("valid_count", len(validUsers)),
-		slog.Int("invalid_count", len(invalidUsers)))
+	if resp.StatusCode != http.StatusOK {
+		logger.Error("Failed to get authenticated user",
+			slog.Int("status_code", resp.StatusCode),
+			slog.String("response", string(body)))
+		return nil, fmt.Errorf("failed to get authenticated user with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var user struct {
+		Login string `json:"login"`
+	}
+	if err := json.Unmarshal(body, &user); err != nil {
+		logger.Error("Failed to parse response", slog.Any("error", err))
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	info := &TokenInfo{Login: user.Login}
+	if header, ok := resp.Header["X-Oauth-Scopes"]; ok {
+		info.Classic = true
+		for _, scope := range strings.Split(strings.Join(header, ","), ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				info.Scopes = append(info.Scopes, scope)
+			}
+		}
+	}
 
-	return &UserValidationResult{
-		ValidUsers:   validUsers,
-		InvalidUsers: invalidUsers,
-	}, nil
+	return info, nil
 }
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// shortBackoff makes retried user lookups wait a millisecond for the rest of the test
+func shortBackoff(t *testing.T) {
+	backoff := userValidationBackoff
+	userValidationBackoff = time.Millisecond
+	t.Cleanup(func() { userValidationBackoff = backoff })
+}
+
+func TestValidateUsers(t *testing.T) {
+	// The server answers each alias of the batch query from the login it was asked for
+	owners := map[string]string{
+		"alice":   `{"__typename":"User","login":"alice","databaseId":1}`,
+		"renamed": `{"__typename":"User","login":"renamed","databaseId":7}`,
+		"octo":    `{"__typename":"Organization","login":"octo","databaseId":2}`,
+		"robot":   `{"__typename":"Bot","login":"robot","databaseId":3}`,
+	}
+	errs := map[string]string{
+		"ghost":  `{"type":"NOT_FOUND","message":"Could not resolve to a User with the login of 'ghost'."}`,
+		"frozen": `{"type":"FORBIDDEN","message":"Account is suspended."}`,
+		"flakey": `{"type":"INTERNAL","message":"Something went wrong while executing your query."}`,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /graphql", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer test-token" {
+			t.Errorf("Authorization = %q", got)
+		}
+		var payload struct {
+			Query     string            `json:"query"`
+			Variables map[string]string `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Fatalf("decoding query: %v", err)
+		}
+		var data, gqlErrors []string
+		for variable, login := range payload.Variables {
+			alias := "u" + strings.TrimPrefix(variable, "l")
+			if !strings.Contains(payload.Query, alias+": repositoryOwner(login: $"+variable+")") {
+				t.Errorf("query has no alias %s for %s", alias, login)
+			}
+			if owner, ok := owners[login]; ok {
+				data = append(data, `"`+alias+`":`+owner)
+				continue
+			}
+			data = append(data, `"`+alias+`":null`)
+			if gqlErr, ok := errs[login]; ok {
+				gqlErrors = append(gqlErrors, strings.TrimSuffix(gqlErr, "}")+`,"path":["`+alias+`"]}`)
+			}
+		}
+		io.WriteString(w, `{"data":{`+strings.Join(data, ",")+`},"errors":[`+strings.Join(gqlErrors, ",")+`]}`)
+	})
+	mux.HandleFunc("GET /user/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") != "9" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, `{"login":"new-name"}`)
+	})
+	ctx := testContext(t, mux)
+	shortBackoff(t)
+
+	tests := []struct {
+		name   string
+		user   UserLookup
+		reason InvalidReason
+		detail string
+	}{
+		{"user", UserLookup{Login: "alice"}, "", ""},
+		{"user with matching id", UserLookup{Login: "alice", ID: 1}, "", ""},
+		{"organization", UserLookup{Login: "octo"}, ReasonOrganization, "organization"},
+		{"bot account", UserLookup{Login: "robot"}, ReasonBot, "bot"},
+		{"bot login", UserLookup{Login: "dependabot[bot]"}, ReasonBot, "bot"},
+		{"not found", UserLookup{Login: "ghost"}, ReasonNotFound, "no GitHub account"},
+		{"suspended", UserLookup{Login: "frozen"}, ReasonSuspended, "suspended"},
+		{"alias error", UserLookup{Login: "flakey"}, ReasonLookupFailed, "Something went wrong"},
+		{"login taken by another account", UserLookup{Login: "renamed", ID: 9}, ReasonRenamed, "account 9 is now @new-name"},
+		{"account renamed away", UserLookup{Login: "ghost", ID: 9}, ReasonRenamed, "account 9 is now @new-name"},
+	}
+
+	users := make([]UserLookup, len(tests))
+	for i, tt := range tests {
+		users[i] = tt.user
+	}
+	result, err := ValidateUsers(ctx, testLogger(), users)
+	if err != nil {
+		t.Fatalf("ValidateUsers: %v", err)
+	}
+
+	valid := map[string]bool{}
+	for _, login := range result.ValidUsers {
+		valid[login] = true
+	}
+	if len(result.Invalid) != len(result.InvalidUsers) {
+		t.Fatalf("%d reasons for %d invalid users", len(result.Invalid), len(result.InvalidUsers))
+	}
+
+	next := 0
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.reason == "" {
+				if !valid[tt.user.Login] {
+					t.Errorf("%s was rejected", tt.user.Login)
+				}
+				return
+			}
+			// Invalid users are reported in input order
+			invalid := result.Invalid[next]
+			next++
+			if invalid.Login != tt.user.Login || invalid.Reason != tt.reason {
+				t.Errorf("got %s %s, want %s %s", invalid.Login, invalid.Reason, tt.user.Login, tt.reason)
+			}
+			if !strings.Contains(invalid.Detail, tt.detail) {
+				t.Errorf("detail = %q, want it to contain %q", invalid.Detail, tt.detail)
+			}
+		})
+	}
+}
+
+func TestValidateUsersBatches(t *testing.T) {
+	queries := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /graphql", func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		var payload struct {
+			Variables map[string]string `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Fatalf("decoding query: %v", err)
+		}
+		if len(payload.Variables) > userValidationBatchSize {
+			t.Errorf("batch of %d logins", len(payload.Variables))
+		}
+		var data []string
+		for variable, login := range payload.Variables {
+			data = append(data, `"u`+strings.TrimPrefix(variable, "l")+`":{"__typename":"User","login":"`+login+`"}`)
+		}
+		io.WriteString(w, `{"data":{`+strings.Join(data, ",")+`}}`)
+	})
+	ctx := testContext(t, mux)
+
+	var logins []string
+	for i := range userValidationBatchSize*2 + 1 {
+		logins = append(logins, "user"+strings.Repeat("x", i))
+	}
+	result, err := ValidateAndFilterUsers(ctx, testLogger(), logins)
+	if err != nil {
+		t.Fatalf("ValidateAndFilterUsers: %v", err)
+	}
+	if queries != 3 {
+		t.Errorf("%d queries, want 3", queries)
+	}
+	if len(result.ValidUsers) != len(logins) {
+		t.Errorf("%d valid users, want %d", len(result.ValidUsers), len(logins))
+	}
+	for i, login := range result.ValidUsers {
+		if login != logins[i] {
+			t.Fatalf("valid user %d is %s, want %s", i, login, logins[i])
+		}
+	}
+}
+
+func TestValidateUsersQueryError(t *testing.T) {
+	// An error without a path fails the whole batch; its users are reported, not dropped
+	ctx := testContext(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"data":null,"errors":[{"message":"Field 'repositoryOwner' is missing"}]}`)
+	}))
+
+	result, err := ValidateAndFilterUsers(ctx, testLogger(), []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("ValidateAndFilterUsers: %v", err)
+	}
+	if len(result.ValidUsers) != 0 || len(result.Invalid) != 2 {
+		t.Fatalf("got %d valid and %d invalid users, want 0 and 2", len(result.ValidUsers), len(result.Invalid))
+	}
+	for _, invalid := range result.Invalid {
+		if invalid.Reason != ReasonLookupFailed || !strings.Contains(invalid.Detail, "repositoryOwner") {
+			t.Errorf("%s: %s %q", invalid.Login, invalid.Reason, invalid.Detail)
+		}
+	}
+}
+
+func TestValidateUsersRetriesAliasErrors(t *testing.T) {
+	// busy is rate limited twice, down never recovers, ghost does not exist and is not asked for again
+	var queried [][]string
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /graphql", func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Variables map[string]string `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Fatalf("decoding query: %v", err)
+		}
+		var logins, data, gqlErrors []string
+		for variable, login := range payload.Variables {
+			logins = append(logins, login)
+			alias := "u" + strings.TrimPrefix(variable, "l")
+			gqlErr := ""
+			switch {
+			case login == "ghost":
+				gqlErr = `"type":"NOT_FOUND","message":"Could not resolve to a User with the login of 'ghost'."`
+			case login == "down":
+				gqlErr = `"type":"SERVICE_UNAVAILABLE","message":"Service unavailable"`
+			case login == "busy" && len(queried) < 2:
+				gqlErr = `"type":"RATE_LIMITED","message":"API rate limit exceeded"`
+			}
+			if gqlErr != "" {
+				data = append(data, `"`+alias+`":null`)
+				gqlErrors = append(gqlErrors, `{`+gqlErr+`,"path":["`+alias+`"]}`)
+				continue
+			}
+			data = append(data, `"`+alias+`":{"__typename":"User","login":"`+login+`"}`)
+		}
+		slices.Sort(logins)
+		queried = append(queried, logins)
+		io.WriteString(w, `{"data":{`+strings.Join(data, ",")+`},"errors":[`+strings.Join(gqlErrors, ",")+`]}`)
+	})
+	ctx := testContext(t, mux)
+	shortBackoff(t)
+
+	result, err := ValidateAndFilterUsers(ctx, testLogger(), []string{"alice", "busy", "down", "ghost"})
+	if err != nil {
+		t.Fatalf("ValidateAndFilterUsers: %v", err)
+	}
+	if !slices.Equal(result.ValidUsers, []string{"alice", "busy"}) {
+		t.Errorf("valid users = %v, want [alice busy]", result.ValidUsers)
+	}
+	reasons := map[string]InvalidReason{}
+	for _, invalid := range result.Invalid {
+		reasons[invalid.Login] = invalid.Reason
+	}
+	if reasons["down"] != ReasonLookupFailed || reasons["ghost"] != ReasonNotFound || len(reasons) != 2 {
+		t.Errorf("invalid users = %v, want down %s and ghost %s", reasons, ReasonLookupFailed, ReasonNotFound)
+	}
+
+	want := [][]string{{"alice", "busy", "down", "ghost"}, {"busy", "down"}, {"busy", "down"}, {"down"}}
+	if len(queried) != len(want) {
+		t.Fatalf("queried %v, want %v", queried, want)
+	}
+	for i := range want {
+		if !slices.Equal(queried[i], want[i]) {
+			t.Errorf("query %d asked for %v, want %v", i+1, queried[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
+)
+
+const (
+	barWidth        = 30
+	maxSubjectLines = 15
+	maxDetailLength = 70
+	refreshInterval = 250 * time.Millisecond
+)
+
+type phase struct {
+	name  string
+	total int
+	done  int
+}
+
+type subjectStatus struct {
+	name    string
+	state   State
+	detail  string
+	updated time.Time
+}
+
+// Console renders a live, redrawn progress view (phase bars, per-subject status lines and ETA)
+// to a terminal. It should only be used when the output is a TTY.
+type Console struct {
+	mu       sync.Mutex
+	out      io.Writer
+	title    string
+	started  time.Time
+	phases   []*phase
+	subjects map[string]*subjectStatus
+	lines    int
+	finished bool
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+// NewConsole creates a Console and starts redrawing it in the background
+func NewConsole(out io.Writer, title string) *Console {
+	c := &Console{
+		out:      out,
+		title:    title,
+		started:  time.Now(),
+		subjects: make(map[string]*subjectStatus),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	go c.loop()
+
+	return c
+}
+
+func (c *Console) loop() {
+	defer close(c.stopped)
+
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			c.render()
+			c.mu.Unlock()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// AddPhase registers a phase or increases the total of an existing one
+func (c *Console) AddPhase(name string, total int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if p := c.phase(name); p != nil {
+		p.total += total
+		return
+	}
+	c.phases = append(c.phases, &phase{name: name, total: total})
+}
+
+// Advance marks n units of work in the phase as processed
+func (c *Console) Advance(name string, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if p := c.phase(name); p != nil {
+		p.done += n
+		if p.done > p.total {
+			p.done = p.total
+		}
+	}
+}
+
+// SetStatus updates the status line for a subject
+func (c *Console) SetStatus(subject string, state State, detail string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, ok := c.subjects[subject]
+	if !ok {
+		s = &subjectStatus{name: subject}
+		c.subjects[subject] = s
+	}
+	s.state = state
+	s.detail = util.Redact(detail)
+	s.updated = time.Now()
+}
+
+// Finish stops the background redraw and renders the final frame
+func (c *Console) Finish() {
+	c.mu.Lock()
+	if c.finished {
+		c.mu.Unlock()
+		return
+	}
+	c.finished = true
+	c.mu.Unlock()
+
+	close(c.stop)
+	<-c.stopped
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.render()
+	fmt.Fprintln(c.out)
+}
+
+func (c *Console) phase(name string) *phase {
+	for _, p := range c.phases {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// render redraws the whole view in place. Callers must hold c.mu.
+func (c *Console) render() {
+	var b strings.Builder
+
+	// Move the cursor back to the top of the previous frame
+	if c.lines > 0 {
+		fmt.Fprintf(&b, "\033[%dA", c.lines)
+	}
+
+	lines := c.frame()
+	for _, line := range lines {
+		b.WriteString("\033[2K")
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	// Clear any leftover lines from a taller previous frame
+	for i := len(lines); i < c.lines; i++ {
+		b.WriteString("\033[2K\n")
+	}
+	if c.lines > len(lines) {
+		fmt.Fprintf(&b, "\033[%dA", c.lines-len(lines))
+	}
+
+	c.lines = len(lines)
+	io.WriteString(c.out, b.String())
+}
+
+// frame builds the text lines of the current view. Callers must hold c.mu.
+func (c *Console) frame() []string {
+	elapsed := time.Since(c.started)
+	lines := []string{
+		fmt.Sprintf("%s  elapsed %s  ETA %s", c.title, elapsed.Round(time.Second), c.eta(elapsed)),
+		"",
+	}
+
+	if len(c.phases) == 0 {
+		lines = append(lines, "  Preparing...")
+	}
+
+	nameWidth := 0
+	for _, p := range c.phases {
+		if len(p.name) > nameWidth {
+			nameWidth = len(p.name)
+		}
+	}
+	for _, p := range c.phases {
+		lines = append(lines, fmt.Sprintf("  %-*s %s %d/%d", nameWidth, p.name, bar(p.done, p.total), p.done, p.total))
+	}
+
+	if len(c.subjects) == 0 {
+		return lines
+	}
+
+	counts := map[State]int{}
+	subjects := make([]*subjectStatus, 0, len(c.subjects))
+	for _, s := range c.subjects {
+		counts[s.state]++
+		subjects = append(subjects, s)
+	}
+
+	lines = append(lines, "", fmt.Sprintf("  ✅ %d done  ❌ %d failed  ⏳ %d running  ⋯ %d pending",
+		counts[StateDone], counts[StateFailed], counts[StateRunning], counts[StatePending]))
+
+	// Running subjects first, then failures, then everything else; most recently updated first
+	sort.Slice(subjects, func(i, j int) bool {
+		ri, rj := stateRank(subjects[i].state), stateRank(subjects[j].state)
+		if ri != rj {
+			return ri < rj
+		}
+		return subjects[i].updated.After(subjects[j].updated)
+	})
+
+	subjectWidth := 0
+	for _, s := range subjects {
+		if len(s.name) > subjectWidth {
+			subjectWidth = len(s.name)
+		}
+	}
+
+	shown := 0
+	for _, s := range subjects {
+		if s.state == StateDone || s.state == StatePending {
+			continue
+		}
+		if shown == maxSubjectLines {
+			break
+		}
+		lines = append(lines, fmt.Sprintf("  %s %-*s %s", stateIcon(s.state), subjectWidth, s.name, truncate(s.detail, maxDetailLength)))
+		shown++
+	}
+	if hidden := counts[StateRunning] + counts[StateFailed] - shown; hidden > 0 {
+		lines = append(lines, fmt.Sprintf("  ... and %d more", hidden))
+	}
+
+	return lines
+}
+
+// eta estimates the remaining time from the overall fraction of processed work
+func (c *Console) eta(elapsed time.Duration) string {
+	total, done := 0, 0
+	for _, p := range c.phases {
+		total += p.total
+		done += p.done
+	}
+	if done == 0 || total == 0 {
+		return "-"
+	}
+	if done >= total {
+		return "0s"
+	}
+	remaining := time.Duration(float64(elapsed) / float64(done) * float64(total-done))
+	return remaining.Round(time.Second).String()
+}
+
+func bar(done, total int) string {
+	filled := 0
+	if total > 0 {
+		filled = done * barWidth / total
+	}
+	return "[" + strings.Repeat("█", filled) + strings.Repeat("░", barWidth-filled) + "]"
+}
+
+func stateRank(state State) int {
+	switch state {
+	case StateRunning:
+		return 0
+	case StateFailed:
+		return 1
+	case StatePending:
+		return 2
+	default:
+		return 3
+	}
+}
+
+func stateIcon(state State) string {
+	switch state {
+	case StateRunning:
+		return "⏳"
+	case StateFailed:
+		return "❌"
+	case StateDone:
+		return "✅"
+	default:
+		return "⋯"
+	}
+}
+
+func truncate(s string, max int) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	if len([]rune(s)) <= max {
+		return s
+	}
+	return string([]rune(s)[:max-3]) + "..."
+}
@@ -0,0 +1,47 @@
+package progress
+
+import (
+	"context"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+)
+
+// State describes where a single subject (usually a student) is in the provisioning flow
+type State int
+
+const (
+	StatePending State = iota
+	StateRunning
+	StateDone
+	StateFailed
+)
+
+// Tracker receives progress events from the provisioning services.
+// Implementations must be safe for concurrent use by multiple workers.
+type Tracker interface {
+	// AddPhase registers a phase (e.g. "Create orgs") with the amount of work expected in it.
+	// Calling it again for an existing phase increases its total.
+	AddPhase(name string, total int)
+	// Advance marks n units of work in the phase as processed, whether they succeeded or not.
+	Advance(name string, n int)
+	// SetStatus updates the status line shown for a subject.
+	SetStatus(subject string, state State, detail string)
+	// Finish renders the final state and stops any background rendering. It is safe to call more than once.
+	Finish()
+}
+
+// FromContext returns the tracker stored in the context, or a no-op tracker if none is set
+func FromContext(ctx context.Context) Tracker {
+	if tracker, ok := ctx.Value(config.ProgressKey).(Tracker); ok && tracker != nil {
+		return tracker
+	}
+	return nopTracker{}
+}
+
+// nopTracker discards all progress events; used when stdout is not a terminal
+type nopTracker struct{}
+
+func (nopTracker) AddPhase(string, int)            {}
+func (nopTracker) Advance(string, int)             {}
+func (nopTracker) SetStatus(string, State, string) {}
+func (nopTracker) Finish()                         {}
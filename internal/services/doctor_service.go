@@ -0,0 +1,316 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/auth"
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+	api "github.com/s-samadi/ghas-lab-builder/internal/github"
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
+)
+
+// CheckStatus is the outcome of a single doctor check
+type CheckStatus string
+
+const (
+	CheckPass CheckStatus = "PASS"
+	CheckWarn CheckStatus = "WARN"
+	CheckFail CheckStatus = "FAIL"
+)
+
+// lowRateLimitThreshold is the remaining REST quota below which doctor warns before a lab run
+const lowRateLimitThreshold = 500
+
+// Classic PAT scopes needed by lab commands; delete_repo is only needed to delete repositories
+var (
+	requiredTokenScopes = []string{"admin:enterprise", "admin:org", "repo"}
+	deleteTokenScopes   = []string{"delete_repo"}
+	// workflowTokenScopes are needed to push, pin or import repositories with GitHub Actions workflows
+	workflowTokenScopes = []string{"workflow"}
+)
+
+// requiredAppPermissions are the repository and organization permissions the app needs in lab organizations:
+// contents to pin and import repositories, issues and pull requests to seed them, and members and custom
+// organization roles to set up teams and staff roles
+var requiredAppPermissions = map[string]string{
+	"administration":                "write",
+	"contents":                      "write",
+	"issues":                        "write",
+	"pull_requests":                 "write",
+	"members":                       "write",
+	"organization_custom_org_roles": "write",
+}
+
+// DoctorCheck is the result of one preflight check
+type DoctorCheck struct {
+	Name   string
+	Status CheckStatus
+	Detail string
+	// Hint tells the user how to fix a warning or failure
+	Hint string
+}
+
+// DoctorReport collects the results of all preflight checks
+type DoctorReport struct {
+	Checks []DoctorCheck
+}
+
+func (r *DoctorReport) add(name string, status CheckStatus, detail string, hint string) {
+	r.Checks = append(r.Checks, DoctorCheck{Name: name, Status: status, Detail: detail, Hint: hint})
+}
+
+// Failures returns the number of failed checks
+func (r *DoctorReport) Failures() int {
+	count := 0
+	for _, check := range r.Checks {
+		if check.Status == CheckFail {
+			count++
+		}
+	}
+	return count
+}
+
+// Print writes the checks as a checklist, with fix hints under warnings and failures
+func (r *DoctorReport) Print(w io.Writer) {
+	for _, check := range r.Checks {
+		fmt.Fprintf(w, "[%s] %s: %s\n", check.Status, check.Name, util.Redact(check.Detail))
+		if check.Hint != "" && check.Status != CheckPass {
+			fmt.Fprintf(w, "       → %s\n", check.Hint)
+		}
+	}
+
+	warnings := 0
+	for _, check := range r.Checks {
+		if check.Status == CheckWarn {
+			warnings++
+		}
+	}
+	fmt.Fprintf(w, "\n%d checks, %d failed, %d warnings\n", len(r.Checks), r.Failures(), warnings)
+}
+
+// RunDoctor checks that the configured credentials can run a lab: token scopes or app permissions,
+// enterprise access, app installation on organizations, template repositories and rate limit.
+// templateReposFile is optional. Checks keep running after a failure so every problem is reported.
+func RunDoctor(ctx context.Context, logger *slog.Logger, templateReposFile string) *DoctorReport {
+	report := &DoctorReport{}
+
+	pool, usingApps := ctx.Value(config.AppPoolKey).(*auth.AppPool)
+	if usingApps && pool != nil {
+		checkApps(ctx, logger, report, pool)
+	} else {
+		checkToken(ctx, logger, report)
+	}
+
+	enterpriseSlug := ctx.Value(config.EnterpriseSlugKey).(string)
+	enterprise, err := api.GetEnterprise(ctx, logger, enterpriseSlug)
+	if err != nil {
+		report.add("Enterprise access", CheckFail, err.Error(),
+			fmt.Sprintf("Check --enterprise-slug %q and that the credentials are an owner of, or installed on, the enterprise", enterpriseSlug))
+	} else {
+		report.add("Enterprise access", CheckPass, fmt.Sprintf("enterprise %s is accessible", enterprise.Slug), "")
+	}
+
+	switch {
+	case !usingApps || pool == nil:
+		report.add("App installation on organizations", CheckWarn, "not available with a Personal Access Token",
+			"lab create installs a GitHub App on every lab organization; use --app-id and --private-key-file for lab create")
+	case enterprise == nil:
+		report.add("App installation on organizations", CheckFail, "skipped because the enterprise is not accessible", "")
+	default:
+		if _, err := enterprise.ListInstallableOrganizations(ctx, logger); err != nil {
+			report.add("App installation on organizations", CheckFail, err.Error(),
+				"Grant the app the enterprise permission 'Enterprise organization installations: Read and write' and accept it on the enterprise installation")
+		} else {
+			report.add("App installation on organizations", CheckPass, "the app can install apps on enterprise organizations", "")
+		}
+	}
+
+	if templateReposFile != "" {
+		checkTemplateRepos(ctx, logger, report, templateReposFile)
+	}
+
+	checkRateLimit(ctx, logger, report)
+
+	return report
+}
+
+// checkToken verifies the Personal Access Token and, for classic tokens, its scopes
+func checkToken(ctx context.Context, logger *slog.Logger, report *DoctorReport) {
+	info, err := api.GetTokenInfo(ctx, logger)
+	if err != nil {
+		report.add("Credentials", CheckFail, err.Error(), "The token is invalid or expired; create a new Personal Access Token")
+		return
+	}
+	report.add("Credentials", CheckPass, fmt.Sprintf("authenticated as %s", info.Login), "")
+
+	if !info.Classic {
+		report.add("Token scopes", CheckWarn, "fine-grained tokens do not report their permissions",
+			"Make sure the token can administer the enterprise, its organizations and their repositories")
+		return
+	}
+
+	missing := missingScopes(info.Scopes, requiredTokenScopes)
+	if len(missing) > 0 {
+		report.add("Token scopes", CheckFail, fmt.Sprintf("missing %s (token has: %s)", strings.Join(missing, ", "), strings.Join(info.Scopes, ", ")),
+			fmt.Sprintf("Regenerate the token with the %s scopes", strings.Join(requiredTokenScopes, ", ")))
+	} else {
+		report.add("Token scopes", CheckPass, strings.Join(info.Scopes, ", "), "")
+	}
+
+	if missing := missingScopes(info.Scopes, deleteTokenScopes); len(missing) > 0 {
+		report.add("Token scopes for deletion", CheckWarn, fmt.Sprintf("missing %s", strings.Join(missing, ", ")),
+			"Add the delete_repo scope to use repo delete")
+	}
+	if missing := missingScopes(info.Scopes, workflowTokenScopes); len(missing) > 0 {
+		report.add("Token scopes for workflows", CheckWarn, fmt.Sprintf("missing %s", strings.Join(missing, ", ")),
+			"Add the workflow scope to sync, pin or import repositories with GitHub Actions workflows")
+	}
+}
+
+func missingScopes(have []string, want []string) []string {
+	var missing []string
+	for _, scope := range want {
+		if !slices.Contains(have, scope) {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+// checkApps verifies each app's credentials, permissions and enterprise installation
+func checkApps(ctx context.Context, logger *slog.Logger, report *DoctorReport, pool *auth.AppPool) {
+	for _, manager := range pool.Managers() {
+		service := manager.Service()
+		name := fmt.Sprintf("App %s", service.AppID())
+
+		jwt, err := service.CreateJWT()
+		if err != nil {
+			report.add(name, CheckFail, err.Error(), "Check the private key")
+			continue
+		}
+		app, err := service.GetApp(ctx, jwt)
+		if err != nil {
+			report.add(name, CheckFail, err.Error(),
+				"Check that --app-id is the App ID from the app settings page and that the private key belongs to that app")
+			continue
+		}
+		report.add(name, CheckPass, fmt.Sprintf("authenticated as %s", app.Slug), "")
+
+		var missing []string
+		for permission, level := range requiredAppPermissions {
+			if !permissionSatisfies(app.Permissions[permission], level) {
+				missing = append(missing, fmt.Sprintf("%s: %s", permission, level))
+			}
+		}
+		slices.Sort(missing)
+		if len(missing) > 0 {
+			report.add(name+" permissions", CheckFail, fmt.Sprintf("missing %s", strings.Join(missing, ", ")),
+				"Update the app's repository and organization permissions and accept the new permissions on the enterprise installation")
+		} else {
+			report.add(name+" permissions", CheckPass, "repository and organization permissions", "")
+		}
+		if !permissionSatisfies(app.Permissions["workflows"], "write") {
+			report.add(name+" workflows permission", CheckWarn, "missing workflows: write",
+				"Add the workflows permission to sync, pin or import repositories with GitHub Actions workflows")
+		}
+
+		installation, err := manager.EnterpriseInstallation(ctx, false)
+		if err != nil {
+			report.add(name+" enterprise installation", CheckFail, err.Error(),
+				"Install the app on the enterprise, or pin the installation with --installation-id")
+			continue
+		}
+		report.add(name+" enterprise installation", CheckPass,
+			fmt.Sprintf("installation %d on %s", installation.ID, installation.Account.Login), "")
+	}
+}
+
+// permissionSatisfies reports whether a granted permission level covers the wanted one
+func permissionSatisfies(granted string, want string) bool {
+	rank := map[string]int{"read": 1, "write": 2, "admin": 3}
+	return rank[granted] >= rank[want]
+}
+
+// checkTemplateRepos verifies that every template repository exists and is marked as a template, and
+// that the files other repositories are imported from can be read
+func checkTemplateRepos(ctx context.Context, logger *slog.Logger, report *DoctorReport, templateReposFile string) {
+	repos, err := util.LoadFromJsonFile(templateReposFile)
+	if err != nil {
+		report.add("Template repositories", CheckFail, fmt.Sprintf("failed to load %s: %v", templateReposFile, err),
+			"Check the path and JSON format of the template repositories file")
+		return
+	}
+
+	for _, repo := range repos {
+		if repo.IsImported() {
+			checkRepoSource(report, repo)
+			continue
+		}
+
+		name := "Template " + repo.Template
+		owner, _, _ := strings.Cut(repo.Template, "/")
+
+		result, err := api.GetRepository(ctx, logger, repo.Template)
+		switch {
+		case errors.Is(err, auth.ErrInstallationNotFound):
+			report.add(name, CheckWarn, fmt.Sprintf("cannot be checked because the app is not installed on %s", owner),
+				"Templates outside the lab organizations must be public or internal to be generated")
+		case errors.Is(err, api.ErrNotFound):
+			report.add(name, CheckFail, "repository not found",
+				"Check the owner/repo name and that the credentials can read it")
+		case err != nil:
+			report.add(name, CheckFail, err.Error(), "")
+		case !result.IsTemplate:
+			report.add(name, CheckFail, "repository is not marked as a template",
+				"Enable 'Template repository' in the repository settings")
+		default:
+			report.add(name, CheckPass, "exists and is a template", "")
+		}
+	}
+}
+
+// checkRepoSource verifies that the bundle or tarball of an imported repository can be read
+func checkRepoSource(report *DoctorReport, repo util.RepoConfig) {
+	name := fmt.Sprintf("Source %s", repo.Path)
+	snapshot, err := util.LoadRepoSnapshot(repo)
+	if err != nil {
+		report.add(name, CheckFail, err.Error(), "Check the path and that the file is a full git bundle or a tar archive")
+		return
+	}
+	detail := fmt.Sprintf("%s with %d files, uploaded to each copy with %d requests", repo.Source, len(snapshot.Files), snapshot.BlobCount()+gitDataRequestsPerRepo)
+	if snapshot.Commit != "" {
+		detail += fmt.Sprintf(" at commit %s", snapshot.Commit)
+	}
+	report.add(name, CheckPass, detail, "")
+}
+
+// checkRateLimit reports the remaining REST and GraphQL quota of the enterprise credentials
+func checkRateLimit(ctx context.Context, logger *slog.Logger, report *DoctorReport) {
+	limits, err := api.GetRateLimit(ctx, logger, config.EnterpriseType)
+	if err != nil {
+		report.add("Rate limit", CheckFail, err.Error(), "")
+		return
+	}
+
+	detail := fmt.Sprintf("REST %d/%d, GraphQL %d/%d remaining; resets at %s",
+		limits.Core.Remaining, limits.Core.Limit,
+		limits.GraphQL.Remaining, limits.GraphQL.Limit,
+		limits.Core.ResetAt().Format(time.Kitchen))
+	resetIn := time.Until(limits.Core.ResetAt()).Round(time.Minute)
+
+	switch {
+	case limits.Core.Remaining == 0 || limits.GraphQL.Remaining == 0:
+		report.add("Rate limit", CheckFail, detail, fmt.Sprintf("Wait %s for the limit to reset, or add apps with --apps-file", resetIn))
+	case limits.Core.Remaining < lowRateLimitThreshold || limits.GraphQL.Remaining < lowRateLimitThreshold:
+		report.add("Rate limit", CheckWarn, detail, "A large lab may run out of quota; wait for the reset or add apps with --apps-file")
+	default:
+		report.add("Rate limit", CheckPass, detail, "")
+	}
+}
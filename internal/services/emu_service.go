@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+	api "github.com/s-samadi/ghas-lab-builder/internal/github"
+	"github.com/s-samadi/ghas-lab-builder/internal/progress"
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
+)
+
+// Phase labels for Enterprise Managed Users provisioning in the terminal progress view
+const (
+	phaseProvisionUsers   = "Provision users"
+	phaseDeprovisionUsers = "Deprovision users"
+)
+
+// managedUsersShortcode returns the enterprise shortcode when the lab runs in EMU mode
+func managedUsersShortcode(ctx context.Context) string {
+	shortcode, _ := ctx.Value(config.EMUShortcodeKey).(string)
+	return shortcode
+}
+
+// managedUserLogin returns the GitHub login of a managed user, honoring a login given in the users file
+func managedUserLogin(user util.LabUser, shortcode string) string {
+	if user.Login != "" {
+		return user.Login
+	}
+	return api.ManagedUserLogin(user.UserName, shortcode)
+}
+
+// provisionManagedUsers provisions every user through the enterprise SCIM API and returns the
+// users that were provisioned, with their GitHub login set, and the usernames of those that failed
+func provisionManagedUsers(ctx context.Context, logger *slog.Logger, users []util.LabUser, shortcode string) ([]util.LabUser, []string) {
+	prog := progress.FromContext(ctx)
+	prog.AddPhase(phaseProvisionUsers, len(users))
+
+	provisioned := make([]util.LabUser, 0, len(users))
+	var failed []string
+	for _, user := range users {
+		if ctx.Err() != nil {
+			failed = append(failed, user.UserName)
+			continue
+		}
+
+		_, err := api.ProvisionManagedUser(ctx, logger, user)
+		prog.Advance(phaseProvisionUsers, 1)
+		if err != nil {
+			logger.Error("Failed to provision managed user",
+				slog.String("user_name", user.UserName),
+				slog.Any("error", err))
+			failed = append(failed, user.UserName)
+			continue
+		}
+		user.Login = managedUserLogin(user, shortcode)
+		provisioned = append(provisioned, user)
+	}
+
+	logger.Info("Provisioned managed users",
+		slog.Int("provisioned", len(provisioned)),
+		slog.Int("failed", len(failed)))
+
+	return provisioned, failed
+}
+
+// deprovisionManagedUsers removes every user through the enterprise SCIM API and returns the
+// deprovisioning error of each login, nil for users that were deprovisioned
+func deprovisionManagedUsers(ctx context.Context, logger *slog.Logger, users []util.LabUser, shortcode string) map[string]error {
+	prog := progress.FromContext(ctx)
+	prog.AddPhase(phaseDeprovisionUsers, len(users))
+
+	results := make(map[string]error, len(users))
+	for _, user := range users {
+		login := managedUserLogin(user, shortcode)
+		if err := ctx.Err(); err != nil {
+			results[login] = err
+			continue
+		}
+
+		err := api.DeprovisionManagedUser(ctx, logger, user.UserName)
+		prog.Advance(phaseDeprovisionUsers, 1)
+		if err != nil {
+			logger.Error("Failed to deprovision managed user",
+				slog.String("user_name", user.UserName),
+				slog.Any("error", err))
+			results[login] = fmt.Errorf("failed to deprovision managed user: %w", err)
+			continue
+		}
+		results[login] = nil
+	}
+
+	return results
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+	api "github.com/s-samadi/ghas-lab-builder/internal/github"
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
+)
+
+// loadRepoSources reads the bundles and tarballs repositories are imported from once, before any
+// organization is provisioned, so a broken file fails the lab early
+func loadRepoSources(logger *slog.Logger, repos []util.RepoConfig) error {
+	for i := range repos {
+		if !repos[i].IsImported() {
+			continue
+		}
+		snapshot, err := util.LoadRepoSnapshot(repos[i])
+		if err != nil {
+			logger.Error("Failed to read repository source",
+				slog.String("path", repos[i].Path),
+				slog.Any("error", err))
+			return fmt.Errorf("failed to read %s %s: %w", repos[i].Source, repos[i].Path, err)
+		}
+		repos[i].Snapshot = snapshot
+		logger.Info("Loaded repository source",
+			slog.String("path", repos[i].Path),
+			slog.String("source", string(repos[i].Source)),
+			slog.String("commit", snapshot.Commit),
+			slog.Int("files", len(snapshot.Files)))
+	}
+	return nil
+}
+
+// Requests each imported or pinned repository makes at most besides its blob uploads: pinning reads the
+// branch, its commit and its tree, and both create a tree, a commit and the branch update
+const gitDataRequestsPerRepo = 6
+
+// estimateGitDataRequests returns the REST requests needed to upload the content of imported and pinned
+// repositories to every copy. Blobs are uploaded to each repository separately, since repositories do
+// not share objects, so the cost grows with the number of files times the number of copies.
+func estimateGitDataRequests(repos []util.RepoConfig, copies int) int {
+	perCopy := 0
+	for _, repo := range repos {
+		if repo.Snapshot != nil {
+			perCopy += repo.Snapshot.BlobCount() + gitDataRequestsPerRepo
+		}
+	}
+	return perCopy * copies
+}
+
+// checkGitDataQuota warns when uploading the content of imported and pinned repositories to every copy
+// may exhaust the REST quota midway through the lab. With apps every organization has an installation
+// and quota of its own, so only the requests of one organization are compared with the limit.
+func checkGitDataQuota(ctx context.Context, logger *slog.Logger, repos []util.RepoConfig, units int, orgCount int) {
+	requests := estimateGitDataRequests(repos, units)
+	if requests == 0 {
+		return
+	}
+	limits, err := api.GetRateLimit(ctx, logger, config.EnterpriseType)
+	if err != nil {
+		logger.Warn("Failed to check the rate limit for repository uploads", slog.Any("error", err))
+		return
+	}
+
+	available := limits.Core.Remaining
+	if api.UsesAppAuth(ctx) {
+		requests = (requests + orgCount - 1) / orgCount
+		available = limits.Core.Limit
+	}
+	if requests > available {
+		logger.Warn("Uploading imported and pinned repositories may exhaust the REST rate limit",
+			slog.Int("estimated_requests", requests),
+			slog.Int("available", available),
+			slog.Time("resets_at", limits.Core.ResetAt()))
+		return
+	}
+	logger.Info("Estimated requests for repository uploads",
+		slog.Int("estimated_requests", requests),
+		slog.Int("available", available))
+}
+
+// createRepo generates a repository from its template, or creates the repository its bundle or tarball
+// is imported into
+func createRepo(ctx context.Context, logger *slog.Logger, organization *api.Organization, repoName string, repoConfig util.RepoConfig) (*api.Repository, error) {
+	opts := generateOptions(repoConfig, repoName)
+	if !repoConfig.IsImported() {
+		return organization.CreateRepoFromTemplateWith(ctx, logger, repoConfig.Template, opts)
+	}
+	if opts.Description == "" {
+		opts.Description = fmt.Sprintf("Repository imported from %s", filepath.Base(repoConfig.Path))
+	}
+	visibility := "private"
+	if opts.Public {
+		visibility = "public"
+	}
+	return organization.CreateRepository(ctx, logger, api.RepoOptions{
+		Name:        opts.Name,
+		Description: opts.Description,
+		Visibility:  visibility,
+		AutoInit:    true,
+	})
+}
+
+// importRepo replaces the initial commit of a created repository with the content of its bundle or tarball,
+// pushed through the Git Data API as blobs, a tree and a commit the default branch is forced to. The tree
+// of a bundle's commit is rebuilt exactly, so its SHA, which is returned, proves the content.
+func importRepo(ctx context.Context, logger *slog.Logger, organization *api.Organization, repo *api.Repository, repoName string, repoConfig util.RepoConfig) (string, error) {
+	snapshot := repoConfig.Snapshot
+
+	created := map[string]bool{}
+	entries := make([]api.TreeEntry, 0, len(snapshot.Files))
+	for _, file := range snapshot.Files {
+		// Submodules are recorded by commit; their content lives in another repository
+		if file.Mode == "160000" {
+			entries = append(entries, api.TreeEntry{Path: file.Path, Mode: file.Mode, Type: "commit", SHA: file.SHA})
+			continue
+		}
+		sha := util.BlobSHA(file.Content)
+		if !created[sha] {
+			blobSHA, err := organization.CreateBlob(ctx, logger, repoName, base64.StdEncoding.EncodeToString(file.Content))
+			if err != nil {
+				return "", err
+			}
+			if blobSHA != sha {
+				return "", fmt.Errorf("upload of %s has SHA %s instead of %s", file.Path, blobSHA, sha)
+			}
+			created[sha] = true
+		}
+		entries = append(entries, api.TreeEntry{Path: file.Path, Mode: file.Mode, Type: "blob", SHA: sha})
+	}
+
+	treeSHA, err := organization.CreateTree(ctx, logger, repoName, entries)
+	if err != nil {
+		return "", err
+	}
+	if snapshot.Tree != "" && treeSHA != snapshot.Tree {
+		return "", fmt.Errorf("rebuilt tree %s does not match tree %s of %s", treeSHA, snapshot.Tree, repoConfig.Path)
+	}
+
+	message := fmt.Sprintf("Import %s", filepath.Base(repoConfig.Path))
+	if snapshot.Commit != "" {
+		message = fmt.Sprintf("%s (%s)", message, snapshot.Commit)
+	}
+	commitSHA, err := organization.CreateCommit(ctx, logger, repoName, message, treeSHA, []string{})
+	if err != nil {
+		return "", err
+	}
+	if err := organization.UpdateBranch(ctx, logger, repoName, repo.DefaultBranch, commitSHA, true); err != nil {
+		return "", err
+	}
+
+	logger.Info("Imported repository",
+		slog.String("repo", repoName),
+		slog.String("path", repoConfig.Path),
+		slog.String("tree", treeSHA),
+		slog.Int("files", len(entries)),
+		slog.Int("blobs", len(created)))
+
+	return treeSHA, nil
+}
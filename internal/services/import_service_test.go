@@ -0,0 +1,28 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
+)
+
+func TestEstimateGitDataRequests(t *testing.T) {
+	snapshot := &util.RepoSnapshot{Files: []util.SourceFile{
+		{Path: "a.txt", Mode: "100644", Content: []byte("a")},
+		{Path: "b.txt", Mode: "100644", Content: []byte("b")},
+		{Path: "copy.txt", Mode: "100644", Content: []byte("a")},
+	}}
+	repos := []util.RepoConfig{
+		{Source: util.SourceBundle, Path: "demo.bundle", Snapshot: snapshot},
+		{Source: util.SourceTemplate, Template: "octo/pinned", Ref: "v1", Snapshot: snapshot},
+		{Source: util.SourceTemplate, Template: "octo/latest"},
+	}
+
+	want := 2 * (2 + gitDataRequestsPerRepo) * 40
+	if got := estimateGitDataRequests(repos, 40); got != want {
+		t.Errorf("estimateGitDataRequests = %d, want %d", got, want)
+	}
+	if got := estimateGitDataRequests(repos[2:], 40); got != 0 {
+		t.Errorf("generated repositories need %d upload requests, want 0", got)
+	}
+}
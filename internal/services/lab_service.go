@@ -2,32 +2,79 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/s-samadi/ghas-lab-builder/internal/config"
 	api "github.com/s-samadi/ghas-lab-builder/internal/github"
+	"github.com/s-samadi/ghas-lab-builder/internal/progress"
+	"github.com/s-samadi/ghas-lab-builder/internal/telemetry"
 	"github.com/s-samadi/ghas-lab-builder/internal/util"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Phase labels shown in the terminal progress view
+const (
+	phaseCreateOrgs    = "Create orgs"
+	phaseInstallApp    = "Install app"
+	phaseGenerateRepos = "Generate repos"
+	phaseDeleteOrgs    = "Delete orgs"
 )
 
 // ProvisionResult represents the result of provisioning an organization
 type ProvisionResult struct {
 	User        string
+	Profile     util.LabUser
+	Team        string
+	Members     []string
 	OrgName     string
 	Status      string
 	Error       string
 	Repos       []RepoReport
+	Roles       []RoleAssignment
+	Steps       []StepTiming
+	CreatedAt   time.Time
+	StartedAt   time.Time
 	CompletedAt time.Time
 }
 
-func ProvisionOrgResources(workerId int, ctx context.Context, logger *slog.Logger, orgChan chan string, resultsChan chan ProvisionResult, enterprise *api.Enterprise, templateRepos []util.RepoConfig) {
+// owner returns the user or team the provisioned organization belongs to
+func (r *ProvisionResult) owner() string {
+	if r.Team != "" {
+		return r.Team
+	}
+	return r.User
+}
+
+// complete stamps the overall provisioning time for the organization
+func (r *ProvisionResult) complete() {
+	r.CompletedAt = time.Now()
+	total := StepTiming{
+		Step:      StepProvisionOrg,
+		Target:    r.owner(),
+		StartedAt: r.StartedAt,
+		EndedAt:   r.CompletedAt,
+		Duration:  r.CompletedAt.Sub(r.StartedAt),
+		Failed:    r.Status != "success",
+	}
+	r.Steps = append(r.Steps, total)
+}
+
+func ProvisionOrgResources(workerId int, ctx context.Context, logger *slog.Logger, orgChan chan labOrgPlan, resultsChan chan ProvisionResult, enterprise *api.Enterprise, definition *util.LabDefinition, shared *api.Organization) {
 
 	logger.Info("Worker started", slog.Int("workerId", workerId))
 
+	ctx, span := telemetry.StartSpan(ctx, "ProvisionWorker", telemetry.AttrWorkerID.Int(workerId))
+	defer span.End()
+
 	// Create a new organization for the user
-	for user := range orgChan {
+	for plan := range orgChan {
 		// Check if context is cancelled
 		select {
 		case <-ctx.Done():
@@ -36,129 +83,270 @@ func ProvisionOrgResources(workerId int, ctx context.Context, logger *slog.Logge
 		default:
 		}
 
-		// Initialize result tracking
-		result := ProvisionResult{
-			User:        user,
-			Status:      "failed",
-			Repos:       []RepoReport{},
-			CompletedAt: time.Now(),
+		resultsChan <- provisionLabOrg(ctx, logger, plan, enterprise, definition, shared)
+	}
+
+	logger.Info("Worker stopped", slog.Int("workerId", workerId))
+}
+
+// provisionLabOrg creates the organization of a user or team, installs the app on it, adds the team
+// and generates every template repository, recording timings along the way. In a single-org lab the
+// shared organization already exists and the user is added to it and gets their own repositories.
+func provisionLabOrg(ctx context.Context, logger *slog.Logger, plan labOrgPlan, enterprise *api.Enterprise, definition *util.LabDefinition, shared *api.Organization) (result ProvisionResult) {
+	user := plan.Key
+	templateRepos := definition.Repos
+	ctx, span := telemetry.StartSpan(ctx, "ProvisionOrg", telemetry.AttrUser.String(plan.label()))
+	defer func() {
+		span.SetAttributes(telemetry.AttrOrg.String(result.OrgName))
+		var err error
+		if result.Status != "success" {
+			err = errors.New(result.Error)
 		}
+		telemetry.End(span, err)
+	}()
+
+	prog := progress.FromContext(ctx)
+	owners := labOwners(ctx, definition)
+
+	// Initialize result tracking
+	result = ProvisionResult{
+		User:      plan.User.Login,
+		Profile:   plan.User,
+		Team:      plan.Team,
+		Members:   logins(plan.Members),
+		Status:    "failed",
+		Repos:     []RepoReport{},
+		StartedAt: time.Now(),
+	}
+
+	// fail records an error that stops the provisioning of the unit
+	fail := func(message string, err error, reposLeft int) ProvisionResult {
+		result.Error = fmt.Sprintf("%s: %v", message, err)
+		result.complete()
+		prog.Advance(phaseGenerateRepos, reposLeft)
+		prog.SetStatus(user, progress.StateFailed, result.Error)
+		return result
+	}
 
+	organization := shared
+	if organization == nil {
 		// Call the GraphQL-based CreateOrg function
-		organization, err := enterprise.CreateOrg(ctx, logger, user)
+		prog.SetStatus(user, progress.StateRunning, "creating organization")
+		stepCtx, step := startStep(ctx, StepCreateOrg, plan.Key)
+		var err error
+		if plan.Team != "" {
+			organization, err = enterprise.CreateLabOrg(stepCtx, logger, plan.OrgName, nil)
+		} else {
+			organization, err = enterprise.CreateOrg(stepCtx, logger, plan.User.Login)
+		}
+		result.Steps = append(result.Steps, step.finish(err))
 		if err != nil {
 			logger.Error("Failed to create organization",
-				slog.String("user", user),
+				slog.String("user", plan.label()),
 				slog.Any("error", err))
-			result.Error = fmt.Sprintf("Failed to create organization: %v", err)
-			resultsChan <- result
-			continue
+			prog.Advance(phaseCreateOrgs, 1)
+			prog.Advance(phaseInstallApp, 1)
+			return fail("Failed to create organization", err, len(templateRepos))
 		}
-		orgName := organization.Login
-		result.OrgName = orgName
-
-		//Install app on organization
-		_, err = enterprise.InstallAppOnOrg(ctx, logger, orgName)
+		result.CreatedAt = time.Now()
+		prog.Advance(phaseCreateOrgs, 1)
+
+		// Install app on organization; with a PAT every request uses the token, so there is nothing to install
+		if api.UsesAppAuth(ctx) {
+			prog.SetStatus(user, progress.StateRunning, "installing app on "+organization.Login)
+			stepCtx, step = startStep(ctx, StepInstallApp, organization.Login)
+			_, err = enterprise.InstallAppOnOrg(stepCtx, logger, organization.Login)
+			result.Steps = append(result.Steps, step.finish(err))
+			if err != nil {
+				logger.Error("Failed to install app on organization",
+					slog.String("org", organization.Login),
+					slog.Any("error", err))
+				prog.Advance(phaseInstallApp, 1)
+				result.OrgName = organization.Login
+				return fail("Failed to install app", err, len(templateRepos))
+			}
+		} else {
+			logger.Info("Skipping app installation with Personal Access Token authentication", slog.String("org", organization.Login))
+		}
+		prog.Advance(phaseInstallApp, 1)
+	}
+	orgName := organization.Login
+	result.OrgName = orgName
+
+	// Add organization name to context for token scoping
+	ctx = context.WithValue(ctx, config.OrgKey, orgName)
+
+	// Give the team, or the user of a shared organization, access to the organization
+	var team *api.Team
+	switch {
+	case plan.Team != "":
+		prog.SetStatus(user, progress.StateRunning, "adding team members")
+		stepCtx, step := startStep(ctx, StepAddMembers, orgName)
+		var err error
+		team, err = addTeam(stepCtx, logger, organization, plan, owners)
+		result.Steps = append(result.Steps, step.finish(err))
 		if err != nil {
-			logger.Error("Failed to install app on organization",
-				slog.String("org", orgName),
-				slog.Any("error", err))
-			result.Error = fmt.Sprintf("Failed to install app: %v", err)
-			resultsChan <- result
-			continue
+			logger.Error("Failed to add team", slog.String("org", orgName), slog.String("team", plan.Team), slog.Any("error", err))
+			return fail("Failed to add team", err, len(templateRepos))
 		}
+	case shared != nil && !slices.Contains(owners, plan.User.Login):
+		prog.SetStatus(user, progress.StateRunning, "adding member")
+		stepCtx, step := startStep(ctx, StepAddMembers, plan.User.Login)
+		err := organization.AddMember(stepCtx, logger, plan.User.Login, "member")
+		result.Steps = append(result.Steps, step.finish(err))
+		if err != nil {
+			return fail("Failed to add member", err, len(templateRepos))
+		}
+	}
 
-		logger.Info("Creating repositories in organization", slog.String("org", orgName))
+	logger.Info("Creating repositories in organization", slog.String("org", orgName))
 
-		// Add organization name to context for token scoping
-		ctx = context.WithValue(ctx, config.OrgKey, orgName)
+	// Track each repository creation
+	failedRepos := 0
+	for i, repoConfig := range templateRepos {
+		prog.SetStatus(user, progress.StateRunning,
+			fmt.Sprintf("generating repos %d/%d (%s)", i+1, len(templateRepos), repoConfig.SourceName()))
 
-		// Track each repository creation
-		for _, repoConfig := range templateRepos {
-			logger.Info("Creating repository",
-				slog.String("repo", repoConfig.Template),
-				slog.Bool("include_all_branches", repoConfig.IncludeAllBranches))
+		logger.Info("Creating repository",
+			slog.String("repo", repoConfig.SourceName()),
+			slog.Bool("include_all_branches", repoConfig.IncludeAllBranches))
 
-			repoResult := RepoReport{
-				Name:   repoConfig.Template,
-				Status: "failed",
-			}
+		repoName := repoNameFor(plan, shared != nil, repoConfig)
+		repoResult := RepoReport{
+			Name:   repoConfig.Template,
+			Status: "failed",
+		}
+		if shared != nil || repoConfig.Name != "" || repoConfig.IsImported() {
+			repoResult.Name = repoName
+		}
 
-			createdRepo, err := organization.CreateRepoFromTemplate(ctx, logger, repoConfig.Template, repoConfig.IncludeAllBranches)
+		stepCtx, step := startStep(ctx, StepGenerateRepo, repoConfig.SourceName(), telemetry.AttrRepo.String(repoName))
+		createdRepo, err := createRepo(stepCtx, logger, organization, repoName, repoConfig)
+		if err == nil {
+			// The team, or the user of a shared organization, gets the lab's permission on the repository
+			switch {
+			case team != nil:
+				err = organization.SetTeamRepoPermission(stepCtx, logger, team.Slug, repoName, definition.RepoPermission)
+			case shared != nil && !slices.Contains(owners, plan.User.Login):
+				err = organization.AddRepoCollaborator(stepCtx, logger, repoName, plan.User.Login, definition.RepoPermission)
+			}
+		}
+		step = step.finish(err)
+		result.Steps = append(result.Steps, step)
+		repoResult.StartedAt = step.StartedAt
+		repoResult.CompletedAt = step.EndedAt
+		repoResult.Duration = step.Duration
+
+		// Wait for the template's contents or import the repository's own, apply the repository's settings,
+		// then seed the exercise material
+		if err == nil {
+			prog.SetStatus(user, progress.StateRunning, fmt.Sprintf("waiting for %s", repoName))
+			stepCtx, step := startStep(ctx, StepWaitRepo, repoName, telemetry.AttrRepo.String(repoName))
+			repoResult.ReadyAfter, err = waitForRepo(stepCtx, logger, organization, createdRepo, repoName, repoConfig)
+			result.Steps = append(result.Steps, step.finish(err))
+		}
+		if err == nil && repoConfig.IsImported() {
+			prog.SetStatus(user, progress.StateRunning, fmt.Sprintf("importing %s", repoName))
+			stepCtx, step := startStep(ctx, StepImportRepo, repoName, telemetry.AttrRepo.String(repoName))
+			repoResult.TreeSHA, err = importRepo(stepCtx, logger, organization, createdRepo, repoName, repoConfig)
+			result.Steps = append(result.Steps, step.finish(err))
 			if err != nil {
-				logger.Error("Failed to create repository",
-					slog.String("repo", repoConfig.Template),
-					slog.Any("error", err))
-				repoResult.Error = fmt.Sprintf("%v", err)
+				err = fmt.Errorf("failed to import %s: %w", repoConfig.Path, err)
 			} else {
-				repoResult.Status = "success"
-				repoResult.URL = createdRepo.HTMLURL
+				repoResult.ImportedFrom = filepath.Base(repoConfig.Path)
 			}
-			result.Repos = append(result.Repos, repoResult)
 		}
-
-		// Mark as success and send result
-		result.Status = "success"
-		resultsChan <- result
-		logger.Info("Finished creating organization", slog.String("org", orgName))
+		if err == nil && repoConfig.PinnedSHA != "" {
+			prog.SetStatus(user, progress.StateRunning, fmt.Sprintf("pinning %s to %s", repoName, repoConfig.Ref))
+			stepCtx, step := startStep(ctx, StepPinRepo, repoName, telemetry.AttrRepo.String(repoName))
+			repoResult.TreeSHA, err = pinRepo(stepCtx, logger, organization, createdRepo, repoName, repoConfig)
+			result.Steps = append(result.Steps, step.finish(err))
+			if err != nil {
+				err = fmt.Errorf("failed to pin repository to %s: %w", repoConfig.Ref, err)
+			} else {
+				repoResult.TemplateSHA = repoConfig.PinnedSHA
+			}
+		}
+		if err == nil && repoConfig.HasSettings() {
+			prog.SetStatus(user, progress.StateRunning, fmt.Sprintf("configuring %s", repoName))
+			stepCtx, step := startStep(ctx, StepConfigureRepo, repoName, telemetry.AttrRepo.String(repoName))
+			err = configureRepo(stepCtx, logger, organization, createdRepo, repoName, repoConfig)
+			result.Steps = append(result.Steps, step.finish(err))
+			if err != nil {
+				err = fmt.Errorf("failed to configure repository: %w", err)
+			}
+		}
+		if err == nil && repoConfig.Seed != nil {
+			prog.SetStatus(user, progress.StateRunning, fmt.Sprintf("seeding %s", repoName))
+			stepCtx, step := startStep(ctx, StepSeedRepo, repoName, telemetry.AttrRepo.String(repoName))
+			repoResult.Seed, err = seedRepository(stepCtx, logger, organization, createdRepo, repoName, repoConfig.Seed)
+			result.Steps = append(result.Steps, step.finish(err))
+			if err != nil {
+				err = fmt.Errorf("failed to seed repository: %w", err)
+			}
+		}
+		if err != nil {
+			logger.Error("Failed to create repository",
+				slog.String("repo", repoConfig.SourceName()),
+				slog.Any("error", err))
+			repoResult.Error = fmt.Sprintf("%v", err)
+			failedRepos++
+		} else {
+			repoResult.Status = "success"
+			repoResult.URL = createdRepo.HTMLURL
+		}
+		result.Repos = append(result.Repos, repoResult)
+		prog.Advance(phaseGenerateRepos, 1)
 	}
 
-	logger.Info("Worker stopped", slog.Int("workerId", workerId))
-}
-
-func CreateLabEnvironment(ctx context.Context, logger *slog.Logger, usersFile string, templateReposFile string) error {
-
-	//Get users
-	logger.Info("Loading users from file", slog.String("file", usersFile))
-	users, err := util.LoadFromFile(usersFile)
-	if err != nil {
-		return err
+	// Give the facilitators and observers their role last, as facilitators leaving the owners may lose
+	// access the provisioning needed; the shared organization is done once every user is in it
+	if shared == nil {
+		prog.SetStatus(user, progress.StateRunning, "assigning facilitator and observer roles")
+		stepCtx, step := startStep(ctx, StepAssignRoles, orgName)
+		result.Roles = assignStaffRoles(stepCtx, logger, organization, definition, plan.User.Login)
+		result.Steps = append(result.Steps, step.finish(assignmentError(result.Roles)))
 	}
 
-	logger.Info("Loaded users", slog.Int("count", len(users)))
+	// Mark as success
+	result.Status = "success"
+	result.complete()
+	prog.SetStatus(user, progress.StateDone,
+		fmt.Sprintf("%d/%d repos created", len(templateRepos)-failedRepos, len(templateRepos)))
+	logger.Info("Finished creating organization", slog.String("org", orgName))
+
+	return result
+}
 
-	// Get facilitators from context (optional)
-	facilitators, _ := ctx.Value(config.FacilitatorsKey).([]string)
+// createSharedOrg creates the one organization of a single-org lab and installs the app on it
+func createSharedOrg(ctx context.Context, logger *slog.Logger, enterprise *api.Enterprise, orgName string) (*api.Organization, error) {
+	prog := progress.FromContext(ctx)
+	defer prog.Advance(phaseCreateOrgs, 1)
+	defer prog.Advance(phaseInstallApp, 1)
 
-	// Validate and filter users
-	logger.Info("Validating users", slog.Int("count", len(users)))
-	userValidation, err := api.ValidateAndFilterUsers(ctx, logger, users)
+	organization, err := enterprise.CreateLabOrg(ctx, logger, orgName, nil)
 	if err != nil {
-		logger.Error("User validation failed", slog.Any("error", err))
-		return fmt.Errorf("user validation failed: %w", err)
+		logger.Error("Failed to create organization", slog.String("org", orgName), slog.Any("error", err))
+		return nil, fmt.Errorf("failed to create organization %s: %w", orgName, err)
 	}
-
-	invalidUsers := userValidation.InvalidUsers
-	users = userValidation.ValidUsers
-
-	// Validate and filter facilitators
-	invalidFacilitators := []string{}
-	if len(facilitators) > 0 {
-		logger.Info("Validating facilitators", slog.Int("count", len(facilitators)))
-		facilitatorValidation, err := api.ValidateAndFilterUsers(ctx, logger, facilitators)
-		if err != nil {
-			logger.Error("Facilitator validation failed", slog.Any("error", err))
-			return fmt.Errorf("facilitator validation failed: %w", err)
+	if api.UsesAppAuth(ctx) {
+		if _, err := enterprise.InstallAppOnOrg(ctx, logger, organization.Login); err != nil {
+			logger.Error("Failed to install app on organization", slog.String("org", orgName), slog.Any("error", err))
+			return nil, fmt.Errorf("failed to install app on %s: %w", orgName, err)
 		}
-		invalidFacilitators = facilitatorValidation.InvalidUsers
-		facilitators = facilitatorValidation.ValidUsers
-		// Update context with filtered facilitators
-		ctx = context.WithValue(ctx, config.FacilitatorsKey, facilitators)
 	}
+	return organization, nil
+}
 
-	// Combine users and facilitators for provisioning
-	allUsersToProvision := make([]string, 0, len(users)+len(facilitators))
-	allUsersToProvision = append(allUsersToProvision, users...)
-	allUsersToProvision = append(allUsersToProvision, facilitators...)
+func CreateLabEnvironment(ctx context.Context, logger *slog.Logger, usersFile string, templateReposFile string) (err error) {
 
-	logger.Info("Proceeding with validated users",
-		slog.Int("student_count", len(users)),
-		slog.Int("facilitator_count", len(facilitators)),
-		slog.Int("total_provision_count", len(allUsersToProvision)),
-		slog.Int("invalid_user_count", len(invalidUsers)),
-		slog.Int("invalid_facilitator_count", len(invalidFacilitators)))
+	startTime := time.Now()
+
+	ctx, span := telemetry.StartSpan(ctx, "CreateLabEnvironment")
+	defer func() { telemetry.End(span, err) }()
 
-	templateRepos, err := util.LoadFromJsonFile(templateReposFile)
+	// Load the users and validate those that exist; observers do not get an organization
+	labPeople, err := prepareLabUsers(ctx, logger, usersFile)
 	if err != nil {
 		return err
 	}
@@ -170,6 +358,19 @@ func CreateLabEnvironment(ctx context.Context, logger *slog.Logger, usersFile st
 		return fmt.Errorf("enterprise slug not found in context")
 	}
 
+	definition, err := util.LoadLabDefinition(templateReposFile)
+	if err != nil {
+		return err
+	}
+	templateRepos := definition.Repos
+	// Resolve pinned templates and read local sources before any repository is created
+	if err := resolveTemplateRefs(ctx, logger, templateRepos); err != nil {
+		return err
+	}
+	if err := loadRepoSources(logger, templateRepos); err != nil {
+		return err
+	}
+
 	// Get lab date from context
 	labDate, ok := ctx.Value(config.LabDateKey).(string)
 	if !ok {
@@ -177,6 +378,67 @@ func CreateLabEnvironment(ctx context.Context, logger *slog.Logger, usersFile st
 		return fmt.Errorf("lab date not found in context")
 	}
 
+	// Check enterprise membership and that the lab fits the seats and GHAS committers left;
+	// managed users pending provisioning count as new seats
+	licenses, err := checkLicenses(ctx, logger, enterpriseSlug, labPeople)
+	if err != nil {
+		return err
+	}
+
+	// Check that the users fit the lab's topology before any account is created
+	if _, err := planLabOrgs(labDate, definition.Topology, labPeople.students, participatingFacilitators(definition, labPeople.facilitators)); err != nil {
+		return err
+	}
+
+	// Every check passed, so the managed users of an EMU lab can be created
+	if err := provisionPendingUsers(ctx, logger, labPeople); err != nil {
+		return err
+	}
+
+	invalidUsers := labPeople.invalidUsers
+	invalidFacilitators := labPeople.invalidFacilitators
+	facilitators := logins(labPeople.facilitators)
+	observers := logins(labPeople.observers)
+
+	// Update context with the validated facilitators, including those from the users file, and the observers
+	ctx = context.WithValue(ctx, config.FacilitatorsKey, facilitators)
+	ctx = context.WithValue(ctx, config.ObserversKey, observers)
+
+	// Combine users and facilitators for provisioning
+	allUsersToProvision := make([]util.LabUser, 0, len(labPeople.students)+len(labPeople.facilitators))
+	allUsersToProvision = append(allUsersToProvision, labPeople.students...)
+	allUsersToProvision = append(allUsersToProvision, labPeople.facilitators...)
+
+	logger.Info("Proceeding with validated users",
+		slog.Int("student_count", len(labPeople.students)),
+		slog.Int("facilitator_count", len(facilitators)),
+		slog.Int("observer_count", len(observers)),
+		slog.Int("total_provision_count", len(allUsersToProvision)),
+		slog.Int("invalid_user_count", len(invalidUsers)),
+		slog.Int("invalid_facilitator_count", len(invalidFacilitators)))
+
+	// Spread the users across organizations according to the lab's topology
+	plans, err := planLabOrgs(labDate, definition.Topology, labPeople.students, participatingFacilitators(definition, labPeople.facilitators))
+	if err != nil {
+		return err
+	}
+	orgCount := len(plans)
+	if definition.Topology == util.TopologySingleOrg {
+		orgCount = 1
+	}
+	logger.Info("Planned lab organizations",
+		slog.String("topology", string(definition.Topology)),
+		slog.Int("org_count", orgCount),
+		slog.Int("unit_count", len(plans)))
+	checkGitDataQuota(ctx, logger, templateRepos, len(plans), orgCount)
+
+	span.SetAttributes(
+		telemetry.AttrEnterpriseSlug.String(enterpriseSlug),
+		telemetry.AttrLabDate.String(labDate),
+		attribute.Int("ghas.user_count", len(allUsersToProvision)),
+		attribute.Int("ghas.template_repo_count", len(templateRepos)),
+		attribute.String("ghas.topology", string(definition.Topology)))
+
 	//Get Enterprise details
 	enterprise, err := api.GetEnterprise(ctx, logger, enterpriseSlug)
 	if err != nil {
@@ -184,31 +446,50 @@ func CreateLabEnvironment(ctx context.Context, logger *slog.Logger, usersFile st
 		return err
 	}
 
-	orgChan := make(chan string, len(allUsersToProvision))
+	// Register the provisioning phases for the terminal progress view
+	prog := progress.FromContext(ctx)
+	defer prog.Finish()
+	prog.AddPhase(phaseCreateOrgs, orgCount)
+	prog.AddPhase(phaseInstallApp, orgCount)
+	prog.AddPhase(phaseGenerateRepos, len(plans)*len(templateRepos))
+	for _, plan := range plans {
+		prog.SetStatus(plan.Key, progress.StatePending, "")
+	}
+
+	// A single-org lab shares one organization, created before the users are added to it
+	var shared *api.Organization
+	if definition.Topology == util.TopologySingleOrg {
+		shared, err = createSharedOrg(ctx, logger, enterprise, api.LabSharedOrgName(labDate))
+		if err != nil {
+			return err
+		}
+	}
+
+	orgChan := make(chan labOrgPlan, len(plans))
 	// Update channel size to accommodate all users
-	resultsChan := make(chan ProvisionResult, len(allUsersToProvision))
+	resultsChan := make(chan ProvisionResult, len(plans))
 
 	// Use WaitGroup to track worker goroutines
 	var wg sync.WaitGroup
 
 	// Calculate optimal number of workers: max 9 or number of users
 	numWorkers := 9
-	if len(allUsersToProvision) < numWorkers {
-		numWorkers = len(allUsersToProvision)
+	if len(plans) < numWorkers {
+		numWorkers = len(plans)
 	}
-	logger.Info("Starting workers", slog.Int("worker_count", numWorkers), slog.Int("total_user_count", len(allUsersToProvision)))
+	logger.Info("Starting workers", slog.Int("worker_count", numWorkers), slog.Int("total_user_count", len(plans)))
 
 	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
 		go func(workerId int) {
 			defer wg.Done()
-			ProvisionOrgResources(workerId, ctx, logger, orgChan, resultsChan, enterprise, templateRepos)
+			ProvisionOrgResources(workerId, ctx, logger, orgChan, resultsChan, enterprise, definition, shared)
 		}(i)
 	}
 
-	// Send all users (students + facilitators) to the channel
-	for _, user := range allUsersToProvision {
-		orgChan <- user
+	// Send all organizations (students + facilitators) to the channel
+	for _, plan := range plans {
+		orgChan <- plan
 	}
 	// Close orgChan immediately after sending all work
 	close(orgChan)
@@ -231,48 +512,76 @@ func CreateLabEnvironment(ctx context.Context, logger *slog.Logger, usersFile st
 			if !ok {
 				// Channel closed, all workers finished
 				logger.Info("All provisioning complete",
-					slog.Int("total", len(allUsersToProvision)),
+					slog.Int("total", len(plans)),
 					slog.Int("success", successCount),
 					slog.Int("failed", failureCount))
 
+				var sharedRoles []RoleAssignment
+				if shared != nil {
+					sharedRoles = assignStaffRoles(ctx, logger, shared, definition, "")
+				}
+
 				// Generate report
 				report := &LabReport{
 					GeneratedAt:         time.Now(),
+					StartedAt:           startTime,
+					Duration:            time.Since(startTime),
 					LabDate:             labDate,
 					EnterpriseSlug:      enterpriseSlug,
-					TotalUsers:          len(allUsersToProvision),
+					TotalUsers:          len(plans),
 					SuccessCount:        successCount,
 					FailureCount:        failureCount,
 					TemplateRepos:       getTemplateNames(templateRepos),
+					TemplatePins:        getTemplatePins(templateRepos),
+					Topology:            string(definition.Topology),
 					Facilitators:        facilitators,
+					Observers:           observers,
+					FacilitatorRole:     string(definition.Facilitators.Role),
+					ObserverRole:        string(definition.Observers.Role),
+					FacilitatorOwnOrgs:  definition.FacilitatorsOwnOrgs(),
+					SharedOrgRoles:      sharedRoles,
 					InvalidUsers:        invalidUsers,
 					InvalidFacilitators: invalidFacilitators,
+					Licenses:            licenses,
 					Organizations:       make([]OrgReport, 0, len(results)),
 				}
 
 				for _, res := range results {
 					orgReport := OrgReport{
 						User:         res.User,
+						UserProfile:  profileOf(res.Profile),
+						Members:      res.Members,
 						OrgName:      res.OrgName,
 						Status:       res.Status,
 						Error:        res.Error,
 						Repositories: res.Repos,
-						CreatedAt:    res.CompletedAt,
+						Roles:        res.Roles,
+						CreatedAt:    res.CreatedAt,
+						StartedAt:    res.StartedAt,
+						CompletedAt:  res.CompletedAt,
+						Steps:        res.Steps,
+					}
+					if res.Team != "" {
+						orgReport.Team = res.Team
 					}
 					report.Organizations = append(report.Organizations, orgReport)
 				}
+				report.StepMetrics = computeStepMetrics(report.Organizations)
+
+				// Stop the progress view before printing the report locations
+				prog.Finish()
 
 				// Generate report files
 				if err := GenerateReportFiles(report, "reports"); err != nil {
 					logger.Error("Failed to generate report files", slog.Any("error", err))
 				}
 
-				if resultCount == len(allUsersToProvision) {
+				if resultCount == len(plans) {
 					logger.Info("All organizations and repositories created successfully")
 					return nil
 				}
 				logger.Error("Workers finished but not all users processed",
-					slog.Int("expected", len(allUsersToProvision)),
+					slog.Int("expected", len(plans)),
 					slog.Int("processed", resultCount))
 				return ctx.Err()
 			}
@@ -283,7 +592,9 @@ func CreateLabEnvironment(ctx context.Context, logger *slog.Logger, usersFile st
 
 			if res.Status == "success" {
 				successCount++
-				logger.Info("Created organization", slog.String("org", res.OrgName))
+				logger.Info("Created organization",
+					slog.String("org", res.OrgName),
+					slog.Duration("took", res.CompletedAt.Sub(res.StartedAt)))
 			} else {
 				failureCount++
 				logger.Error("Failed to create organization",
@@ -291,6 +602,20 @@ func CreateLabEnvironment(ctx context.Context, logger *slog.Logger, usersFile st
 					slog.String("error", res.Error))
 			}
 
+			// Estimate remaining time from the average throughput so far
+			elapsed := time.Since(startTime)
+			remaining := len(plans) - resultCount
+			eta := time.Duration(0)
+			if resultCount > 0 {
+				eta = elapsed / time.Duration(resultCount) * time.Duration(remaining)
+			}
+			logger.Info("Provisioning progress",
+				slog.Int("completed", resultCount),
+				slog.Int("total", len(plans)),
+				slog.Float64("percent", float64(resultCount)/float64(len(plans))*100),
+				slog.Duration("elapsed", elapsed),
+				slog.Duration("eta", eta))
+
 		case <-ctx.Done():
 			logger.Error("Timeout reached while creating lab environment")
 			return ctx.Err()
@@ -302,11 +627,22 @@ func CreateLabEnvironment(ctx context.Context, logger *slog.Logger, usersFile st
 func getTemplateNames(configs []util.RepoConfig) []string {
 	names := make([]string, len(configs))
 	for i, config := range configs {
-		names[i] = config.Template
+		names[i] = config.SourceName()
 	}
 	return names
 }
 
+// getTemplatePins lists the commits the pinned templates resolved to
+func getTemplatePins(configs []util.RepoConfig) []TemplatePin {
+	var pins []TemplatePin
+	for _, config := range configs {
+		if config.PinnedSHA != "" {
+			pins = append(pins, TemplatePin{Template: config.Template, Ref: config.Ref, SHA: config.PinnedSHA})
+		}
+	}
+	return pins
+}
+
 func DestroyOrgResources(workerId int, ctx context.Context, logger *slog.Logger, userChan chan string, resultsChan chan string, enterprise *api.Enterprise, labDate string) {
 	logger.Info("Destroy worker started", slog.Int("workerId", workerId))
 
@@ -319,7 +655,7 @@ func DestroyOrgResources(workerId int, ctx context.Context, logger *slog.Logger,
 		default:
 		}
 
-		orgName := "ghas-labs-" + labDate + "-" + user
+		orgName := api.LabOrgName(labDate, user)
 		logger.Info("Deleting organization", slog.String("org", orgName), slog.String("user", user))
 
 		// Call the GraphQL-based DeleteOrg function
@@ -340,18 +676,23 @@ func DestroyOrgResources(workerId int, ctx context.Context, logger *slog.Logger,
 	logger.Info("Destroy worker stopped", slog.Int("workerId", workerId))
 }
 
-func DestroyLabEnvironment(ctx context.Context, logger *slog.Logger, labDate string, usersFile string) error {
+func DestroyLabEnvironment(ctx context.Context, logger *slog.Logger, labDate string, usersFile string, templateReposFile string) (err error) {
 
 	startTime := time.Now()
 
-	// Get users
-	logger.Info("Loading users from file", slog.String("file", usersFile))
-	users, err := util.LoadFromFile(usersFile)
+	ctx, span := telemetry.StartSpan(ctx, "DestroyLabEnvironment", telemetry.AttrLabDate.String(labDate))
+	defer func() { telemetry.End(span, err) }()
+
+	// Get users; managed users are deleted by their provisioned login and observers have no org
+	labPeople, err := loadLabUsers(ctx, logger, usersFile)
 	if err != nil {
 		return err
 	}
-
-	logger.Info("Loaded users", slog.Int("count", len(users)))
+	shortcode := managedUsersShortcode(ctx)
+	managedUsers := labPeople.managed
+	users := withLogins(logger, labPeople.students)
+	facilitatorUsers := withLogins(logger, labPeople.facilitators)
+	facilitators := logins(facilitatorUsers)
 
 	// Get enterprise slug from context
 	enterpriseSlug, ok := ctx.Value(config.EnterpriseSlugKey).(string)
@@ -360,19 +701,31 @@ func DestroyLabEnvironment(ctx context.Context, logger *slog.Logger, labDate str
 		return err
 	}
 
-	// Get facilitators from context
-	facilitators, _ := ctx.Value(config.FacilitatorsKey).([]string)
-
-	// Combine users and facilitators for deletion
-	allUsersToDelete := make([]string, 0, len(users)+len(facilitators))
-	allUsersToDelete = append(allUsersToDelete, users...)
-	allUsersToDelete = append(allUsersToDelete, facilitators...)
+	// The lab definition decides which organizations the users and facilitators were given
+	definition := util.DefaultLabDefinition()
+	if templateReposFile != "" {
+		definition, err = util.LoadLabDefinition(templateReposFile)
+		if err != nil {
+			return err
+		}
+	}
+	topology := definition.Topology
+	plans, err := planLabOrgs(labDate, topology, users, participatingFacilitators(definition, facilitatorUsers))
+	if err != nil {
+		return err
+	}
+	allUsersToDelete := orgsToDelete(plans, topology)
 
 	logger.Info("Proceeding with deletion",
 		slog.Int("student_count", len(users)),
 		slog.Int("facilitator_count", len(facilitators)),
+		slog.String("topology", string(topology)),
 		slog.Int("total_delete_count", len(allUsersToDelete)))
 
+	span.SetAttributes(
+		telemetry.AttrEnterpriseSlug.String(enterpriseSlug),
+		attribute.Int("ghas.user_count", len(allUsersToDelete)))
+
 	// Get Enterprise details
 	enterprise, err := api.GetEnterprise(ctx, logger, enterpriseSlug)
 	if err != nil {
@@ -385,14 +738,22 @@ func DestroyLabEnvironment(ctx context.Context, logger *slog.Logger, labDate str
 		GeneratedAt:    time.Now(),
 		LabDate:        labDate,
 		EnterpriseSlug: enterpriseSlug,
-		TotalUsers:     len(users),
+		TotalUsers:     len(allUsersToDelete),
 		SuccessCount:   0,
 		FailureCount:   0,
 		Organizations:  make([]DeleteOrgReport, 0),
 		Facilitators:   facilitators,
 	}
 
-	userChan := make(chan string, len(allUsersToDelete))
+	// Register the deletion phase for the terminal progress view
+	prog := progress.FromContext(ctx)
+	defer prog.Finish()
+	prog.AddPhase(phaseDeleteOrgs, len(allUsersToDelete))
+	for _, plan := range allUsersToDelete {
+		prog.SetStatus(plan.Key, progress.StatePending, "")
+	}
+
+	userChan := make(chan labOrgPlan, len(allUsersToDelete))
 	resultsChan := make(chan DeleteOrgReport, len(allUsersToDelete))
 
 	// Use WaitGroup to track worker goroutines
@@ -414,9 +775,9 @@ func DestroyLabEnvironment(ctx context.Context, logger *slog.Logger, labDate str
 		}(i)
 	}
 
-	// Send all users (students + facilitators) to the channel
-	for _, user := range allUsersToDelete {
-		userChan <- user
+	// Send all organizations (students + facilitators) to the channel
+	for _, plan := range allUsersToDelete {
+		userChan <- plan
 	}
 	// Close userChan immediately after sending all work
 	close(userChan)
@@ -442,7 +803,36 @@ func DestroyLabEnvironment(ctx context.Context, logger *slog.Logger, labDate str
 					slog.Int("failed", deleteReport.FailureCount),
 					slog.Duration("duration", time.Since(startTime)))
 
+				// Deprovision managed users once their organizations are gone
+				if shortcode != "" {
+					deprovisioned := deprovisionManagedUsers(ctx, logger, managedUsers, shortcode)
+					for i := range deleteReport.Organizations {
+						org := &deleteReport.Organizations[i]
+						deprovisionErr, ok := deprovisioned[org.User]
+						if !ok {
+							continue
+						}
+						delete(deprovisioned, org.User)
+						if deprovisionErr != nil {
+							org.DeprovisionError = deprovisionErr.Error()
+							deleteReport.DeprovisionFailureCount++
+						} else {
+							org.Deprovisioned = true
+						}
+					}
+					// Members of team and shared organizations are reported on their own
+					for _, login := range slices.Sorted(maps.Keys(deprovisioned)) {
+						status := ManagedUserReport{Login: login, Deprovisioned: deprovisioned[login] == nil}
+						if deprovisioned[login] != nil {
+							status.Error = deprovisioned[login].Error()
+							deleteReport.DeprovisionFailureCount++
+						}
+						deleteReport.ManagedUsers = append(deleteReport.ManagedUsers, status)
+					}
+				}
+
 				// Generate report
+				prog.Finish()
 				if err := GenerateDeleteReportFiles(deleteReport, "reports"); err != nil {
 					logger.Error("Failed to generate deletion report", slog.Any("error", err))
 				}
@@ -450,6 +840,9 @@ func DestroyLabEnvironment(ctx context.Context, logger *slog.Logger, labDate str
 				if deleteReport.FailureCount > 0 {
 					return fmt.Errorf("failed to delete %d organization(s)", deleteReport.FailureCount)
 				}
+				if deleteReport.DeprovisionFailureCount > 0 {
+					return fmt.Errorf("failed to deprovision %d managed user(s)", deleteReport.DeprovisionFailureCount)
+				}
 				return nil
 			}
 
@@ -466,6 +859,7 @@ func DestroyLabEnvironment(ctx context.Context, logger *slog.Logger, labDate str
 			logger.Error("Timeout reached while destroying lab environment")
 
 			// Generate report even on timeout
+			prog.Finish()
 			if err := GenerateDeleteReportFiles(deleteReport, "reports"); err != nil {
 				logger.Error("Failed to generate deletion report", slog.Any("error", err))
 			}
@@ -475,10 +869,17 @@ func DestroyLabEnvironment(ctx context.Context, logger *slog.Logger, labDate str
 	}
 }
 
-func DestroyOrgResourcesWithReport(workerId int, ctx context.Context, logger *slog.Logger, userChan chan string, resultsChan chan DeleteOrgReport, enterprise *api.Enterprise, labDate string) {
+func DestroyOrgResourcesWithReport(workerId int, ctx context.Context, logger *slog.Logger, userChan chan labOrgPlan, resultsChan chan DeleteOrgReport, enterprise *api.Enterprise, labDate string) {
 	logger.Info("Destroy worker started", slog.Int("workerId", workerId))
 
-	for user := range userChan {
+	ctx, span := telemetry.StartSpan(ctx, "DestroyWorker", telemetry.AttrWorkerID.Int(workerId))
+	defer span.End()
+
+	prog := progress.FromContext(ctx)
+
+	for plan := range userChan {
+		user := plan.Key
+
 		// Check if context is cancelled
 		select {
 		case <-ctx.Done():
@@ -487,18 +888,28 @@ func DestroyOrgResourcesWithReport(workerId int, ctx context.Context, logger *sl
 		default:
 		}
 
-		orgName := "ghas-labs-" + labDate + "-" + user
-		logger.Info("Deleting organization", slog.String("org", orgName), slog.String("user", user))
+		orgName := plan.OrgName
+		logger.Info("Deleting organization", slog.String("org", orgName), slog.String("user", plan.label()))
 
 		deleteTime := time.Now()
 		orgReport := DeleteOrgReport{
-			User:      user,
-			OrgName:   orgName,
-			DeletedAt: deleteTime,
+			User:        plan.User.Login,
+			UserProfile: profileOf(plan.User),
+			Members:     logins(plan.Members),
+			OrgName:     orgName,
+			DeletedAt:   deleteTime,
+		}
+		if plan.Team != "" {
+			orgReport.Team = plan.Team
 		}
 
 		// Call the GraphQL-based DeleteOrg function
-		if err := enterprise.DeleteOrg(ctx, logger, orgName); err != nil {
+		prog.SetStatus(user, progress.StateRunning, "deleting "+orgName)
+		orgCtx, orgSpan := telemetry.StartSpan(ctx, "DeleteOrg", telemetry.AttrUser.String(user), telemetry.AttrOrg.String(orgName))
+		err := enterprise.DeleteOrg(orgCtx, logger, orgName)
+		telemetry.End(orgSpan, err)
+		prog.Advance(phaseDeleteOrgs, 1)
+		if err != nil {
 			logger.Error("Failed to delete organization",
 				slog.String("user", user),
 				slog.String("org", orgName),
@@ -506,11 +917,13 @@ func DestroyOrgResourcesWithReport(workerId int, ctx context.Context, logger *sl
 
 			orgReport.Status = "failed"
 			orgReport.Error = err.Error()
+			prog.SetStatus(user, progress.StateFailed, orgReport.Error)
 			resultsChan <- orgReport
 			continue
 		}
 
 		orgReport.Status = "success"
+		prog.SetStatus(user, progress.StateDone, "deleted "+orgName)
 		resultsChan <- orgReport
 		logger.Info("Finished deleting organization", slog.String("org", orgName))
 	}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+	api "github.com/s-samadi/ghas-lab-builder/internal/github"
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
+)
+
+// labUsers are the people of a lab split by role
+type labUsers struct {
+	students     []util.LabUser
+	facilitators []util.LabUser
+	observers    []util.LabUser
+	// managed are the users file entries provisioned through SCIM in EMU mode
+	managed []util.LabUser
+
+	invalidUsers        []api.InvalidUser
+	invalidFacilitators []api.InvalidUser
+}
+
+// logins returns the logins of users
+func logins(users []util.LabUser) []string {
+	result := make([]string, len(users))
+	for i, user := range users {
+		result[i] = user.Login
+	}
+	return result
+}
+
+// withLogins returns the users that have a login, warning about those listed only by email
+func withLogins(logger *slog.Logger, users []util.LabUser) []util.LabUser {
+	kept := make([]util.LabUser, 0, len(users))
+	for _, user := range users {
+		if user.Login == "" {
+			logger.Warn("Skipping user without a login; set --emu-shortcode for users listed by email",
+				slog.String("email", user.Email))
+			continue
+		}
+		kept = append(kept, user)
+	}
+	return kept
+}
+
+// loadLabUsers reads the users file and merges in the facilitators given with --facilitators.
+// In EMU mode every user listed with an email gets the login it is, or will be, provisioned with.
+func loadLabUsers(ctx context.Context, logger *slog.Logger, usersFile string) (*labUsers, error) {
+	logger.Info("Loading users from file", slog.String("file", usersFile))
+	people, err := util.LoadUsersFromFile(usersFile)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Loaded users", slog.Int("count", len(people)))
+
+	shortcode := managedUsersShortcode(ctx)
+	users := &labUsers{}
+	seen := map[string]bool{}
+	for _, person := range people {
+		if shortcode != "" && person.Email != "" {
+			users.managed = append(users.managed, person)
+			person.Login = managedUserLogin(person, shortcode)
+		}
+		if person.Login != "" {
+			seen[strings.ToLower(person.Login)] = true
+		}
+
+		switch person.Role {
+		case util.RoleFacilitator:
+			users.facilitators = append(users.facilitators, person)
+		case util.RoleObserver:
+			users.observers = append(users.observers, person)
+		default:
+			users.students = append(users.students, person)
+		}
+	}
+
+	// Facilitators from the flag are merged in, unless the users file already lists them
+	flagFacilitators, _ := ctx.Value(config.FacilitatorsKey).([]string)
+	for _, login := range flagFacilitators {
+		if login = strings.TrimSpace(login); login == "" || seen[strings.ToLower(login)] {
+			continue
+		}
+		seen[strings.ToLower(login)] = true
+		users.facilitators = append(users.facilitators, util.LabUser{Login: login, Role: util.RoleFacilitator})
+	}
+
+	return users, nil
+}
+
+// prepareLabUsers loads the users of a lab and checks that every login exists. Managed users are
+// not created here; in EMU mode they are pending until provisionPendingUsers runs, after every other
+// check of the lab has passed, so a lab that cannot go ahead leaves no accounts behind.
+func prepareLabUsers(ctx context.Context, logger *slog.Logger, usersFile string) (*labUsers, error) {
+	users, err := loadLabUsers(ctx, logger, usersFile)
+	if err != nil {
+		return nil, err
+	}
+
+	// Managed users do not exist until they are provisioned, so they are not validated
+	pending := map[string]bool{}
+	if shortcode := managedUsersShortcode(ctx); shortcode != "" {
+		for _, user := range users.managed {
+			pending[managedUserLogin(user, shortcode)] = true
+		}
+	}
+
+	listedStudents := len(users.students)
+	users.students, err = validateLabUsers(ctx, logger, "users", users.students, pending, &users.invalidUsers)
+	if err != nil {
+		return nil, err
+	}
+	if listedStudents > 0 && len(users.students) == 0 {
+		return nil, fmt.Errorf("none of the %d users in %s can be provisioned", listedStudents, usersFile)
+	}
+	users.facilitators, err = validateLabUsers(ctx, logger, "facilitators", users.facilitators, pending, &users.invalidFacilitators)
+	if err != nil {
+		return nil, err
+	}
+	var invalidObservers []api.InvalidUser
+	users.observers, err = validateLabUsers(ctx, logger, "observers", users.observers, pending, &invalidObservers)
+	if err != nil {
+		return nil, err
+	}
+	users.invalidUsers = append(users.invalidUsers, invalidObservers...)
+
+	if len(users.facilitators) == 0 {
+		return nil, fmt.Errorf("at least one valid facilitator is required: use --facilitators or give users the %s role in the users file", util.RoleFacilitator)
+	}
+
+	return users, nil
+}
+
+// provisionPendingUsers creates the managed users of an EMU lab through SCIM. Users that cannot be
+// provisioned are dropped from the lab and reported as invalid.
+func provisionPendingUsers(ctx context.Context, logger *slog.Logger, users *labUsers) error {
+	shortcode := managedUsersShortcode(ctx)
+	if shortcode == "" || len(users.managed) == 0 {
+		return nil
+	}
+
+	_, failed := provisionManagedUsers(ctx, logger, users.managed, shortcode)
+	if len(failed) == 0 {
+		return nil
+	}
+	failedNames := map[string]bool{}
+	for _, name := range failed {
+		failedNames[name] = true
+	}
+
+	listedStudents := len(users.students)
+	var invalidUsers, invalidFacilitators, invalidObservers []api.InvalidUser
+	users.students, invalidUsers = dropFailed(users.students, failedNames)
+	users.facilitators, invalidFacilitators = dropFailed(users.facilitators, failedNames)
+	users.observers, invalidObservers = dropFailed(users.observers, failedNames)
+	users.invalidUsers = append(append(users.invalidUsers, invalidUsers...), invalidObservers...)
+	users.invalidFacilitators = append(users.invalidFacilitators, invalidFacilitators...)
+
+	if listedStudents > 0 && len(users.students) == 0 {
+		return fmt.Errorf("none of the %d users could be provisioned", listedStudents)
+	}
+	if len(users.facilitators) == 0 {
+		return fmt.Errorf("no facilitator could be provisioned")
+	}
+	return nil
+}
+
+// dropFailed removes the managed users whose SCIM provisioning failed, reporting them as invalid
+func dropFailed(users []util.LabUser, failed map[string]bool) ([]util.LabUser, []api.InvalidUser) {
+	kept := make([]util.LabUser, 0, len(users))
+	var dropped []api.InvalidUser
+	for _, user := range users {
+		if user.Email != "" && failed[user.UserName] {
+			dropped = append(dropped, api.InvalidUser{
+				Login:  user.Login,
+				Reason: api.ReasonProvisioningFailed,
+				Detail: fmt.Sprintf("SCIM provisioning of %s failed", user.UserName),
+			})
+			continue
+		}
+		kept = append(kept, user)
+	}
+	return kept, dropped
+}
+
+// validateLabUsers checks that the logins of users exist, skipping managed users pending provisioning.
+// Users without a login cannot be provisioned outside EMU mode and are reported as invalid.
+func validateLabUsers(ctx context.Context, logger *slog.Logger, kind string, users []util.LabUser, pending map[string]bool, invalid *[]api.InvalidUser) ([]util.LabUser, error) {
+	var toValidate []api.UserLookup
+	for _, user := range users {
+		if user.Login == "" {
+			logger.Warn("Skipping user without a login; set --emu-shortcode to provision users by email",
+				slog.String("email", user.Email))
+			*invalid = append(*invalid, api.InvalidUser{
+				Login:  user.Email,
+				Reason: api.ReasonMissingLogin,
+				Detail: "set --emu-shortcode to provision users by email",
+			})
+			continue
+		}
+		if !pending[user.Login] {
+			toValidate = append(toValidate, api.UserLookup{Login: user.Login, ID: user.ID})
+		}
+	}
+
+	valid := map[string]bool{}
+	if len(toValidate) > 0 {
+		logger.Info("Validating "+kind, slog.Int("count", len(toValidate)))
+		validation, err := api.ValidateUsers(ctx, logger, toValidate)
+		if err != nil {
+			logger.Error("Validation failed", slog.String("kind", kind), slog.Any("error", err))
+			return nil, fmt.Errorf("%s validation failed: %w", kind, err)
+		}
+		for _, login := range validation.ValidUsers {
+			valid[login] = true
+		}
+		*invalid = append(*invalid, validation.Invalid...)
+	}
+
+	kept := make([]util.LabUser, 0, len(users))
+	for _, user := range users {
+		if user.Login != "" && (pending[user.Login] || valid[user.Login]) {
+			kept = append(kept, user)
+		}
+	}
+	return kept, nil
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+	api "github.com/s-samadi/ghas-lab-builder/internal/github"
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
+)
+
+// LicenseReport records the seats and GitHub Advanced Security committers a lab consumes
+type LicenseReport struct {
+	SeatsConsumed       int `json:"seats_consumed"`
+	SeatsPurchased      int `json:"seats_purchased"`
+	NewSeats            int `json:"new_seats"`
+	CommittersActive    int `json:"committers_active"`
+	CommittersPurchased int `json:"committers_purchased"`
+	NewCommitters       int `json:"new_committers"`
+	// NewMembers are lab users without an enterprise license, each taking a new seat
+	NewMembers []string `json:"new_members,omitempty"`
+	// OutsideCollaborators become members of the enterprise when they join a lab organization
+	OutsideCollaborators []string `json:"outside_collaborators,omitempty"`
+	// Overage explains why the lab exceeds the licenses, when it was allowed to go ahead anyway
+	Overage string `json:"overage,omitempty"`
+	// Skipped is set when the license usage could not be read
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// SeatsAvailable returns the seats left before the lab is provisioned
+func (r *LicenseReport) SeatsAvailable() int {
+	return r.SeatsPurchased - r.SeatsConsumed
+}
+
+// CommittersAvailable returns the GitHub Advanced Security committers left before the lab is provisioned
+func (r *LicenseReport) CommittersAvailable() int {
+	return r.CommittersPurchased - r.CommittersActive
+}
+
+// allowLicenseOverage reports whether --allow-license-overage was given
+func allowLicenseOverage(ctx context.Context) bool {
+	allow, _ := ctx.Value(config.AllowLicenseOverageKey).(bool)
+	return allow
+}
+
+// checkLicenses checks the enterprise membership of the students and facilitators and works out how
+// many new seats and GitHub Advanced Security committers the lab consumes. In EMU mode users that are
+// not managed users of the enterprise cannot join, so they are moved to the invalid users.
+// The lab is refused when it needs more than the enterprise has, unless --allow-license-overage is set;
+// when the license usage cannot be read the check is skipped with a warning.
+func checkLicenses(ctx context.Context, logger *slog.Logger, enterpriseSlug string, people *labUsers) (*LicenseReport, error) {
+	allow := allowLicenseOverage(ctx)
+	report := &LicenseReport{}
+
+	if host, ok := ctx.Value(config.HostKey).(*config.Host); ok && host != nil {
+		if err := host.Supports(config.FeatureLicenseReporting); err != nil {
+			logger.Warn("Skipping license check", slog.Any("reason", err))
+			report.Skipped = err.Error()
+			return report, nil
+		}
+	}
+
+	licenses, err := api.GetConsumedLicenses(ctx, logger, enterpriseSlug)
+	var committers *api.AdvancedSecurityCommitters
+	if err == nil {
+		committers, err = api.GetAdvancedSecurityCommitters(ctx, logger, enterpriseSlug)
+	}
+	if err != nil {
+		logger.Warn("Skipping license check: the license usage cannot be read", slog.Any("error", err))
+		report.Skipped = err.Error()
+		return report, nil
+	}
+	report.SeatsConsumed = licenses.SeatsConsumed
+	report.SeatsPurchased = licenses.SeatsPurchased
+
+	// Managed users provisioned in this run may not be listed yet; they take a new seat
+	managed := map[string]bool{}
+	shortcode := managedUsersShortcode(ctx)
+	for _, user := range people.managed {
+		managed[strings.ToLower(managedUserLogin(user, shortcode))] = true
+	}
+
+	isMember := func(user util.LabUser, invalid *[]api.InvalidUser) bool {
+		login := strings.ToLower(user.Login)
+		licensed, ok := licenses.Users[login]
+		switch {
+		case ok:
+			if licensed.IsOutsideCollaborator() {
+				report.OutsideCollaborators = append(report.OutsideCollaborators, user.Login)
+			}
+			return true
+		case shortcode != "" && !managed[login]:
+			*invalid = append(*invalid, api.InvalidUser{
+				Login:  user.Login,
+				Reason: api.ReasonNotEnterpriseMember,
+				Detail: "only managed users of the enterprise can join lab organizations",
+			})
+			return false
+		default:
+			report.NewMembers = append(report.NewMembers, user.Login)
+			return true
+		}
+	}
+	people.students = filterUsers(people.students, func(user util.LabUser) bool { return isMember(user, &people.invalidUsers) })
+	people.facilitators = filterUsers(people.facilitators, func(user util.LabUser) bool { return isMember(user, &people.invalidFacilitators) })
+	if len(people.facilitators) == 0 {
+		return nil, fmt.Errorf("no facilitator is a managed user of the enterprise")
+	}
+	report.NewSeats = len(report.NewMembers)
+	report.CommittersActive = committers.Active
+	report.CommittersPurchased = committers.Purchased
+	report.NewCommitters = countNewCommitters(people, committers)
+
+	logger.Info("License check",
+		slog.Int("new_seats", report.NewSeats),
+		slog.Int("seats_available", report.SeatsAvailable()),
+		slog.Int("new_committers", report.NewCommitters),
+		slog.Int("committers_available", report.CommittersAvailable()),
+		slog.Int("outside_collaborators", len(report.OutsideCollaborators)))
+
+	var overage []string
+	if report.SeatsPurchased > 0 && report.NewSeats > report.SeatsAvailable() {
+		overage = append(overage, fmt.Sprintf("%d new seats are needed but %d are available", report.NewSeats, max(report.SeatsAvailable(), 0)))
+	}
+	if report.CommittersPurchased > 0 && report.NewCommitters > report.CommittersAvailable() {
+		overage = append(overage, fmt.Sprintf("%d new GitHub Advanced Security committers are needed but %d are available", report.NewCommitters, max(report.CommittersAvailable(), 0)))
+	}
+	if len(overage) > 0 {
+		report.Overage = strings.Join(overage, "; ")
+		if !allow {
+			return nil, fmt.Errorf("the lab exceeds the enterprise licenses: %s (use --allow-license-overage to go ahead anyway)", report.Overage)
+		}
+		logger.Warn("The lab exceeds the enterprise licenses", slog.String("overage", report.Overage))
+	}
+
+	return report, nil
+}
+
+// countNewCommitters counts the students and facilitators who are not yet GitHub Advanced Security
+// committers; every lab user pushing to a lab repository becomes one
+func countNewCommitters(people *labUsers, committers *api.AdvancedSecurityCommitters) int {
+	count := 0
+	for _, users := range [][]util.LabUser{people.students, people.facilitators} {
+		for _, user := range users {
+			if !committers.Logins[strings.ToLower(user.Login)] {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// filterUsers returns the users keep accepts
+func filterUsers(users []util.LabUser, keep func(util.LabUser) bool) []util.LabUser {
+	kept := make([]util.LabUser, 0, len(users))
+	for _, user := range users {
+		if keep(user) {
+			kept = append(kept, user)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Provisioning step names recorded in OrgReport.Steps
+const (
+	StepCreateOrg     = "create_org"
+	StepInstallApp    = "install_app"
+	StepAssignRoles   = "assign_roles"
+	StepAddMembers    = "add_members"
+	StepGenerateRepo  = "generate_repo"
+	StepWaitRepo      = "wait_repo"
+	StepPinRepo       = "pin_repo"
+	StepImportRepo    = "import_repo"
+	StepConfigureRepo = "configure_repo"
+	StepSeedRepo      = "seed_repo"
+	StepProvisionOrg  = "provision_org"
+)
+
+// StepTiming records when a single provisioning step started and finished
+type StepTiming struct {
+	Step      string        `json:"step"`
+	Target    string        `json:"target,omitempty"`
+	StartedAt time.Time     `json:"started_at"`
+	EndedAt   time.Time     `json:"ended_at"`
+	Duration  time.Duration `json:"duration"`
+	Failed    bool          `json:"failed,omitempty"`
+
+	span trace.Span
+}
+
+// StepMetric summarises the durations of one step across the whole lab
+type StepMetric struct {
+	Step string `json:"step"`
+	// Count is the number of steps that succeeded; only their durations are summarised
+	Count int `json:"count"`
+	// Failed is the number of steps that failed, often long before a successful step would have ended
+	Failed int           `json:"failed"`
+	Min    time.Duration `json:"min"`
+	P50    time.Duration `json:"p50"`
+	P90    time.Duration `json:"p90"`
+	P95    time.Duration `json:"p95"`
+	Max    time.Duration `json:"max"`
+	Total  time.Duration `json:"total"`
+}
+
+// startStep begins timing a step for the given target (user, org or repo) and opens a trace span for it
+// with any further attributes. The returned context carries the span so GitHub calls made during the
+// step are nested beneath it.
+func startStep(ctx context.Context, step string, target string, attrs ...attribute.KeyValue) (context.Context, StepTiming) {
+	ctx, span := telemetry.StartSpan(ctx, step, append([]attribute.KeyValue{telemetry.AttrTarget.String(target)}, attrs...)...)
+	return ctx, StepTiming{
+		Step:      step,
+		Target:    target,
+		StartedAt: time.Now(),
+		span:      span,
+	}
+}
+
+// finish stamps the end time and duration of the step and ends its span
+func (s StepTiming) finish(err error) StepTiming {
+	s.EndedAt = time.Now()
+	s.Duration = s.EndedAt.Sub(s.StartedAt)
+	s.Failed = err != nil
+	if s.span != nil {
+		telemetry.End(s.span, err)
+		s.span = nil
+	}
+	return s
+}
+
+// computeStepMetrics aggregates step timings from every organization into per-step percentiles.
+// Failed steps are counted but left out of the durations, so fast failures do not skew them.
+// Steps are returned in the order they are first seen so the report follows the provisioning flow.
+func computeStepMetrics(orgs []OrgReport) []StepMetric {
+	durations := make(map[string][]time.Duration)
+	failed := make(map[string]int)
+	order := []string{}
+
+	for _, org := range orgs {
+		for _, step := range org.Steps {
+			if _, ok := durations[step.Step]; !ok {
+				order = append(order, step.Step)
+				durations[step.Step] = nil
+			}
+			if step.Failed {
+				failed[step.Step]++
+				continue
+			}
+			durations[step.Step] = append(durations[step.Step], step.Duration)
+		}
+	}
+
+	metrics := make([]StepMetric, 0, len(order))
+	for _, name := range order {
+		values := durations[name]
+		sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+		metric := StepMetric{Step: name, Count: len(values), Failed: failed[name]}
+		if len(values) > 0 {
+			for _, v := range values {
+				metric.Total += v
+			}
+			metric.Min = values[0]
+			metric.P50 = percentile(values, 50)
+			metric.P90 = percentile(values, 90)
+			metric.P95 = percentile(values, 95)
+			metric.Max = values[len(values)-1]
+		}
+		metrics = append(metrics, metric)
+	}
+
+	return metrics
+}
+
+// percentile returns the nearest-rank percentile p (0-100) of an ascending slice
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// formatDuration renders a duration rounded for display in reports
+func formatDuration(d time.Duration) string {
+	switch {
+	case d <= 0:
+		return "-"
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	default:
+		return d.Round(100 * time.Millisecond).String()
+	}
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	ten := make([]time.Duration, 10)
+	for i := range ten {
+		ten[i] = time.Duration(i+1) * time.Second
+	}
+
+	tests := []struct {
+		name   string
+		sorted []time.Duration
+		p      float64
+		want   time.Duration
+	}{
+		{"empty", nil, 50, 0},
+		{"single value", []time.Duration{3 * time.Second}, 95, 3 * time.Second},
+		{"p0 is the minimum", ten, 0, 1 * time.Second},
+		{"p50 of ten", ten, 50, 5 * time.Second},
+		{"p90 of ten", ten, 90, 9 * time.Second},
+		{"p95 rounds up to the next rank", ten, 95, 10 * time.Second},
+		{"p100 is the maximum", ten, 100, 10 * time.Second},
+		{"above 100 is clamped", ten, 150, 10 * time.Second},
+		{"p50 of two is the lower value", []time.Duration{time.Second, 2 * time.Second}, 50, time.Second},
+		{"p51 of two is the upper value", []time.Duration{time.Second, 2 * time.Second}, 51, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.sorted, tt.p); got != tt.want {
+				t.Errorf("percentile(%v, %v) = %v, want %v", tt.sorted, tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeStepMetrics(t *testing.T) {
+	orgs := []OrgReport{
+		{Steps: []StepTiming{
+			{Step: StepCreateOrg, Duration: 3 * time.Second},
+			{Step: StepGenerateRepo, Duration: 2 * time.Second},
+		}},
+		{Steps: []StepTiming{
+			{Step: StepCreateOrg, Duration: time.Second},
+			{Step: StepGenerateRepo, Duration: 4 * time.Second},
+			{Step: StepGenerateRepo, Duration: time.Second},
+			{Step: StepWaitRepo, Duration: 50 * time.Millisecond, Failed: true},
+		}},
+		{Steps: []StepTiming{
+			{Step: StepCreateOrg, Duration: 100 * time.Millisecond, Failed: true},
+		}},
+	}
+
+	metrics := computeStepMetrics(orgs)
+	want := []StepMetric{
+		{Step: StepCreateOrg, Count: 2, Failed: 1, Min: time.Second, P50: time.Second, P90: 3 * time.Second, P95: 3 * time.Second, Max: 3 * time.Second, Total: 4 * time.Second},
+		{Step: StepGenerateRepo, Count: 3, Min: time.Second, P50: 2 * time.Second, P90: 4 * time.Second, P95: 4 * time.Second, Max: 4 * time.Second, Total: 7 * time.Second},
+		{Step: StepWaitRepo, Failed: 1},
+	}
+	if len(metrics) != len(want) {
+		t.Fatalf("got %d metrics, want %d", len(metrics), len(want))
+	}
+	for i := range want {
+		if metrics[i] != want[i] {
+			t.Errorf("metric %d = %+v, want %+v", i, metrics[i], want[i])
+		}
+	}
+}
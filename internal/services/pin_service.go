@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	api "github.com/s-samadi/ghas-lab-builder/internal/github"
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
+)
+
+// resolveTemplateRefs resolves the ref of every pinned template to a commit once, so every repository
+// of the lab gets the same content even if the template changes while the lab is provisioned. The
+// content of the pinned commit is read once as well and shared by every organization of the lab.
+func resolveTemplateRefs(ctx context.Context, logger *slog.Logger, repos []util.RepoConfig) error {
+	snapshots := map[string]*util.RepoSnapshot{}
+	for i := range repos {
+		if repos[i].Ref == "" || repos[i].IsImported() {
+			continue
+		}
+		sha, err := api.ResolveRef(ctx, logger, repos[i].Template, repos[i].Ref)
+		if err != nil {
+			return err
+		}
+		repos[i].PinnedSHA = sha
+
+		key := repos[i].Template + "@" + sha
+		snapshot, ok := snapshots[key]
+		if !ok {
+			snapshot, err = readTemplateCommit(ctx, logger, repos[i].Template, sha)
+			if err != nil {
+				return err
+			}
+			snapshots[key] = snapshot
+		}
+		repos[i].Snapshot = snapshot
+		logger.Info("Pinned template",
+			slog.String("template", repos[i].Template),
+			slog.String("ref", repos[i].Ref),
+			slog.String("sha", sha),
+			slog.Int("files", len(snapshot.Files)))
+	}
+	return nil
+}
+
+// readTemplateCommit reads the files of a commit of a template, fetching each distinct blob once
+func readTemplateCommit(ctx context.Context, logger *slog.Logger, template string, sha string) (*util.RepoSnapshot, error) {
+	commit, err := api.GetGitCommit(ctx, logger, template, sha)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := api.GetTree(ctx, logger, template, commit.Tree.SHA)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &util.RepoSnapshot{Commit: sha, Tree: commit.Tree.SHA}
+	blobs := map[string][]byte{}
+	for _, entry := range entries {
+		switch entry.Type {
+		case "tree":
+			// Directories are rebuilt from the paths of their files
+			continue
+		case "commit":
+			snapshot.Files = append(snapshot.Files, util.SourceFile{Path: entry.Path, Mode: entry.Mode, SHA: entry.SHA})
+			continue
+		}
+		content, ok := blobs[entry.SHA]
+		if !ok {
+			encoded, err := api.GetBlob(ctx, logger, template, entry.SHA)
+			if err != nil {
+				return nil, err
+			}
+			content, err = base64.StdEncoding.DecodeString(strings.ReplaceAll(encoded, "\n", ""))
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode blob %s of %s: %w", entry.SHA, template, err)
+			}
+			if got := util.BlobSHA(content); got != entry.SHA {
+				return nil, fmt.Errorf("blob %s of %s has SHA %s", entry.SHA, template, got)
+			}
+			blobs[entry.SHA] = content
+		}
+		snapshot.Files = append(snapshot.Files, util.SourceFile{Path: entry.Path, Mode: entry.Mode, Content: content})
+	}
+	return snapshot, nil
+}
+
+// pinRepo commits the content of the template's pinned commit on top of the default branch of a generated
+// repository. Generated repositories have a history of their own, so the pinned commit cannot be checked out;
+// instead its tree is rebuilt from the blobs read by resolveTemplateRefs, uploading only those the repository
+// lacks. Git trees are content addressed, so the rebuilt tree has the same SHA as the pinned commit's, which
+// is returned as proof of the content.
+func pinRepo(ctx context.Context, logger *slog.Logger, organization *api.Organization, repo *api.Repository, repoName string, repoConfig util.RepoConfig) (string, error) {
+	fullName := organization.Login + "/" + repoName
+	pinned := repoConfig.Snapshot
+	if pinned == nil {
+		return "", fmt.Errorf("content of %s@%s was not read", repoConfig.Template, repoConfig.PinnedSHA)
+	}
+
+	headSHA, err := organization.GetBranchSHA(ctx, logger, repoName, repo.DefaultBranch)
+	if err != nil {
+		return "", err
+	}
+	head, err := api.GetGitCommit(ctx, logger, fullName, headSHA)
+	if err != nil {
+		return "", err
+	}
+	if head.Tree.SHA == pinned.Tree {
+		logger.Info("Repository already has the pinned content", slog.String("repo", repoName), slog.String("tree", pinned.Tree))
+		return pinned.Tree, nil
+	}
+
+	present := map[string]bool{}
+	headEntries, err := api.GetTree(ctx, logger, fullName, head.Tree.SHA)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range headEntries {
+		present[entry.SHA] = true
+	}
+
+	files := make([]api.TreeEntry, 0, len(pinned.Files))
+	copied := 0
+	for _, file := range pinned.Files {
+		if file.Mode == "160000" {
+			files = append(files, api.TreeEntry{Path: file.Path, Mode: file.Mode, Type: "commit", SHA: file.SHA})
+			continue
+		}
+		sha := util.BlobSHA(file.Content)
+		if !present[sha] {
+			blobSHA, err := organization.CreateBlob(ctx, logger, repoName, base64.StdEncoding.EncodeToString(file.Content))
+			if err != nil {
+				return "", err
+			}
+			if blobSHA != sha {
+				return "", fmt.Errorf("copy of %s has SHA %s instead of %s", file.Path, blobSHA, sha)
+			}
+			present[sha] = true
+			copied++
+		}
+		files = append(files, api.TreeEntry{Path: file.Path, Mode: file.Mode, Type: "blob", SHA: sha})
+	}
+
+	treeSHA, err := organization.CreateTree(ctx, logger, repoName, files)
+	if err != nil {
+		return "", err
+	}
+	if treeSHA != pinned.Tree {
+		return "", fmt.Errorf("rebuilt tree %s does not match tree %s of %s@%s", treeSHA, pinned.Tree, repoConfig.Template, repoConfig.PinnedSHA)
+	}
+
+	message := fmt.Sprintf("Pin to %s@%s (%s)", repoConfig.Template, repoConfig.Ref, repoConfig.PinnedSHA)
+	commitSHA, err := organization.CreateCommit(ctx, logger, repoName, message, treeSHA, []string{headSHA})
+	if err != nil {
+		return "", err
+	}
+	if err := organization.UpdateBranch(ctx, logger, repoName, repo.DefaultBranch, commitSHA, false); err != nil {
+		return "", err
+	}
+
+	logger.Info("Pinned repository",
+		slog.String("repo", repoName),
+		slog.String("template_sha", repoConfig.PinnedSHA),
+		slog.String("tree", treeSHA),
+		slog.Int("blobs_copied", copied))
+
+	return treeSHA, nil
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
+)
+
+func TestResolveTemplateRefs(t *testing.T) {
+	readme := []byte("# Demo\n")
+	app := []byte("print('hello')\n")
+	blobs := map[string][]byte{util.BlobSHA(readme): readme, util.BlobSHA(app): app}
+	tree := []map[string]string{
+		{"path": "README.md", "mode": "100644", "type": "blob", "sha": util.BlobSHA(readme)},
+		{"path": "src", "mode": "040000", "type": "tree", "sha": "tree-src"},
+		{"path": "src/app.py", "mode": "100644", "type": "blob", "sha": util.BlobSHA(app)},
+		{"path": "src/copy.py", "mode": "100644", "type": "blob", "sha": util.BlobSHA(app)},
+		{"path": "vendor/lib", "mode": "160000", "type": "commit", "sha": "submodule-commit"},
+	}
+
+	var mu sync.Mutex
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+
+		path := strings.TrimPrefix(r.URL.Path, "/repos/octo/demo/")
+		switch {
+		case path == "commits/v1.0":
+			json.NewEncoder(w).Encode(map[string]string{"sha": "pinned-commit"})
+		case path == "git/commits/pinned-commit":
+			json.NewEncoder(w).Encode(map[string]any{"sha": "pinned-commit", "tree": map[string]string{"sha": "pinned-tree"}})
+		case path == "git/trees/pinned-tree":
+			json.NewEncoder(w).Encode(map[string]any{"tree": tree})
+		case strings.HasPrefix(path, "git/blobs/"):
+			content, ok := blobs[strings.TrimPrefix(path, "git/blobs/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"content": base64.StdEncoding.EncodeToString(content), "encoding": "base64"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	ctx := context.WithValue(context.Background(), config.TokenKey, "test-token")
+	ctx = context.WithValue(ctx, config.BaseURLKey, server.URL)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	repos := []util.RepoConfig{
+		{Template: "octo/demo", Ref: "v1.0", Source: util.SourceTemplate},
+		{Template: "octo/demo", Ref: "v1.0", Source: util.SourceTemplate, Name: "demo-2"},
+		{Template: "octo/other", Source: util.SourceTemplate},
+	}
+	if err := resolveTemplateRefs(ctx, logger, repos); err != nil {
+		t.Fatalf("resolveTemplateRefs: %v", err)
+	}
+
+	if repos[0].PinnedSHA != "pinned-commit" || repos[1].PinnedSHA != "pinned-commit" {
+		t.Errorf("pinned SHAs = %q, %q, want pinned-commit", repos[0].PinnedSHA, repos[1].PinnedSHA)
+	}
+	if repos[2].PinnedSHA != "" || repos[2].Snapshot != nil {
+		t.Errorf("unpinned template was resolved: %+v", repos[2])
+	}
+	if repos[0].Snapshot == nil || repos[0].Snapshot != repos[1].Snapshot {
+		t.Fatalf("repositories pinned to the same commit do not share its content")
+	}
+
+	snapshot := repos[0].Snapshot
+	if snapshot.Commit != "pinned-commit" || snapshot.Tree != "pinned-tree" {
+		t.Errorf("snapshot commit %q tree %q", snapshot.Commit, snapshot.Tree)
+	}
+	if len(snapshot.Files) != 4 {
+		t.Fatalf("snapshot has %d files, want 4 without directories", len(snapshot.Files))
+	}
+	for _, file := range snapshot.Files {
+		switch file.Path {
+		case "src/app.py", "src/copy.py":
+			if string(file.Content) != string(app) {
+				t.Errorf("%s content = %q", file.Path, file.Content)
+			}
+		case "vendor/lib":
+			if file.SHA != "submodule-commit" || file.Content != nil {
+				t.Errorf("submodule = %+v", file)
+			}
+		}
+	}
+
+	for sha := range blobs {
+		if got := requests["/repos/octo/demo/git/blobs/"+sha]; got != 1 {
+			t.Errorf("blob %s fetched %d times, want once", sha, got)
+		}
+	}
+	if got := requests["/repos/octo/demo/git/trees/pinned-tree"]; got != 1 {
+		t.Errorf("tree fetched %d times, want once", got)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"slices"
+	"time"
 
 	"github.com/s-samadi/ghas-lab-builder/internal/config"
 	api "github.com/s-samadi/ghas-lab-builder/internal/github"
@@ -29,6 +31,13 @@ func CreateReposInLabOrg(ctx context.Context, logger *slog.Logger, templateRepos
 		return fmt.Errorf("failed to load template repositories: %w", err)
 	}
 
+	if err := resolveTemplateRefs(ctx, logger, templateRepos); err != nil {
+		return err
+	}
+	if err := loadRepoSources(logger, templateRepos); err != nil {
+		return err
+	}
+
 	logger.Info("Loaded template repositories",
 		slog.Int("count", len(templateRepos)),
 		slog.String("org", orgName))
@@ -48,14 +57,38 @@ func CreateReposInLabOrg(ctx context.Context, logger *slog.Logger, templateRepos
 	successCount := 0
 	for _, repoConfig := range templateRepos {
 		logger.Info("Creating repository from template",
-			slog.String("template", repoConfig.Template),
+			slog.String("template", repoConfig.SourceName()),
 			slog.Bool("include_all_branches", repoConfig.IncludeAllBranches),
 			slog.String("org", orgName))
 
-		_, err := organization.CreateRepoFromTemplate(ctx, logger, repoConfig.Template, repoConfig.IncludeAllBranches)
+		repoName := repoConfig.RepoName()
+		createdRepo, err := createRepo(ctx, logger, organization, repoName, repoConfig)
+		if err == nil {
+			_, err = waitForRepo(ctx, logger, organization, createdRepo, repoName, repoConfig)
+		}
+		if err == nil && repoConfig.IsImported() {
+			if _, err = importRepo(ctx, logger, organization, createdRepo, repoName, repoConfig); err != nil {
+				err = fmt.Errorf("failed to import %s: %w", repoConfig.Path, err)
+			}
+		}
+		if err == nil && repoConfig.PinnedSHA != "" {
+			if _, err = pinRepo(ctx, logger, organization, createdRepo, repoName, repoConfig); err != nil {
+				err = fmt.Errorf("failed to pin repository to %s: %w", repoConfig.Ref, err)
+			}
+		}
+		if err == nil && repoConfig.HasSettings() {
+			if err = configureRepo(ctx, logger, organization, createdRepo, repoName, repoConfig); err != nil {
+				err = fmt.Errorf("failed to configure repository: %w", err)
+			}
+		}
+		if err == nil && repoConfig.Seed != nil {
+			if _, err = seedRepository(ctx, logger, organization, createdRepo, repoName, repoConfig.Seed); err != nil {
+				err = fmt.Errorf("failed to seed repository: %w", err)
+			}
+		}
 		if err != nil {
 			logger.Error("Failed to create repository",
-				slog.String("repo", repoConfig.Template),
+				slog.String("repo", repoConfig.SourceName()),
 				slog.String("org", orgName),
 				slog.Any("error", err))
 			// Continue with other repos even if one fails
@@ -64,7 +97,7 @@ func CreateReposInLabOrg(ctx context.Context, logger *slog.Logger, templateRepos
 
 		successCount++
 		logger.Info("Successfully created repository",
-			slog.String("template", repoConfig.Template),
+			slog.String("template", repoConfig.SourceName()),
 			slog.String("org", orgName))
 	}
 
@@ -160,3 +193,80 @@ func DeleteReposInLabOrg(ctx context.Context, logger *slog.Logger, repoNames []s
 
 	return nil
 }
+
+// generateOptions returns the name, description and visibility a repository is generated with
+func generateOptions(repoConfig util.RepoConfig, repoName string) api.GenerateOptions {
+	return api.GenerateOptions{
+		Name:               repoName,
+		Description:        repoConfig.Description,
+		Public:             repoConfig.Visibility == "public",
+		IncludeAllBranches: repoConfig.IncludeAllBranches,
+	}
+}
+
+// waitForRepo waits until a generated repository has the branches of its template: its default branch, or
+// every branch when all branches are included
+func waitForRepo(ctx context.Context, logger *slog.Logger, organization *api.Organization, repo *api.Repository, repoName string, repoConfig util.RepoConfig) (time.Duration, error) {
+	var branches []string
+	if repo.DefaultBranch != "" {
+		branches = append(branches, repo.DefaultBranch)
+	}
+	if repoConfig.IncludeAllBranches {
+		templateBranches, err := api.ListBranches(ctx, logger, repoConfig.Template)
+		if err != nil {
+			return 0, fmt.Errorf("failed to list the branches of %s: %w", repoConfig.Template, err)
+		}
+		for _, branch := range templateBranches {
+			if !slices.Contains(branches, branch) {
+				branches = append(branches, branch)
+			}
+		}
+	}
+	return organization.WaitForBranches(ctx, logger, repoName, branches)
+}
+
+// configureRepo applies the settings of a generated repository that cannot be given when it is generated:
+// internal visibility, the default branch, features, topics and GitHub Actions
+func configureRepo(ctx context.Context, logger *slog.Logger, organization *api.Organization, repo *api.Repository, repoName string, repoConfig util.RepoConfig) error {
+	settings := map[string]any{}
+	if repoConfig.Visibility == "internal" {
+		settings["visibility"] = "internal"
+	}
+	if repoConfig.DefaultBranch != "" {
+		settings["default_branch"] = repoConfig.DefaultBranch
+	}
+	if features := repoConfig.Features; features != nil {
+		for key, enabled := range map[string]*bool{
+			"has_issues":      features.Issues,
+			"has_wiki":        features.Wiki,
+			"has_projects":    features.Projects,
+			"has_discussions": features.Discussions,
+		} {
+			if enabled != nil {
+				settings[key] = *enabled
+			}
+		}
+	}
+	if len(settings) > 0 {
+		updated, err := organization.UpdateRepository(ctx, logger, repoName, settings)
+		if err != nil {
+			return err
+		}
+		// Seeded pull requests target the new default branch
+		if updated.DefaultBranch != "" {
+			repo.DefaultBranch = updated.DefaultBranch
+		}
+	}
+
+	if len(repoConfig.Topics) > 0 {
+		if err := organization.ReplaceTopics(ctx, logger, repoName, repoConfig.Topics); err != nil {
+			return err
+		}
+	}
+	if repoConfig.ActionsEnabled != nil {
+		if err := organization.SetActionsEnabled(ctx, logger, repoName, *repoConfig.ActionsEnabled); err != nil {
+			return err
+		}
+	}
+	return nil
+}
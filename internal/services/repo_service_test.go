@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+	api "github.com/s-samadi/ghas-lab-builder/internal/github"
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
+)
+
+func TestConfigureRepo(t *testing.T) {
+	enabled, disabled := true, false
+
+	tests := []struct {
+		name       string
+		repoConfig util.RepoConfig
+		// want maps each request the repository is configured with to its decoded body
+		want          map[string]string
+		defaultBranch string
+		status        int
+		wantErr       string
+	}{
+		{
+			name:          "nothing to configure",
+			repoConfig:    util.RepoConfig{Visibility: "private"},
+			want:          map[string]string{},
+			defaultBranch: "main",
+		},
+		{
+			name: "settings",
+			repoConfig: util.RepoConfig{
+				Visibility:    "internal",
+				DefaultBranch: "develop",
+				Features:      &util.RepoFeatures{Issues: &enabled, Wiki: &disabled},
+			},
+			want: map[string]string{
+				"PATCH /repos/lab/demo": `{"default_branch":"develop","has_issues":true,"has_wiki":false,"visibility":"internal"}`,
+			},
+			defaultBranch: "develop",
+		},
+		{
+			name:       "topics and actions",
+			repoConfig: util.RepoConfig{Topics: []string{"ghas", "lab"}, ActionsEnabled: &disabled},
+			want: map[string]string{
+				"PUT /repos/lab/demo/topics":              `{"names":["ghas","lab"]}`,
+				"PUT /repos/lab/demo/actions/permissions": `{"enabled":false}`,
+			},
+			defaultBranch: "main",
+		},
+		{
+			name:       "rejected settings",
+			repoConfig: util.RepoConfig{Visibility: "internal"},
+			want:       map[string]string{"PATCH /repos/lab/demo": `{"visibility":"internal"}`},
+			status:     http.StatusUnprocessableEntity,
+			wantErr:    "422",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			got := map[string]string{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body map[string]any
+				if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+					t.Errorf("decoding %s %s: %v", r.Method, r.URL.Path, err)
+				}
+				encoded, _ := json.Marshal(body)
+				mu.Lock()
+				got[r.Method+" "+r.URL.Path] = string(encoded)
+				mu.Unlock()
+
+				switch {
+				case tt.status != 0:
+					w.WriteHeader(tt.status)
+					io.WriteString(w, `{"message":"Validation Failed"}`)
+				case r.Method == http.MethodPatch:
+					branch, _ := body["default_branch"].(string)
+					json.NewEncoder(w).Encode(api.Repository{FullName: "lab/demo", DefaultBranch: branch})
+				case strings.HasSuffix(r.URL.Path, "/topics"):
+					io.WriteString(w, `{"names":[]}`)
+				default:
+					w.WriteHeader(http.StatusNoContent)
+				}
+			}))
+			defer server.Close()
+
+			ctx := context.WithValue(context.Background(), config.TokenKey, "test-token")
+			ctx = context.WithValue(ctx, config.BaseURLKey, server.URL)
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			repo := &api.Repository{FullName: "lab/demo", DefaultBranch: "main"}
+			err := configureRepo(ctx, logger, &api.Organization{Login: "lab"}, repo, "demo", tt.repoConfig)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("requests = %v, want %v", got, tt.want)
+			}
+			if tt.wantErr == "" && repo.DefaultBranch != tt.defaultBranch {
+				t.Errorf("default branch = %q, want %q", repo.DefaultBranch, tt.defaultBranch)
+			}
+		})
+	}
+}
+
+func TestWaitForRepo(t *testing.T) {
+	tests := []struct {
+		name               string
+		includeAllBranches bool
+		template           []string
+		copied             []string
+		wantErr            string
+	}{
+		{"default branch", false, nil, []string{"main"}, ""},
+		{"every template branch", true, []string{"main", "feature"}, []string{"feature", "main"}, ""},
+		{"template branch not copied yet", true, []string{"main", "feature"}, []string{"main"}, "deadline exceeded"},
+		{"template branches cannot be listed", true, nil, []string{"main"}, "failed to list the branches of octo/demo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			branches := map[string][]string{"/repos/octo/demo/branches": tt.template, "/repos/lab/demo/branches": tt.copied}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				names, ok := branches[r.URL.Path]
+				if !ok || names == nil {
+					http.NotFound(w, r)
+					return
+				}
+				result := []map[string]string{}
+				for _, name := range names {
+					result = append(result, map[string]string{"name": name})
+				}
+				json.NewEncoder(w).Encode(result)
+			}))
+			defer server.Close()
+
+			// The copy is polled until the context ends, long before the readiness timeout
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			ctx = context.WithValue(ctx, config.TokenKey, "test-token")
+			ctx = context.WithValue(ctx, config.BaseURLKey, server.URL)
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			repo := &api.Repository{FullName: "lab/demo", DefaultBranch: "main"}
+			repoConfig := util.RepoConfig{Template: "octo/demo", IncludeAllBranches: tt.includeAllBranches}
+			_, err := waitForRepo(ctx, logger, &api.Organization{Login: "lab"}, repo, "demo", repoConfig)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
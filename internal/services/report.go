@@ -2,34 +2,152 @@ package services
 
 import (
 	"fmt"
+	"io"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
+
+	api "github.com/s-samadi/ghas-lab-builder/internal/github"
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
 )
 
 // LabReport represents the complete lab environment creation report
 type LabReport struct {
-	GeneratedAt         time.Time   `json:"generated_at"`
-	LabDate             string      `json:"lab_date"`
-	EnterpriseSlug      string      `json:"enterprise_slug"`
-	TotalUsers          int         `json:"total_users"`
-	SuccessCount        int         `json:"success_count"`
-	FailureCount        int         `json:"failure_count"`
-	Organizations       []OrgReport `json:"organizations"`
-	TemplateRepos       []string    `json:"template_repos"`
-	Facilitators        []string    `json:"facilitators,omitempty"`
-	InvalidUsers        []string    `json:"invalid_users,omitempty"`
-	InvalidFacilitators []string    `json:"invalid_facilitators,omitempty"`
+	GeneratedAt        time.Time     `json:"generated_at"`
+	StartedAt          time.Time     `json:"started_at"`
+	Duration           time.Duration `json:"duration"`
+	LabDate            string        `json:"lab_date"`
+	EnterpriseSlug     string        `json:"enterprise_slug"`
+	TotalUsers         int           `json:"total_users"`
+	SuccessCount       int           `json:"success_count"`
+	FailureCount       int           `json:"failure_count"`
+	Organizations      []OrgReport   `json:"organizations"`
+	TemplateRepos      []string      `json:"template_repos"`
+	TemplatePins       []TemplatePin `json:"template_pins,omitempty"`
+	Topology           string        `json:"topology,omitempty"`
+	Facilitators       []string      `json:"facilitators,omitempty"`
+	Observers          []string      `json:"observers,omitempty"`
+	FacilitatorRole    string        `json:"facilitator_role,omitempty"`
+	ObserverRole       string        `json:"observer_role,omitempty"`
+	FacilitatorOwnOrgs bool          `json:"facilitator_own_orgs"`
+	// SharedOrgRoles are the roles of the facilitators and observers in the organization of a single-org lab
+	SharedOrgRoles      []RoleAssignment  `json:"shared_org_roles,omitempty"`
+	InvalidUsers        []api.InvalidUser `json:"invalid_users,omitempty"`
+	InvalidFacilitators []api.InvalidUser `json:"invalid_facilitators,omitempty"`
+	Licenses            *LicenseReport    `json:"licenses,omitempty"`
+	StepMetrics         []StepMetric      `json:"step_metrics,omitempty"`
+}
+
+// TemplatePin records the commit the ref of a pinned template resolved to
+type TemplatePin struct {
+	Template string `json:"template"`
+	Ref      string `json:"ref"`
+	SHA      string `json:"sha"`
+}
+
+// templatePinLabel describes the pin of a template, if it has one
+func (r *LabReport) templatePinLabel(template string) string {
+	for _, pin := range r.TemplatePins {
+		if pin.Template == template {
+			return fmt.Sprintf(" @ `%s` (`%s`)", pin.Ref, pin.SHA)
+		}
+	}
+	return ""
 }
 
 // OrgReport represents the details of a single organization
 type OrgReport struct {
-	User         string       `json:"user"`
+	User string `json:"user"`
+	UserProfile
+	// Members are the users of a team organization
+	Members      []string     `json:"members,omitempty"`
 	OrgName      string       `json:"org_name"`
 	Status       string       `json:"status"`
 	Error        string       `json:"error,omitempty"`
 	Repositories []RepoReport `json:"repositories"`
-	CreatedAt    time.Time    `json:"created_at"`
+	// Roles are the roles of the facilitators and observers in the organization
+	Roles       []RoleAssignment `json:"roles,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	StartedAt   time.Time        `json:"started_at"`
+	CompletedAt time.Time        `json:"completed_at"`
+	Steps       []StepTiming     `json:"steps,omitempty"`
+}
+
+// UserProfile is the users file metadata of the person an organization belongs to
+type UserProfile struct {
+	DisplayName string            `json:"display_name,omitempty"`
+	Email       string            `json:"email,omitempty"`
+	Team        string            `json:"team,omitempty"`
+	Role        string            `json:"role,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+}
+
+func profileOf(user util.LabUser) UserProfile {
+	profile := UserProfile{
+		Email:      user.Email,
+		Team:       user.Team,
+		Role:       string(user.Role),
+		Attributes: user.Attributes,
+	}
+	if name := user.Name(); name != user.Login {
+		profile.DisplayName = name
+	}
+	return profile
+}
+
+// writeUserProfile writes the metadata of an organization's user as Markdown list items
+func writeUserProfile(w io.Writer, profile UserProfile) {
+	if profile.DisplayName != "" {
+		fmt.Fprintf(w, "- **Name:** %s\n", profile.DisplayName)
+	}
+	if profile.Role != "" && profile.Role != string(util.RoleStudent) {
+		fmt.Fprintf(w, "- **Role:** %s\n", profile.Role)
+	}
+	if profile.Team != "" {
+		fmt.Fprintf(w, "- **Team:** %s\n", profile.Team)
+	}
+	if profile.Email != "" {
+		fmt.Fprintf(w, "- **Email:** %s\n", profile.Email)
+	}
+	keys := slices.Sorted(maps.Keys(profile.Attributes))
+	for _, key := range keys {
+		fmt.Fprintf(w, "- **%s:** %s\n", key, profile.Attributes[key])
+	}
+}
+
+// ownerLabel names the user, or the team, an organization was provisioned for
+func ownerLabel(user string, team string) string {
+	if user == "" && team != "" {
+		return "team " + team
+	}
+	return "@" + user
+}
+
+// writeOwner writes the user an organization belongs to, or the members of a team organization
+func writeOwner(w io.Writer, user string, members []string) {
+	if user != "" {
+		fmt.Fprintf(w, "- **User:** @%s\n", user)
+	}
+	if len(members) > 0 {
+		fmt.Fprintf(w, "- **Members:** ")
+		for i, member := range members {
+			if i > 0 {
+				fmt.Fprintf(w, ", ")
+			}
+			fmt.Fprintf(w, "@%s", member)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+}
+
+// Duration returns the total time spent provisioning the organization
+func (o OrgReport) Duration() time.Duration {
+	if o.StartedAt.IsZero() || o.CompletedAt.IsZero() {
+		return 0
+	}
+	return o.CompletedAt.Sub(o.StartedAt)
 }
 
 // RepoReport represents the details of a repository
@@ -38,6 +156,20 @@ type RepoReport struct {
 	Status string `json:"status"`
 	Error  string `json:"error,omitempty"`
 	URL    string `json:"url,omitempty"`
+	// TemplateSHA is the template commit the repository was pinned to, and TreeSHA the tree of its default
+	// branch, which matches the tree of that commit
+	TemplateSHA string `json:"template_sha,omitempty"`
+	TreeSHA     string `json:"tree_sha,omitempty"`
+	// ImportedFrom names the bundle or tarball the repository was imported from, with TreeSHA the tree
+	// of its imported content
+	ImportedFrom string `json:"imported_from,omitempty"`
+	// ReadyAfter is how long the repository took to get the contents of its template after it was generated
+	ReadyAfter time.Duration `json:"ready_after,omitempty"`
+	// Seed counts the issues, labels, branches and pull requests seeded in the repository
+	Seed        *SeedReport   `json:"seed,omitempty"`
+	StartedAt   time.Time     `json:"started_at"`
+	CompletedAt time.Time     `json:"completed_at"`
+	Duration    time.Duration `json:"duration"`
 }
 
 // DeleteLabReport represents the complete lab environment deletion report
@@ -50,15 +182,68 @@ type DeleteLabReport struct {
 	FailureCount   int               `json:"failure_count"`
 	Organizations  []DeleteOrgReport `json:"organizations"`
 	Facilitators   []string          `json:"facilitators,omitempty"`
+	// DeprovisionFailureCount counts managed users that could not be deprovisioned in EMU mode
+	DeprovisionFailureCount int `json:"deprovision_failure_count,omitempty"`
+	// ManagedUsers records the deprovisioning of managed users without an organization of their own
+	ManagedUsers []ManagedUserReport `json:"managed_users,omitempty"`
+}
+
+// ManagedUserReport records the SCIM deprovisioning of one managed user
+type ManagedUserReport struct {
+	Login         string `json:"login"`
+	Deprovisioned bool   `json:"deprovisioned"`
+	Error         string `json:"error,omitempty"`
 }
 
 // DeleteOrgReport represents the deletion details of a single organization
 type DeleteOrgReport struct {
-	User      string    `json:"user"`
+	User string `json:"user"`
+	UserProfile
+	// Members are the users of a team or shared organization
+	Members   []string  `json:"members,omitempty"`
 	OrgName   string    `json:"org_name"`
 	Status    string    `json:"status"` // "success" or "failed"
 	Error     string    `json:"error,omitempty"`
 	DeletedAt time.Time `json:"deleted_at"`
+	// Deprovisioned and DeprovisionError record the SCIM deprovisioning of a managed user
+	Deprovisioned    bool   `json:"deprovisioned,omitempty"`
+	DeprovisionError string `json:"deprovision_error,omitempty"`
+}
+
+// redact scrubs secrets from every free-form field of the report
+func (r *LabReport) redact() {
+	for i := range r.Organizations {
+		org := &r.Organizations[i]
+		org.Error = util.Redact(org.Error)
+		for j := range org.Repositories {
+			org.Repositories[j].Error = util.Redact(org.Repositories[j].Error)
+			org.Repositories[j].URL = util.Redact(org.Repositories[j].URL)
+		}
+		for j := range org.Roles {
+			org.Roles[j].Error = util.Redact(org.Roles[j].Error)
+		}
+	}
+	for i := range r.SharedOrgRoles {
+		r.SharedOrgRoles[i].Error = util.Redact(r.SharedOrgRoles[i].Error)
+	}
+	// Lookup failures carry GitHub's raw GraphQL and HTTP responses
+	for i := range r.InvalidUsers {
+		r.InvalidUsers[i].Detail = util.Redact(r.InvalidUsers[i].Detail)
+	}
+	for i := range r.InvalidFacilitators {
+		r.InvalidFacilitators[i].Detail = util.Redact(r.InvalidFacilitators[i].Detail)
+	}
+}
+
+// redact scrubs secrets from every free-form field of the deletion report
+func (r *DeleteLabReport) redact() {
+	for i := range r.Organizations {
+		r.Organizations[i].Error = util.Redact(r.Organizations[i].Error)
+		r.Organizations[i].DeprovisionError = util.Redact(r.Organizations[i].DeprovisionError)
+	}
+	for i := range r.ManagedUsers {
+		r.ManagedUsers[i].Error = util.Redact(r.ManagedUsers[i].Error)
+	}
 }
 
 // GenerateReportFiles generates Markdown report and GitHub Actions summary
@@ -72,6 +257,9 @@ func GenerateReportFiles(report *LabReport, outputDir string) error {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
+	// Error messages come straight from GitHub responses; scrub them before they are written anywhere
+	report.redact()
+
 	timestamp := time.Now().Format("20060102-150405")
 	filename := fmt.Sprintf("lab-report-%s-%s.md", report.LabDate, timestamp)
 	mdPath := filepath.Join(outputDir, filename)
@@ -120,7 +308,11 @@ func generateGitHubStepSummary(report *LabReport) error {
 		emoji = "❌"
 	}
 
-	fmt.Fprintf(file, "> %s **Lab Date:** `%s` | **Enterprise:** `%s`\n\n", emoji, report.LabDate, report.EnterpriseSlug)
+	fmt.Fprintf(file, "> %s **Lab Date:** `%s` | **Enterprise:** `%s`", emoji, report.LabDate, report.EnterpriseSlug)
+	if report.Topology != "" {
+		fmt.Fprintf(file, " | **Topology:** `%s`", report.Topology)
+	}
+	fmt.Fprintf(file, "\n\n")
 
 	// Stats table
 	fmt.Fprintf(file, "## 📊 Summary\n\n")
@@ -132,31 +324,22 @@ func generateGitHubStepSummary(report *LabReport) error {
 		float64(report.FailureCount)/float64(report.TotalUsers)*100)
 	fmt.Fprintf(file, "\n")
 
+	// Step timings
+	if len(report.StepMetrics) > 0 {
+		fmt.Fprintf(file, "## ⏱️ Timing (total %s)\n\n", formatDuration(report.Duration))
+		writeStepMetricsTable(file, report.StepMetrics)
+		fmt.Fprintf(file, "\n")
+	}
+
 	// Invalid users warning
 	if len(report.InvalidUsers) > 0 || len(report.InvalidFacilitators) > 0 {
 		fmt.Fprintf(file, "## ⚠️ Invalid Users Skipped\n\n")
-		if len(report.InvalidUsers) > 0 {
-			fmt.Fprintf(file, "**Invalid Users (%d):** ", len(report.InvalidUsers))
-			for i, u := range report.InvalidUsers {
-				if i > 0 {
-					fmt.Fprintf(file, ", ")
-				}
-				fmt.Fprintf(file, "`@%s`", u)
-			}
-			fmt.Fprintf(file, "\n\n")
-		}
-		if len(report.InvalidFacilitators) > 0 {
-			fmt.Fprintf(file, "**Invalid Facilitators (%d):** ", len(report.InvalidFacilitators))
-			for i, f := range report.InvalidFacilitators {
-				if i > 0 {
-					fmt.Fprintf(file, ", ")
-				}
-				fmt.Fprintf(file, "`@%s`", f)
-			}
-			fmt.Fprintf(file, "\n\n")
-		}
+		writeInvalidUsers(file, "Invalid Users", report.InvalidUsers)
+		writeInvalidUsers(file, "Invalid Facilitators", report.InvalidFacilitators)
 	}
 
+	writeLicenses(file, report.Licenses)
+
 	// Facilitators
 	if len(report.Facilitators) > 0 {
 		fmt.Fprintf(file, "**👥 Facilitators:** ")
@@ -168,12 +351,19 @@ func generateGitHubStepSummary(report *LabReport) error {
 		}
 		fmt.Fprintf(file, "\n\n")
 	}
+	if report.FacilitatorRole != "" {
+		fmt.Fprintf(file, "**🔑 Roles:** facilitators %s", report.FacilitatorRole)
+		if len(report.Observers) > 0 {
+			fmt.Fprintf(file, ", observers %s", report.ObserverRole)
+		}
+		fmt.Fprintf(file, "\n\n")
+	}
 
 	// Template repos
 	fmt.Fprintf(file, "## 📦 Template Repositories (%d)\n\n", len(report.TemplateRepos))
 	fmt.Fprintf(file, "<details>\n<summary>Click to expand</summary>\n\n")
 	for _, repo := range report.TemplateRepos {
-		fmt.Fprintf(file, "- `%s`\n", repo)
+		fmt.Fprintf(file, "- `%s`%s\n", repo, report.templatePinLabel(repo))
 	}
 	fmt.Fprintf(file, "\n</details>\n\n")
 
@@ -181,8 +371,8 @@ func generateGitHubStepSummary(report *LabReport) error {
 	if report.SuccessCount > 0 {
 		fmt.Fprintf(file, "## ✅ Successfully Created Organizations (%d)\n\n", report.SuccessCount)
 		fmt.Fprintf(file, "<details>\n<summary>Click to expand</summary>\n\n")
-		fmt.Fprintf(file, "| Organization | User | Repos Created | Repos Failed |\n")
-		fmt.Fprintf(file, "|--------------|------|-------------:|--------------:|\n")
+		fmt.Fprintf(file, "| Organization | User | Repos Created | Repos Failed | Duration |\n")
+		fmt.Fprintf(file, "|--------------|------|-------------:|--------------:|---------:|\n")
 
 		for _, org := range report.Organizations {
 			if org.Status == "success" {
@@ -201,8 +391,8 @@ func generateGitHubStepSummary(report *LabReport) error {
 					emoji = "⚠️"
 				}
 
-				fmt.Fprintf(file, "| %s `%s` | `@%s` | %d | %d |\n",
-					emoji, org.OrgName, org.User, successRepos, failedRepos)
+				fmt.Fprintf(file, "| %s `%s` | `%s` | %d | %d | %s |\n",
+					emoji, org.OrgName, ownerLabel(org.User, org.Team), successRepos, failedRepos, formatDuration(org.Duration()))
 			}
 		}
 		fmt.Fprintf(file, "\n</details>\n\n")
@@ -221,7 +411,7 @@ func generateGitHubStepSummary(report *LabReport) error {
 				if len(errorMsg) > 80 {
 					errorMsg = errorMsg[:77] + "..."
 				}
-				fmt.Fprintf(file, "| `%s` | `@%s` | %s |\n", org.OrgName, org.User, errorMsg)
+				fmt.Fprintf(file, "| `%s` | `%s` | %s |\n", org.OrgName, ownerLabel(org.User, org.Team), errorMsg)
 			}
 		}
 		fmt.Fprintf(file, "\n")
@@ -233,11 +423,11 @@ func generateGitHubStepSummary(report *LabReport) error {
 
 	for _, org := range report.Organizations {
 		if org.Status == "success" && len(org.Repositories) > 0 {
-			fmt.Fprintf(file, "### `%s` (@%s)\n\n", org.OrgName, org.User)
+			fmt.Fprintf(file, "### `%s` (%s)\n\n", org.OrgName, ownerLabel(org.User, org.Team))
 
 			for _, repo := range org.Repositories {
 				if repo.Status == "success" {
-					fmt.Fprintf(file, "- ✅ [%s](%s)\n", repo.Name, repo.URL)
+					fmt.Fprintf(file, "- ✅ [%s](%s) (%s)\n", repo.Name, repo.URL, formatDuration(repo.Duration))
 				} else {
 					fmt.Fprintf(file, "- ❌ `%s` - %s\n", repo.Name, repo.Error)
 				}
@@ -269,6 +459,9 @@ func generateMarkdownReport(report *LabReport, filePath string) error {
 	fmt.Fprintf(file, "**Generated:** %s\n\n", report.GeneratedAt.Format("2006-01-02 15:04:05 MST"))
 	fmt.Fprintf(file, "**Lab Date:** %s\n\n", report.LabDate)
 	fmt.Fprintf(file, "**Enterprise:** %s\n\n", report.EnterpriseSlug)
+	if report.Topology != "" {
+		fmt.Fprintf(file, "**Topology:** %s\n\n", report.Topology)
+	}
 
 	if len(report.Facilitators) > 0 {
 		fmt.Fprintf(file, "**Facilitators:** ")
@@ -281,42 +474,62 @@ func generateMarkdownReport(report *LabReport, filePath string) error {
 		fmt.Fprintf(file, "\n\n")
 	}
 
-	// Write invalid users warning if any
-	if len(report.InvalidUsers) > 0 || len(report.InvalidFacilitators) > 0 {
-		fmt.Fprintf(file, "## ⚠️ Invalid Users Skipped\n\n")
-		if len(report.InvalidUsers) > 0 {
-			fmt.Fprintf(file, "**Invalid Users (%d):** ", len(report.InvalidUsers))
-			for i, u := range report.InvalidUsers {
-				if i > 0 {
-					fmt.Fprintf(file, ", ")
-				}
-				fmt.Fprintf(file, "@%s", u)
+	if len(report.Observers) > 0 {
+		fmt.Fprintf(file, "**Observers:** ")
+		for i, o := range report.Observers {
+			if i > 0 {
+				fmt.Fprintf(file, ", ")
 			}
-			fmt.Fprintf(file, "\n\n")
+			fmt.Fprintf(file, "@%s", o)
 		}
-		if len(report.InvalidFacilitators) > 0 {
-			fmt.Fprintf(file, "**Invalid Facilitators (%d):** ", len(report.InvalidFacilitators))
-			for i, f := range report.InvalidFacilitators {
-				if i > 0 {
-					fmt.Fprintf(file, ", ")
-				}
-				fmt.Fprintf(file, "@%s", f)
-			}
-			fmt.Fprintf(file, "\n\n")
+		fmt.Fprintf(file, "\n\n")
+	}
+
+	if report.FacilitatorRole != "" {
+		fmt.Fprintf(file, "**Facilitator Role:** %s", report.FacilitatorRole)
+		if !report.FacilitatorOwnOrgs {
+			fmt.Fprintf(file, " (no organizations of their own)")
 		}
+		fmt.Fprintf(file, "\n\n")
+	}
+	if report.ObserverRole != "" && len(report.Observers) > 0 {
+		fmt.Fprintf(file, "**Observer Role:** %s\n\n", report.ObserverRole)
 	}
 
+	if len(report.SharedOrgRoles) > 0 {
+		fmt.Fprintf(file, "## Shared Organization Roles\n\n")
+		writeRoles(file, report.SharedOrgRoles)
+		fmt.Fprintf(file, "\n")
+	}
+
+	// Write invalid users warning if any
+	if len(report.InvalidUsers) > 0 || len(report.InvalidFacilitators) > 0 {
+		fmt.Fprintf(file, "## ⚠️ Invalid Users Skipped\n\n")
+		writeInvalidUsers(file, "Invalid Users", report.InvalidUsers)
+		writeInvalidUsers(file, "Invalid Facilitators", report.InvalidFacilitators)
+	}
+
+	writeLicenses(file, report.Licenses)
+
 	// Write summary
 	fmt.Fprintf(file, "## Summary\n\n")
 	fmt.Fprintf(file, "- **Total Users:** %d\n", report.TotalUsers)
 	fmt.Fprintf(file, "- **Successful Organizations:** %d\n", report.SuccessCount)
 	fmt.Fprintf(file, "- **Failed Organizations:** %d\n", report.FailureCount)
-	fmt.Fprintf(file, "- **Success Rate:** %.1f%%\n\n", float64(report.SuccessCount)/float64(report.TotalUsers)*100)
+	fmt.Fprintf(file, "- **Success Rate:** %.1f%%\n", float64(report.SuccessCount)/float64(report.TotalUsers)*100)
+	fmt.Fprintf(file, "- **Total Duration:** %s\n\n", formatDuration(report.Duration))
+
+	// Write step timings
+	if len(report.StepMetrics) > 0 {
+		fmt.Fprintf(file, "## Timing\n\n")
+		writeStepMetricsTable(file, report.StepMetrics)
+		fmt.Fprintf(file, "\n")
+	}
 
 	// Write template repositories
 	fmt.Fprintf(file, "## Template Repositories\n\n")
 	for _, repo := range report.TemplateRepos {
-		fmt.Fprintf(file, "- `%s`\n", repo)
+		fmt.Fprintf(file, "- `%s`%s\n", repo, report.templatePinLabel(repo))
 	}
 	fmt.Fprintf(file, "\n")
 
@@ -326,8 +539,15 @@ func generateMarkdownReport(report *LabReport, filePath string) error {
 		for _, org := range report.Organizations {
 			if org.Status == "success" {
 				fmt.Fprintf(file, "### %s\n\n", org.OrgName)
-				fmt.Fprintf(file, "- **User:** @%s\n", org.User)
+				writeOwner(file, org.User, org.Members)
+				writeUserProfile(file, org.UserProfile)
 				fmt.Fprintf(file, "- **Created At:** %s\n", org.CreatedAt.Format("2006-01-02 15:04:05 MST"))
+				fmt.Fprintf(file, "- **Provisioning Time:** %s\n", formatDuration(org.Duration()))
+				for _, step := range org.Steps {
+					if step.Step == StepCreateOrg || step.Step == StepInstallApp {
+						fmt.Fprintf(file, "  - `%s`: %s\n", step.Step, formatDuration(step.Duration))
+					}
+				}
 
 				successRepos := 0
 				failedRepos := 0
@@ -340,13 +560,32 @@ func generateMarkdownReport(report *LabReport, filePath string) error {
 				}
 				fmt.Fprintf(file, "- **Repositories:** %d created, %d failed\n\n", successRepos, failedRepos)
 
+				if len(org.Roles) > 0 {
+					fmt.Fprintf(file, "#### Roles:\n\n")
+					writeRoles(file, org.Roles)
+					fmt.Fprintf(file, "\n")
+				}
+
 				if len(org.Repositories) > 0 {
 					fmt.Fprintf(file, "#### Repositories:\n\n")
 					for _, repo := range org.Repositories {
 						if repo.Status == "success" {
-							fmt.Fprintf(file, "- ✅ `%s` - [%s](%s)\n", repo.Name, repo.URL, repo.URL)
+							fmt.Fprintf(file, "- ✅ `%s` - [%s](%s) (%s)", repo.Name, repo.URL, repo.URL, formatDuration(repo.Duration))
+							switch {
+							case repo.ImportedFrom != "":
+								fmt.Fprintf(file, " - imported from `%s` (tree `%s`)", repo.ImportedFrom, repo.TreeSHA)
+							case repo.TreeSHA != "":
+								fmt.Fprintf(file, " - pinned to `%s` (tree `%s`)", repo.TemplateSHA, repo.TreeSHA)
+							}
+							if repo.ReadyAfter > 0 {
+								fmt.Fprintf(file, " - ready after %s", formatDuration(repo.ReadyAfter))
+							}
+							if repo.Seed != nil {
+								fmt.Fprintf(file, " - seeded %d new, %d existing", repo.Seed.Created, repo.Seed.Existing)
+							}
+							fmt.Fprintf(file, "\n")
 						} else {
-							fmt.Fprintf(file, "- ❌ `%s` - Error: %s\n", repo.Name, repo.Error)
+							fmt.Fprintf(file, "- ❌ `%s` - Error: %s (%s)\n", repo.Name, repo.Error, formatDuration(repo.Duration))
 						}
 					}
 					fmt.Fprintf(file, "\n")
@@ -361,7 +600,9 @@ func generateMarkdownReport(report *LabReport, filePath string) error {
 		for _, org := range report.Organizations {
 			if org.Status == "failed" {
 				fmt.Fprintf(file, "### %s\n\n", org.OrgName)
-				fmt.Fprintf(file, "- **User:** @%s\n", org.User)
+				writeOwner(file, org.User, org.Members)
+				writeUserProfile(file, org.UserProfile)
+				fmt.Fprintf(file, "- **Failed After:** %s\n", formatDuration(org.Duration()))
 				fmt.Fprintf(file, "- **Error:** %s\n\n", org.Error)
 			}
 		}
@@ -370,6 +611,29 @@ func generateMarkdownReport(report *LabReport, filePath string) error {
 	return nil
 }
 
+// writeRoles lists the roles of the facilitators and observers in an organization
+func writeRoles(w io.Writer, roles []RoleAssignment) {
+	for _, role := range roles {
+		if role.Error != "" {
+			fmt.Fprintf(w, "- ❌ @%s (%s): %s - Error: %s\n", role.Login, role.Kind, role.Role, role.Error)
+		} else {
+			fmt.Fprintf(w, "- @%s (%s): %s\n", role.Login, role.Kind, role.Role)
+		}
+	}
+}
+
+// writeStepMetricsTable writes per-step duration percentiles of the successful steps as a Markdown table
+func writeStepMetricsTable(w io.Writer, metrics []StepMetric) {
+	fmt.Fprintf(w, "| Step | Count | Failed | Min | p50 | p90 | p95 | Max | Total |\n")
+	fmt.Fprintf(w, "|------|------:|-------:|----:|----:|----:|----:|----:|------:|\n")
+	for _, m := range metrics {
+		fmt.Fprintf(w, "| `%s` | %d | %d | %s | %s | %s | %s | %s | %s |\n",
+			m.Step, m.Count, m.Failed,
+			formatDuration(m.Min), formatDuration(m.P50), formatDuration(m.P90),
+			formatDuration(m.P95), formatDuration(m.Max), formatDuration(m.Total))
+	}
+}
+
 // GenerateDeleteReportFiles generates Markdown report and GitHub Actions summary for deletions
 func GenerateDeleteReportFiles(report *DeleteLabReport, outputDir string) error {
 	if outputDir == "" {
@@ -381,6 +645,9 @@ func GenerateDeleteReportFiles(report *DeleteLabReport, outputDir string) error
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
+	// Error messages come straight from GitHub responses; scrub them before they are written anywhere
+	report.redact()
+
 	timestamp := time.Now().Format("20060102-150405")
 	filename := fmt.Sprintf("lab-delete-report-%s-%s.md", report.LabDate, timestamp)
 	mdPath := filepath.Join(outputDir, filename)
@@ -461,8 +728,8 @@ func generateDeleteGitHubStepSummary(report *DeleteLabReport) error {
 
 		for _, org := range report.Organizations {
 			if org.Status == "success" {
-				fmt.Fprintf(file, "| ✅ `%s` | `@%s` | %s |\n",
-					org.OrgName, org.User, org.DeletedAt.Format("2006-01-02 15:04:05 MST"))
+				fmt.Fprintf(file, "| ✅ `%s` | `%s` | %s |\n",
+					org.OrgName, ownerLabel(org.User, org.Team), org.DeletedAt.Format("2006-01-02 15:04:05 MST"))
 			}
 		}
 		fmt.Fprintf(file, "\n")
@@ -481,7 +748,7 @@ func generateDeleteGitHubStepSummary(report *DeleteLabReport) error {
 				if len(errorMsg) > 80 {
 					errorMsg = errorMsg[:77] + "..."
 				}
-				fmt.Fprintf(file, "| ❌ `%s` | `@%s` | %s |\n", org.OrgName, org.User, errorMsg)
+				fmt.Fprintf(file, "| ❌ `%s` | `%s` | %s |\n", org.OrgName, ownerLabel(org.User, org.Team), errorMsg)
 			}
 		}
 		fmt.Fprintf(file, "\n")
@@ -525,6 +792,9 @@ func generateDeleteMarkdownReport(report *DeleteLabReport, filePath string) erro
 	fmt.Fprintf(file, "- **Total Organizations:** %d\n", report.TotalUsers)
 	fmt.Fprintf(file, "- **Successfully Deleted:** %d\n", report.SuccessCount)
 	fmt.Fprintf(file, "- **Failed to Delete:** %d\n", report.FailureCount)
+	if report.DeprovisionFailureCount > 0 {
+		fmt.Fprintf(file, "- **Failed to Deprovision Managed Users:** %d\n", report.DeprovisionFailureCount)
+	}
 	fmt.Fprintf(file, "- **Success Rate:** %.1f%%\n\n", float64(report.SuccessCount)/float64(report.TotalUsers)*100)
 
 	// Write successfully deleted organizations
@@ -533,7 +803,9 @@ func generateDeleteMarkdownReport(report *DeleteLabReport, filePath string) erro
 		for _, org := range report.Organizations {
 			if org.Status == "success" {
 				fmt.Fprintf(file, "### %s\n\n", org.OrgName)
-				fmt.Fprintf(file, "- **User:** @%s\n", org.User)
+				writeOwner(file, org.User, org.Members)
+				writeUserProfile(file, org.UserProfile)
+				writeDeprovisionStatus(file, org)
 				fmt.Fprintf(file, "- **Deleted At:** %s\n\n", org.DeletedAt.Format("2006-01-02 15:04:05 MST"))
 			}
 		}
@@ -545,11 +817,80 @@ func generateDeleteMarkdownReport(report *DeleteLabReport, filePath string) erro
 		for _, org := range report.Organizations {
 			if org.Status == "failed" {
 				fmt.Fprintf(file, "### %s\n\n", org.OrgName)
-				fmt.Fprintf(file, "- **User:** @%s\n", org.User)
+				writeOwner(file, org.User, org.Members)
+				writeUserProfile(file, org.UserProfile)
+				writeDeprovisionStatus(file, org)
 				fmt.Fprintf(file, "- **Error:** %s\n\n", org.Error)
 			}
 		}
 	}
 
+	// Write managed users of team and shared organizations
+	if len(report.ManagedUsers) > 0 {
+		fmt.Fprintf(file, "## Managed Users\n\n")
+		for _, user := range report.ManagedUsers {
+			if user.Deprovisioned {
+				fmt.Fprintf(file, "- @%s: deprovisioned\n", user.Login)
+			} else {
+				fmt.Fprintf(file, "- @%s: %s\n", user.Login, user.Error)
+			}
+		}
+		fmt.Fprintf(file, "\n")
+	}
+
 	return nil
 }
+
+// writeDeprovisionStatus notes whether the managed user of a deleted organization was deprovisioned
+func writeDeprovisionStatus(w io.Writer, org DeleteOrgReport) {
+	switch {
+	case org.Deprovisioned:
+		fmt.Fprintf(w, "- **Managed User:** deprovisioned\n")
+	case org.DeprovisionError != "":
+		fmt.Fprintf(w, "- **Managed User:** %s\n", org.DeprovisionError)
+	}
+}
+
+// writeInvalidUsers lists skipped users with the reason each one was rejected
+func writeInvalidUsers(w io.Writer, title string, users []api.InvalidUser) {
+	if len(users) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "**%s (%d):**\n\n", title, len(users))
+	for _, u := range users {
+		fmt.Fprintf(w, "- `@%s`: %s", u.Login, u.Reason)
+		if u.Detail != "" {
+			fmt.Fprintf(w, " (%s)", u.Detail)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	fmt.Fprintf(w, "\n")
+}
+
+// writeLicenses summarizes the seats and GitHub Advanced Security committers the lab consumes
+func writeLicenses(w io.Writer, licenses *LicenseReport) {
+	if licenses == nil {
+		return
+	}
+	fmt.Fprintf(w, "## Licenses\n\n")
+	if licenses.Skipped != "" {
+		fmt.Fprintf(w, "License check skipped: %s\n\n", licenses.Skipped)
+		return
+	}
+	fmt.Fprintf(w, "- **New Seats:** %d (%d of %d in use)\n", licenses.NewSeats, licenses.SeatsConsumed, licenses.SeatsPurchased)
+	fmt.Fprintf(w, "- **New GHAS Committers:** %d (%d of %d in use)\n", licenses.NewCommitters, licenses.CommittersActive, licenses.CommittersPurchased)
+	if len(licenses.OutsideCollaborators) > 0 {
+		fmt.Fprintf(w, "- **Outside Collaborators Becoming Members:** ")
+		for i, login := range licenses.OutsideCollaborators {
+			if i > 0 {
+				fmt.Fprintf(w, ", ")
+			}
+			fmt.Fprintf(w, "`@%s`", login)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	if licenses.Overage != "" {
+		fmt.Fprintf(w, "\n⚠️ **Over the licensed limits:** %s\n", licenses.Overage)
+	}
+	fmt.Fprintf(w, "\n")
+}
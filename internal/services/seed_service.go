@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	api "github.com/s-samadi/ghas-lab-builder/internal/github"
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
+)
+
+// SeedReport counts the seeded items created in a repository and those that were already there
+type SeedReport struct {
+	Created  int `json:"created"`
+	Existing int `json:"existing"`
+}
+
+// seedRepository creates the labels, branches, issues and pull requests of a generated repository.
+// Items that already exist are left alone, so seeding a repository again only fills in what is missing;
+// an existing pull request is only given the seeded labels it lacks.
+func seedRepository(ctx context.Context, logger *slog.Logger, organization *api.Organization, repo *api.Repository, repoName string, seed *util.RepoSeed) (*SeedReport, error) {
+	report := &SeedReport{}
+	count := func(created bool) {
+		if created {
+			report.Created++
+		} else {
+			report.Existing++
+		}
+	}
+
+	defaultBranch := repo.DefaultBranch
+	if defaultBranch == "" {
+		defaultBranch = "main"
+	}
+
+	for _, label := range seed.Labels {
+		created, err := organization.CreateLabel(ctx, logger, repoName, api.Label{
+			Name:        label.Name,
+			Color:       label.Color,
+			Description: label.Description,
+		})
+		if err != nil {
+			return report, err
+		}
+		count(created)
+	}
+
+	for _, branch := range seed.Branches {
+		_, err := organization.GetBranchSHA(ctx, logger, repoName, branch.Name)
+		if err == nil {
+			count(false)
+			continue
+		}
+		if !errors.Is(err, api.ErrNotFound) {
+			return report, err
+		}
+		from := branch.From
+		if from == "" {
+			from = defaultBranch
+		}
+		sha, err := organization.GetBranchSHA(ctx, logger, repoName, from)
+		if err != nil {
+			return report, fmt.Errorf("failed to find the base of branch %s: %w", branch.Name, err)
+		}
+		if err := organization.CreateBranch(ctx, logger, repoName, branch.Name, sha); err != nil {
+			return report, err
+		}
+		count(true)
+	}
+
+	if len(seed.Issues) > 0 {
+		issues, err := organization.ListIssues(ctx, logger, repoName)
+		if err != nil {
+			return report, err
+		}
+		titles := map[string]bool{}
+		for _, issue := range issues {
+			if issue.PullRequest == nil {
+				titles[issue.Title] = true
+			}
+		}
+		for _, issue := range seed.Issues {
+			if titles[issue.Title] {
+				count(false)
+				continue
+			}
+			if _, err := organization.CreateIssue(ctx, logger, repoName, issue.Title, issue.Body, issue.Labels); err != nil {
+				return report, err
+			}
+			count(true)
+		}
+	}
+
+	for _, pull := range seed.PullRequests {
+		base := pull.Base
+		if base == "" {
+			base = defaultBranch
+		}
+		existing, err := organization.ListPullRequests(ctx, logger, repoName, pull.Head)
+		if err != nil {
+			return report, err
+		}
+		if pr := findPullInto(existing, base); pr != nil {
+			if missing := missingLabels(pr.Labels, pull.Labels); len(missing) > 0 {
+				if err := organization.AddIssueLabels(ctx, logger, repoName, pr.Number, missing); err != nil {
+					return report, err
+				}
+			}
+			count(false)
+			continue
+		}
+		created, err := organization.CreatePullRequest(ctx, logger, repoName, pull.Title, pull.Body, pull.Head, base, pull.Draft)
+		if err != nil {
+			return report, err
+		}
+		if len(pull.Labels) > 0 {
+			if err := organization.AddIssueLabels(ctx, logger, repoName, created.Number, pull.Labels); err != nil {
+				return report, err
+			}
+		}
+		count(true)
+	}
+
+	logger.Info("Seeded repository",
+		slog.String("repo", repoName),
+		slog.Int("created", report.Created),
+		slog.Int("existing", report.Existing))
+
+	return report, nil
+}
+
+// findPullInto returns the pull request that targets base, if any
+func findPullInto(pulls []api.PullRequest, base string) *api.PullRequest {
+	for i := range pulls {
+		if pulls[i].Base.Ref == base {
+			return &pulls[i]
+		}
+	}
+	return nil
+}
+
+// missingLabels returns the labels of want that are not among have
+func missingLabels(have []api.Label, want []string) []string {
+	var missing []string
+	for _, name := range want {
+		if !slices.ContainsFunc(have, func(label api.Label) bool { return strings.EqualFold(label.Name, name) }) {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
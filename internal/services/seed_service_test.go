@@ -0,0 +1,188 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+	api "github.com/s-samadi/ghas-lab-builder/internal/github"
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
+)
+
+// seedServer is a stand-in for the GitHub API of one repository, lab/demo, that keeps what is seeded
+type seedServer struct {
+	mu       sync.Mutex
+	labels   map[string]bool
+	branches map[string]string
+	issues   []api.Issue
+	pulls    []api.PullRequest
+}
+
+// newSeedServer starts a seedServer with a main branch
+func newSeedServer(t *testing.T) (*httptest.Server, *seedServer) {
+	s := &seedServer{labels: map[string]bool{}, branches: map[string]string{"main": "sha-main"}}
+	decode := func(r *http.Request, v any) {
+		if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+			t.Errorf("decoding %s %s: %v", r.Method, r.URL.Path, err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /repos/lab/demo/labels", func(w http.ResponseWriter, r *http.Request) {
+		var label api.Label
+		decode(r, &label)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.labels[label.Name] {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			io.WriteString(w, `{"errors":[{"code":"already_exists"}]}`)
+			return
+		}
+		s.labels[label.Name] = true
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{}`)
+	})
+	mux.HandleFunc("GET /repos/lab/demo/git/ref/heads/{branch...}", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		sha, ok := s.branches[r.PathValue("branch")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, `{"object":{"sha":"`+sha+`"}}`)
+	})
+	mux.HandleFunc("POST /repos/lab/demo/git/refs", func(w http.ResponseWriter, r *http.Request) {
+		var ref struct{ Ref, SHA string }
+		decode(r, &ref)
+		s.mu.Lock()
+		s.branches[strings.TrimPrefix(ref.Ref, "refs/heads/")] = ref.SHA
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{}`)
+	})
+	mux.HandleFunc("GET /repos/lab/demo/issues", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		json.NewEncoder(w).Encode(s.issues)
+	})
+	mux.HandleFunc("POST /repos/lab/demo/issues", func(w http.ResponseWriter, r *http.Request) {
+		var issue api.Issue
+		decode(r, &issue)
+		s.mu.Lock()
+		issue.Number = len(s.issues) + 1
+		s.issues = append(s.issues, issue)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(issue)
+	})
+	mux.HandleFunc("GET /repos/lab/demo/pulls", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		pulls := []api.PullRequest{}
+		for _, pull := range s.pulls {
+			if r.URL.Query().Get("head") == "lab:"+pull.Head.Ref {
+				pulls = append(pulls, pull)
+			}
+		}
+		json.NewEncoder(w).Encode(pulls)
+	})
+	mux.HandleFunc("POST /repos/lab/demo/pulls", func(w http.ResponseWriter, r *http.Request) {
+		var request struct{ Title, Head, Base string }
+		decode(r, &request)
+		s.mu.Lock()
+		var pull api.PullRequest
+		pull.Number = len(s.issues) + 1
+		pull.Title = request.Title
+		pull.Head.Ref = request.Head
+		pull.Base.Ref = request.Base
+		s.pulls = append(s.pulls, pull)
+		s.issues = append(s.issues, api.Issue{Number: pull.Number, Title: pull.Title, PullRequest: &struct{}{}})
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(pull)
+	})
+	mux.HandleFunc("POST /repos/lab/demo/issues/{number}/labels", func(w http.ResponseWriter, r *http.Request) {
+		var request struct{ Labels []string }
+		decode(r, &request)
+		number, _ := strconv.Atoi(r.PathValue("number"))
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i := range s.pulls {
+			if s.pulls[i].Number == number {
+				for _, name := range request.Labels {
+					s.pulls[i].Labels = append(s.pulls[i].Labels, api.Label{Name: name})
+				}
+			}
+		}
+		io.WriteString(w, `[]`)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, s
+}
+
+func TestSeedRepositoryTwice(t *testing.T) {
+	server, s := newSeedServer(t)
+
+	ctx := context.WithValue(context.Background(), config.TokenKey, "test-token")
+	ctx = context.WithValue(ctx, config.BaseURLKey, server.URL)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	seed := &util.RepoSeed{
+		Labels:       []util.SeedLabel{{Name: "bug", Color: "d73a4a"}, {Name: "lab"}},
+		Branches:     []util.SeedBranch{{Name: "feature/fix"}},
+		Issues:       []util.SeedIssue{{Title: "Triage the alerts", Labels: []string{"lab"}}},
+		PullRequests: []util.SeedPullRequest{{Title: "Fix the bug", Head: "feature/fix", Labels: []string{"bug", "lab"}}},
+	}
+	organization := &api.Organization{Login: "lab"}
+	repo := &api.Repository{FullName: "lab/demo", DefaultBranch: "main"}
+
+	first, err := seedRepository(ctx, logger, organization, repo, "demo", seed)
+	if err != nil {
+		t.Fatalf("first seed: %v", err)
+	}
+	if first.Created != 5 || first.Existing != 0 {
+		t.Errorf("first seed created %d and found %d, want 5 and 0", first.Created, first.Existing)
+	}
+
+	// A label taken off the pull request between the runs is put back
+	s.mu.Lock()
+	s.pulls[0].Labels = s.pulls[0].Labels[:1]
+	s.mu.Unlock()
+
+	second, err := seedRepository(ctx, logger, organization, repo, "demo", seed)
+	if err != nil {
+		t.Fatalf("second seed: %v", err)
+	}
+	if second.Created != 0 || second.Existing != 5 {
+		t.Errorf("second seed created %d and found %d, want 0 and 5", second.Created, second.Existing)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.issues) != 2 || len(s.pulls) != 1 || len(s.branches) != 2 {
+		t.Errorf("repository has %d issues, %d pull requests and %d branches, want 2, 1 and 2", len(s.issues), len(s.pulls), len(s.branches))
+	}
+	var labels []string
+	for _, label := range s.pulls[0].Labels {
+		labels = append(labels, label.Name)
+	}
+	if !slices.Equal(labels, []string{"bug", "lab"}) {
+		t.Errorf("pull request labels = %v, want [bug lab]", labels)
+	}
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+	api "github.com/s-samadi/ghas-lab-builder/internal/github"
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
+)
+
+// RoleAssignment records the role a facilitator or observer was given in a lab organization
+type RoleAssignment struct {
+	Login string `json:"login"`
+	// Kind is the lab role of the user: facilitator or observer
+	Kind  util.UserRole `json:"kind"`
+	Role  util.OrgRole  `json:"role"`
+	Error string        `json:"error,omitempty"`
+}
+
+// labOwners returns the facilitators that own the organizations no user owns: the team and shared
+// organizations, and those of students outside an EMU enterprise. With another facilitator role the first facilitator stays their owner, as
+// GitHub does not let an organization lose its last owner.
+func labOwners(ctx context.Context, definition *util.LabDefinition) []string {
+	facilitators, _ := ctx.Value(config.FacilitatorsKey).([]string)
+	if definition.Facilitators.Role != util.OrgRoleOwner && len(facilitators) > 1 {
+		return facilitators[:1]
+	}
+	return facilitators
+}
+
+// orgOwners returns the users that keep owning an organization once the facilitators have their role.
+// Only a facilitator and, in an EMU enterprise, a managed student are made owners of their own
+// organization as it is created; any other organization keeps the owners from labOwners.
+func orgOwners(ctx context.Context, definition *util.LabDefinition, orgUser string) []string {
+	facilitators, _ := ctx.Value(config.FacilitatorsKey).([]string)
+	shortcode, _ := ctx.Value(config.EMUShortcodeKey).(string)
+	if orgUser != "" && (shortcode != "" || slices.Contains(facilitators, orgUser)) {
+		return []string{orgUser}
+	}
+	return labOwners(ctx, definition)
+}
+
+// assignStaffRoles gives the facilitators and observers their role in an organization. Facilitators own
+// every organization as it is created, so those with another role are made members first; the owners
+// from orgOwners keep owning the organization. A failed assignment is recorded without stopping the lab.
+func assignStaffRoles(ctx context.Context, logger *slog.Logger, organization *api.Organization, definition *util.LabDefinition, orgUser string) []RoleAssignment {
+	facilitators, _ := ctx.Value(config.FacilitatorsKey).([]string)
+	observers, _ := ctx.Value(config.ObserversKey).([]string)
+
+	owners := orgOwners(ctx, definition, orgUser)
+
+	var assignments []RoleAssignment
+	for _, login := range facilitators {
+		role := definition.Facilitators.Role
+		if slices.Contains(owners, login) {
+			role = util.OrgRoleOwner
+		}
+		assignments = append(assignments, assignOrgRole(ctx, logger, organization, definition, login, util.RoleFacilitator, role))
+	}
+	if definition.Observers.Role != util.OrgRoleNone {
+		for _, login := range observers {
+			// A facilitator also listed as an observer keeps the facilitator role
+			if slices.Contains(facilitators, login) {
+				continue
+			}
+			assignments = append(assignments, assignOrgRole(ctx, logger, organization, definition, login, util.RoleObserver, definition.Observers.Role))
+		}
+	}
+	return assignments
+}
+
+// assignOrgRole gives one facilitator or observer a role in an organization
+func assignOrgRole(ctx context.Context, logger *slog.Logger, organization *api.Organization, definition *util.LabDefinition, login string, kind util.UserRole, role util.OrgRole) RoleAssignment {
+	assignment := RoleAssignment{Login: login, Kind: kind, Role: role}
+
+	var err error
+	switch role {
+	case util.OrgRoleOwner:
+		// Facilitators were made owners when the organization was created
+		if kind != util.RoleFacilitator {
+			err = organization.AddMember(ctx, logger, login, "admin")
+		}
+	case util.OrgRoleSecurityManager, util.OrgRoleReadOnly:
+		roleName := "security_manager"
+		if role == util.OrgRoleReadOnly {
+			roleName = definition.ReadOnlyRole
+		}
+		err = organization.AddMember(ctx, logger, login, "member")
+		if err == nil {
+			err = organization.AssignOrganizationRole(ctx, logger, login, roleName)
+		}
+	}
+	if err != nil {
+		logger.Error("Failed to assign organization role",
+			slog.String("org", organization.Login),
+			slog.String("user", login),
+			slog.String("role", string(role)),
+			slog.Any("error", err))
+		assignment.Error = fmt.Sprintf("%v", err)
+	}
+	return assignment
+}
+
+// assignmentError returns the first assignment that could not be made as an error
+func assignmentError(assignments []RoleAssignment) error {
+	for _, assignment := range assignments {
+		if assignment.Error != "" {
+			return fmt.Errorf("failed to assign %s the %s role: %s", assignment.Login, assignment.Role, assignment.Error)
+		}
+	}
+	return nil
+}
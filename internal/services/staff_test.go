@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+	api "github.com/s-samadi/ghas-lab-builder/internal/github"
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
+)
+
+func TestLabOwners(t *testing.T) {
+	tests := []struct {
+		name         string
+		role         util.OrgRole
+		facilitators []string
+		want         []string
+	}{
+		{"owners", util.OrgRoleOwner, []string{"frank", "grace"}, []string{"frank", "grace"}},
+		{"security managers keep the first facilitator as owner", util.OrgRoleSecurityManager, []string{"frank", "grace"}, []string{"frank"}},
+		{"read only keeps the first facilitator as owner", util.OrgRoleReadOnly, []string{"frank", "grace"}, []string{"frank"}},
+		{"a single facilitator stays owner", util.OrgRoleReadOnly, []string{"frank"}, []string{"frank"}},
+		{"no facilitators", util.OrgRoleSecurityManager, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			definition := util.DefaultLabDefinition()
+			definition.Facilitators.Role = tt.role
+			ctx := context.WithValue(context.Background(), config.FacilitatorsKey, tt.facilitators)
+
+			if got := labOwners(ctx, definition); !slices.Equal(got, tt.want) {
+				t.Errorf("labOwners = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssignStaffRoles(t *testing.T) {
+	tests := []struct {
+		name      string
+		role      util.OrgRole
+		shortcode string
+		orgUser   string
+		want      map[string]util.OrgRole
+		members   []string
+	}{
+		{"student outside EMU", util.OrgRoleSecurityManager, "", "alice",
+			map[string]util.OrgRole{"frank": util.OrgRoleOwner, "grace": util.OrgRoleSecurityManager, "carol": util.OrgRoleReadOnly},
+			[]string{"carol", "grace"}},
+		{"EMU student", util.OrgRoleSecurityManager, "octo", "alice",
+			map[string]util.OrgRole{"frank": util.OrgRoleSecurityManager, "grace": util.OrgRoleSecurityManager, "carol": util.OrgRoleReadOnly},
+			[]string{"carol", "frank", "grace"}},
+		{"facilitator's own organization", util.OrgRoleReadOnly, "", "grace",
+			map[string]util.OrgRole{"frank": util.OrgRoleReadOnly, "grace": util.OrgRoleOwner, "carol": util.OrgRoleReadOnly},
+			[]string{"carol", "frank"}},
+		{"shared organization", util.OrgRoleReadOnly, "octo", "",
+			map[string]util.OrgRole{"frank": util.OrgRoleOwner, "grace": util.OrgRoleReadOnly, "carol": util.OrgRoleReadOnly},
+			[]string{"carol", "grace"}},
+		{"facilitators as owners", util.OrgRoleOwner, "", "alice",
+			map[string]util.OrgRole{"frank": util.OrgRoleOwner, "grace": util.OrgRoleOwner, "carol": util.OrgRoleReadOnly},
+			[]string{"carol"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var members []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/orgs/lab/memberships/"):
+					mu.Lock()
+					members = append(members, strings.TrimPrefix(r.URL.Path, "/orgs/lab/memberships/"))
+					mu.Unlock()
+					w.Write([]byte(`{"state":"active"}`))
+				case r.Method == http.MethodGet && r.URL.Path == "/orgs/lab/organization-roles":
+					w.Write([]byte(`{"roles":[{"id":1,"name":"security_manager"},{"id":2,"name":"all_repo_read"}]}`))
+				case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/orgs/lab/organization-roles/users/"):
+					w.WriteHeader(http.StatusNoContent)
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			ctx := context.WithValue(context.Background(), config.TokenKey, "test-token")
+			ctx = context.WithValue(ctx, config.BaseURLKey, server.URL)
+			ctx = context.WithValue(ctx, config.FacilitatorsKey, []string{"frank", "grace"})
+			ctx = context.WithValue(ctx, config.ObserversKey, []string{"carol", "grace"})
+			ctx = context.WithValue(ctx, config.EMUShortcodeKey, tt.shortcode)
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			definition := util.DefaultLabDefinition()
+			definition.Facilitators.Role = tt.role
+			definition.Observers.Role = util.OrgRoleReadOnly
+			definition.ReadOnlyRole = util.DefaultReadOnlyRole
+
+			assignments := assignStaffRoles(ctx, logger, &api.Organization{Login: "lab"}, definition, tt.orgUser)
+			if err := assignmentError(assignments); err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]util.OrgRole{}
+			for _, assignment := range assignments {
+				got[assignment.Login] = assignment.Role
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("roles = %v, want %v", got, tt.want)
+			}
+			slices.Sort(members)
+			if !slices.Equal(members, tt.members) {
+				t.Errorf("members added = %v, want %v", members, tt.members)
+			}
+		})
+	}
+}
@@ -0,0 +1,256 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/config"
+	api "github.com/s-samadi/ghas-lab-builder/internal/github"
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
+)
+
+// TemplateSyncOptions describe where templates are copied from and to
+type TemplateSyncOptions struct {
+	// TemplateReposFile lists the templates to copy; OutputFile is the same file with the copies as templates
+	TemplateReposFile string
+	OutputFile        string
+	// Org is the organization of the target enterprise the templates are copied into
+	Org string
+	// SourceURL is the web root templates are cloned from, e.g. https://github.com, and SourceToken
+	// the token for private templates
+	SourceURL   string
+	SourceToken string
+	// Visibility of copies created by the sync
+	Visibility string
+	// Overwrite lets the sync push to repositories it did not create
+	Overwrite bool
+}
+
+// SyncTemplates copies every template of a repos file, with all its branches and tags, into an organization
+// of the target enterprise, marks the copies as templates, and writes the repos file with the copies as the
+// lab's templates. Copies that exist are updated, so templates can be synced again when they change.
+func SyncTemplates(ctx context.Context, logger *slog.Logger, opts TemplateSyncOptions) error {
+	if _, err := exec.LookPath("git"); err != nil {
+		return fmt.Errorf("templates are copied with git, which was not found: %w", err)
+	}
+
+	definition, err := util.LoadLabDefinition(opts.TemplateReposFile)
+	if err != nil {
+		logger.Error("Failed to load template repositories",
+			slog.String("file", opts.TemplateReposFile),
+			slog.Any("error", err))
+		return fmt.Errorf("failed to load template repositories: %w", err)
+	}
+
+	// Each template is copied once, under its own name
+	copies := map[string]string{}
+	var templates []string
+	for _, repo := range definition.Repos {
+		if repo.IsImported() {
+			continue
+		}
+		if _, ok := copies[repo.Template]; ok {
+			continue
+		}
+		_, name, _ := strings.Cut(repo.Template, "/")
+		target := opts.Org + "/" + name
+		for template, mirror := range copies {
+			if mirror == target {
+				return fmt.Errorf("templates %s and %s would both be copied to %s", template, repo.Template, target)
+			}
+		}
+		copies[repo.Template] = target
+		templates = append(templates, repo.Template)
+	}
+
+	ctx = context.WithValue(ctx, config.OrgKey, opts.Org)
+	organization, err := api.GetOrganization(ctx, logger, opts.Org)
+	if err != nil {
+		logger.Error("Failed to get organization",
+			slog.String("org", opts.Org),
+			slog.Any("error", err))
+		return fmt.Errorf("failed to get organization %s: %w", opts.Org, err)
+	}
+
+	failed := 0
+	for _, template := range templates {
+		if err := syncTemplate(ctx, logger, organization, template, copies[template], opts); err != nil {
+			logger.Error("Failed to sync template",
+				slog.String("template", template),
+				slog.String("copy", copies[template]),
+				slog.Any("error", err))
+			failed++
+			continue
+		}
+		logger.Info("Synced template",
+			slog.String("template", template),
+			slog.String("copy", copies[template]))
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to sync %d of %d templates", failed, len(templates))
+	}
+
+	if err := rewriteTemplateRefs(opts.TemplateReposFile, opts.OutputFile, copies); err != nil {
+		return fmt.Errorf("failed to write %s: %w", opts.OutputFile, err)
+	}
+
+	logger.Info("Wrote template repositories file with the copied templates",
+		slog.String("file", opts.OutputFile),
+		slog.Int("templates", len(templates)))
+
+	return nil
+}
+
+// copyDescription is the description of the copies the sync creates, which marks them as its own
+func copyDescription(template string) string {
+	return fmt.Sprintf("Copy of template %s", template)
+}
+
+// isTemplateCopy reports whether a repository is a copy of the template made by an earlier sync
+func isTemplateCopy(repo *api.Repository, template string) bool {
+	return repo.IsTemplate && repo.Description == copyDescription(template)
+}
+
+// syncTemplate clones a template and pushes its branches and tags to its copy, creating the copy first
+// if needed. The push prunes branches and tags the template no longer has, so a repository the sync did
+// not create is only pushed to with Overwrite.
+func syncTemplate(ctx context.Context, logger *slog.Logger, organization *api.Organization, template string, mirror string, opts TemplateSyncOptions) error {
+	_, name, _ := strings.Cut(mirror, "/")
+
+	existing, err := api.GetRepository(ctx, logger, mirror)
+	switch {
+	case errors.Is(err, api.ErrNotFound):
+		// Pushing needs an empty repository, without the initial commit of auto_init
+		_, err = organization.CreateRepository(ctx, logger, api.RepoOptions{
+			Name:        name,
+			Description: copyDescription(template),
+			Visibility:  opts.Visibility,
+		})
+		if err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case !isTemplateCopy(existing, template) && !opts.Overwrite:
+		return fmt.Errorf("%s exists but is not a copy of %s made by templates sync; use --overwrite to replace its branches and tags", mirror, template)
+	}
+
+	dir, err := os.MkdirTemp("", "ghas-lab-template-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	sourceURL := fmt.Sprintf("%s/%s.git", strings.TrimSuffix(opts.SourceURL, "/"), template)
+	if _, err := runGit(ctx, logger, "", opts.SourceToken, "clone", "--bare", "--quiet", sourceURL, dir); err != nil {
+		return err
+	}
+	defaultBranch, err := runGit(ctx, logger, dir, "", "symbolic-ref", "--short", "HEAD")
+	if err != nil {
+		return err
+	}
+
+	host, ok := ctx.Value(config.HostKey).(*config.Host)
+	if !ok || host == nil {
+		return fmt.Errorf("target host not found in context")
+	}
+	token, err := api.AccessToken(ctx, config.OrganizationType, organization.Login)
+	if err != nil {
+		return err
+	}
+	targetURL := fmt.Sprintf("%s/%s.git", host.WebURL(), mirror)
+	if _, err := runGit(ctx, logger, dir, token, "push", "--quiet", "--force", "--prune", targetURL,
+		"refs/heads/*:refs/heads/*", "refs/tags/*:refs/tags/*"); err != nil {
+		if isWorkflowRejection(err) {
+			return fmt.Errorf("the template has GitHub Actions workflows, which need the workflow scope for tokens or the workflows permission for apps: %w", err)
+		}
+		return err
+	}
+
+	// An overwritten repository is marked as a copy, so later syncs update it without --overwrite
+	_, err = organization.UpdateRepository(ctx, logger, name, map[string]any{
+		"is_template":    true,
+		"default_branch": defaultBranch,
+		"description":    copyDescription(template),
+	})
+	return err
+}
+
+// isWorkflowRejection reports whether GitHub refused a push because it creates or updates workflow files
+// and the credentials lack the workflow scope or workflows permission
+func isWorkflowRejection(err error) bool {
+	message := err.Error()
+	return strings.Contains(message, "refusing to allow") && strings.Contains(message, "workflow")
+}
+
+// runGit runs git and returns its trimmed output. A token is sent as an HTTP header through the
+// environment, so it shows up in neither the command line nor the remote URLs git prints.
+func runGit(ctx context.Context, logger *slog.Logger, dir string, token string, args ...string) (string, error) {
+	logger.Info("Running git", slog.String("command", args[0]), slog.String("dir", dir))
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if token != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + token))
+		cmd.Env = append(cmd.Env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0=Authorization: Basic "+credentials)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		logger.Error("git failed",
+			slog.String("command", args[0]),
+			slog.String("output", stderr.String()),
+			slog.Any("error", err))
+		return "", fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// rewriteTemplateRefs writes a repos file with its templates replaced by their copies, keeping every
+// other setting as written
+func rewriteTemplateRefs(path string, output string, copies map[string]string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file map[string]any
+	if err := json.Unmarshal(data, &file); err != nil {
+		return err
+	}
+
+	setup, _ := file["lab-env-setup"].(map[string]any)
+	repos, _ := setup["repos"].([]any)
+	for i, repo := range repos {
+		switch repo := repo.(type) {
+		case string:
+			if mirror, ok := copies[repo]; ok {
+				repos[i] = mirror
+			}
+		case map[string]any:
+			template, _ := repo["template"].(string)
+			if mirror, ok := copies[template]; ok {
+				repo["template"] = mirror
+			}
+		}
+	}
+
+	data, err = json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output, append(data, '\n'), 0o644)
+}
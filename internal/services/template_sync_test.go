@@ -0,0 +1,48 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	api "github.com/s-samadi/ghas-lab-builder/internal/github"
+)
+
+func TestIsTemplateCopy(t *testing.T) {
+	tests := []struct {
+		name string
+		repo api.Repository
+		want bool
+	}{
+		{"copy made by the sync", api.Repository{IsTemplate: true, Description: "Copy of template octo/demo"}, true},
+		{"copy of another template", api.Repository{IsTemplate: true, Description: "Copy of template octo/other"}, false},
+		{"template of its own", api.Repository{IsTemplate: true, Description: "Demo application"}, false},
+		{"repository that is not a template", api.Repository{Description: "Copy of template octo/demo"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTemplateCopy(&tt.repo, "octo/demo"); got != tt.want {
+				t.Errorf("isTemplateCopy = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsWorkflowRejection(t *testing.T) {
+	tests := []struct {
+		name   string
+		stderr string
+		want   bool
+	}{
+		{"token without workflow scope", " ! [remote rejected] main -> main (refusing to allow a Personal Access Token to create or update workflow `.github/workflows/ci.yml` without `workflow` scope)", true},
+		{"app without workflows permission", " ! [remote rejected] main -> main (refusing to allow a GitHub App to create or update workflow `.github/workflows/ci.yml` without `workflows` permission)", true},
+		{"other rejection", " ! [remote rejected] main -> main (protected branch hook declined)", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := errors.New("git push failed: exit status 1: " + tt.stderr)
+			if got := isWorkflowRejection(err); got != tt.want {
+				t.Errorf("isWorkflowRejection = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
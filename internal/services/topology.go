@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	api "github.com/s-samadi/ghas-lab-builder/internal/github"
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
+)
+
+// labOrgPlan is one unit of lab provisioning: an organization, or in a single-org lab one user's
+// share of the shared organization
+type labOrgPlan struct {
+	// Key tracks the unit in the progress view: a user login or a team name
+	Key     string
+	OrgName string
+	// User is the user of an org-per-user or single-org unit
+	User util.LabUser
+	// Team and Members are set for the organization of a team
+	Team    string
+	Members []util.LabUser
+}
+
+// label names the owner of the unit in logs and errors, or the organization when it is shared
+func (p labOrgPlan) label() string {
+	if p.Team != "" {
+		return "team " + p.Team
+	}
+	if p.User.Login == "" {
+		return p.OrgName
+	}
+	return p.User.Login
+}
+
+// planLabOrgs spreads the students and facilitators across organizations according to the topology.
+// In an org-per-team lab every student needs a team; facilitators join the organization of their
+// team, if they have one, and otherwise only own every organization.
+func planLabOrgs(labDate string, topology util.Topology, students []util.LabUser, facilitators []util.LabUser) ([]labOrgPlan, error) {
+	users := slices.Concat(students, facilitators)
+
+	switch topology {
+	case util.TopologyOrgPerTeam:
+		var missing []string
+		var plans []labOrgPlan
+		index := map[string]int{}
+		for _, user := range users {
+			team := strings.TrimSpace(user.Team)
+			if team == "" {
+				if user.Role != util.RoleFacilitator {
+					missing = append(missing, user.Login)
+				}
+				continue
+			}
+			slug := api.TeamSlug(team)
+			i, ok := index[slug]
+			if !ok {
+				i = len(plans)
+				index[slug] = i
+				plans = append(plans, labOrgPlan{
+					Key:     team,
+					OrgName: api.LabTeamOrgName(labDate, team),
+					Team:    team,
+				})
+			}
+			plans[i].Members = append(plans[i].Members, user)
+		}
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("the %s topology needs a team for every user; missing for %s", topology, strings.Join(missing, ", "))
+		}
+		return plans, nil
+
+	case util.TopologySingleOrg:
+		plans := make([]labOrgPlan, len(users))
+		for i, user := range users {
+			plans[i] = labOrgPlan{Key: user.Login, OrgName: api.LabSharedOrgName(labDate), User: user}
+		}
+		return plans, nil
+
+	default:
+		plans := make([]labOrgPlan, len(users))
+		for i, user := range users {
+			plans[i] = labOrgPlan{Key: user.Login, OrgName: api.LabOrgName(labDate, user.Login), User: user}
+		}
+		return plans, nil
+	}
+}
+
+// participatingFacilitators returns the facilitators provisioned like students: all of them, unless the
+// lab definition leaves them without an organization of their own
+func participatingFacilitators(definition *util.LabDefinition, facilitators []util.LabUser) []util.LabUser {
+	if !definition.FacilitatorsOwnOrgs() {
+		return nil
+	}
+	return facilitators
+}
+
+// orgsToDelete collapses the plans of a single-org lab into its one shared organization
+func orgsToDelete(plans []labOrgPlan, topology util.Topology) []labOrgPlan {
+	if topology != util.TopologySingleOrg || len(plans) == 0 {
+		return plans
+	}
+	shared := labOrgPlan{Key: plans[0].OrgName, OrgName: plans[0].OrgName}
+	for _, plan := range plans {
+		shared.Members = append(shared.Members, plan.User)
+	}
+	return []labOrgPlan{shared}
+}
+
+// repoNameFor returns the name of a template's repository: its configured name, suffixed with the
+// user in a single-org lab so every user gets their own copy
+func repoNameFor(plan labOrgPlan, shared bool, repoConfig util.RepoConfig) string {
+	name := repoConfig.RepoName()
+	if shared {
+		return name + "-" + plan.User.Login
+	}
+	return name
+}
+
+// addTeam adds the members of a team organization to it, creates their team and adds them to the team
+func addTeam(ctx context.Context, logger *slog.Logger, organization *api.Organization, plan labOrgPlan, facilitators []string) (*api.Team, error) {
+	for _, member := range plan.Members {
+		// Facilitators already own the organization
+		if slices.Contains(facilitators, member.Login) {
+			continue
+		}
+		if err := organization.AddMember(ctx, logger, member.Login, "member"); err != nil {
+			return nil, err
+		}
+	}
+
+	team, err := organization.CreateTeam(ctx, logger, plan.Team)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range plan.Members {
+		if err := organization.AddTeamMember(ctx, logger, team.Slug, member.Login); err != nil {
+			return nil, err
+		}
+	}
+	return team, nil
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
+)
+
+func TestPlanLabOrgs(t *testing.T) {
+	type plan struct {
+		key     string
+		org     string
+		user    string
+		team    string
+		members []string
+	}
+	student := func(login string, team string) util.LabUser {
+		return util.LabUser{Login: login, Team: team, Role: util.RoleStudent}
+	}
+	facilitator := func(login string, team string) util.LabUser {
+		return util.LabUser{Login: login, Team: team, Role: util.RoleFacilitator}
+	}
+
+	tests := []struct {
+		name         string
+		topology     util.Topology
+		students     []util.LabUser
+		facilitators []util.LabUser
+		want         []plan
+		wantErr      string
+	}{
+		{
+			name:         "org per user",
+			topology:     util.TopologyOrgPerUser,
+			students:     []util.LabUser{student("alice", "red"), student("bob_smith", "")},
+			facilitators: []util.LabUser{facilitator("frank", "")},
+			want: []plan{
+				{key: "alice", org: "ghas-labs-2026-10-19-alice", user: "alice"},
+				{key: "bob_smith", org: "ghas-labs-2026-10-19-bob-smith", user: "bob_smith"},
+				{key: "frank", org: "ghas-labs-2026-10-19-frank", user: "frank"},
+			},
+		},
+		{
+			name:     "no topology is org per user",
+			students: []util.LabUser{student("alice", "")},
+			want:     []plan{{key: "alice", org: "ghas-labs-2026-10-19-alice", user: "alice"}},
+		},
+		{
+			name:         "single org",
+			topology:     util.TopologySingleOrg,
+			students:     []util.LabUser{student("alice", ""), student("bob", "")},
+			facilitators: []util.LabUser{facilitator("frank", "")},
+			want: []plan{
+				{key: "alice", org: "ghas-labs-2026-10-19", user: "alice"},
+				{key: "bob", org: "ghas-labs-2026-10-19", user: "bob"},
+				{key: "frank", org: "ghas-labs-2026-10-19", user: "frank"},
+			},
+		},
+		{
+			name:         "org per team groups teams by slug",
+			topology:     util.TopologyOrgPerTeam,
+			students:     []util.LabUser{student("alice", "Red Team"), student("carol", "Blue"), student("bob", " red team ")},
+			facilitators: []util.LabUser{facilitator("frank", ""), facilitator("grace", "Blue")},
+			want: []plan{
+				{key: "Red Team", org: "ghas-labs-2026-10-19-team-red-team", team: "Red Team", members: []string{"alice", "bob"}},
+				{key: "Blue", org: "ghas-labs-2026-10-19-team-blue", team: "Blue", members: []string{"carol", "grace"}},
+			},
+		},
+		{
+			name:         "org per team without facilitator teams",
+			topology:     util.TopologyOrgPerTeam,
+			students:     []util.LabUser{student("alice", "red")},
+			facilitators: []util.LabUser{facilitator("frank", "")},
+			want:         []plan{{key: "red", org: "ghas-labs-2026-10-19-team-red", team: "red", members: []string{"alice"}}},
+		},
+		{
+			name:     "org per team needs a team for every student",
+			topology: util.TopologyOrgPerTeam,
+			students: []util.LabUser{student("alice", "red"), student("bob", ""), student("carol", "  ")},
+			wantErr:  "missing for bob, carol",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plans, err := planLabOrgs("2026-10-19", tt.topology, tt.students, tt.facilitators)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]plan, len(plans))
+			for i, p := range plans {
+				got[i] = plan{key: p.Key, org: p.OrgName, user: p.User.Login, team: p.Team}
+				for _, member := range p.Members {
+					got[i].members = append(got[i].members, member.Login)
+				}
+			}
+			if !slices.EqualFunc(got, tt.want, func(a, b plan) bool {
+				return a.key == b.key && a.org == b.org && a.user == b.user && a.team == b.team && slices.Equal(a.members, b.members)
+			}) {
+				t.Errorf("plans = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepoNameFor(t *testing.T) {
+	plan := labOrgPlan{Key: "alice", User: util.LabUser{Login: "alice"}}
+
+	tests := []struct {
+		name       string
+		shared     bool
+		repoConfig util.RepoConfig
+		want       string
+	}{
+		{"template", false, util.RepoConfig{Template: "octo/demo"}, "demo"},
+		{"template in a shared organization", true, util.RepoConfig{Template: "octo/demo"}, "demo-alice"},
+		{"configured name", false, util.RepoConfig{Template: "octo/demo", Name: "exercise"}, "exercise"},
+		{"configured name in a shared organization", true, util.RepoConfig{Template: "octo/demo", Name: "exercise"}, "exercise-alice"},
+		{"imported bundle in a shared organization", true, util.RepoConfig{Source: util.SourceBundle, Path: "repos/app.bundle"}, "app-alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := repoNameFor(plan, tt.shared, tt.repoConfig); got != tt.want {
+				t.Errorf("repoNameFor = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/s-samadi/ghas-lab-builder/internal/util"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ServiceName identifies this tool in exported traces
+	ServiceName = "ghas-lab-builder"
+
+	tracerName = "github.com/s-samadi/ghas-lab-builder"
+)
+
+// Common span attribute keys
+const (
+	AttrOrg            = attribute.Key("ghas.org")
+	AttrUser           = attribute.Key("ghas.user")
+	AttrRepo           = attribute.Key("ghas.repo")
+	AttrLabDate        = attribute.Key("ghas.lab_date")
+	AttrEnterpriseSlug = attribute.Key("ghas.enterprise")
+	AttrWorkerID       = attribute.Key("ghas.worker_id")
+	AttrTargetType     = attribute.Key("ghas.target_type")
+	AttrTarget         = attribute.Key("ghas.target")
+	AttrInstallationID = attribute.Key("ghas.installation_id")
+)
+
+// Config controls where spans are exported
+type Config struct {
+	// Enabled turns tracing on. When false a no-op tracer is used.
+	Enabled bool
+	// Endpoint is the OTLP/HTTP collector endpoint (e.g. "http://localhost:4318").
+	// If empty, OTEL_EXPORTER_OTLP_TRACES_ENDPOINT / OTEL_EXPORTER_OTLP_ENDPOINT are consulted.
+	Endpoint string
+	// FileDir receives a JSON file of spans when no collector endpoint is configured, unless NoFile is set
+	FileDir string
+	NoFile  bool
+}
+
+// Setup installs the global tracer provider according to cfg and returns a shutdown function
+// that flushes any buffered spans, and the trace file if spans are written to one. The shutdown
+// function is never nil.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, string, error) {
+	noop := func(context.Context) error { return nil }
+
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	}
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+
+	if !cfg.Enabled && cfg.Endpoint == "" {
+		return noop, "", nil
+	}
+
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+	filePath := ""
+
+	if endpoint != "" {
+		opts := []otlptracehttp.Option{}
+		// Accept both full URLs and bare host:port values
+		if strings.Contains(endpoint, "://") {
+			opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+		} else {
+			opts = append(opts, otlptracehttp.WithEndpoint(endpoint), otlptracehttp.WithInsecure())
+		}
+		exp, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return noop, "", fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+		}
+		exporter = exp
+	} else {
+		if cfg.NoFile {
+			return noop, "", fmt.Errorf("tracing without a trace file needs an OTLP endpoint: set --otel-endpoint or drop --no-log-file")
+		}
+		filePath = util.GenerateLogFileName(cfg.FileDir, "ghas-lab-builder-trace", util.LogFormatJSON)
+		if dir := filepath.Dir(filePath); dir != "" && dir != "." {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return noop, "", fmt.Errorf("failed to create trace directory: %w", err)
+			}
+		}
+		f, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return noop, "", fmt.Errorf("failed to open trace file: %w", err)
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return noop, "", fmt.Errorf("failed to create file trace exporter: %w", err)
+		}
+		exporter = exp
+		file = f
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(ServiceName),
+	))
+	if err != nil {
+		res = resource.Default()
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	shutdown := func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			err = errors.Join(err, file.Close())
+		}
+		return err
+	}
+
+	return shutdown, filePath, nil
+}
+
+// Tracer returns the tracer used for all lab builder spans
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// StartSpan starts a span as a child of any span already in ctx
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err (if any) on the span and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		// Errors often embed GitHub responses, so scrub them like log records
+		msg := util.Redact(err.Error())
+		span.RecordError(errors.New(msg))
+		span.SetStatus(codes.Error, msg)
+	}
+	span.End()
+}
@@ -0,0 +1,104 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Default timeouts for connections to GitHub
+const (
+	DefaultConnectTimeout  = 10 * time.Second
+	DefaultResponseTimeout = 2 * time.Minute
+)
+
+// Options configures the HTTP transport shared by every GitHub call
+type Options struct {
+	// CAFile is a PEM bundle of extra certificate authorities to trust, added to the system roots
+	CAFile string
+
+	// ProxyURL overrides HTTPS_PROXY/HTTP_PROXY/NO_PROXY from the environment when set
+	ProxyURL string
+
+	// ClientCertFile and ClientKeyFile are a PEM certificate and key for mutual TLS
+	ClientCertFile string
+	ClientKeyFile  string
+
+	// ConnectTimeout bounds dialing and the TLS handshake
+	ConnectTimeout time.Duration
+
+	// ResponseTimeout bounds the wait for response headers once a request is sent
+	ResponseTimeout time.Duration
+}
+
+// New builds an HTTP transport from the options. Zero timeouts use the defaults.
+func New(opts Options) (*http.Transport, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil || roots == nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA file %s does not contain any PEM certificates", opts.CAFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	if (opts.ClientCertFile == "") != (opts.ClientKeyFile == "") {
+		return nil, fmt.Errorf("client certificate and client key must be provided together")
+	}
+	if opts.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.ClientCertFile, opts.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if opts.ProxyURL != "" {
+		proxyURL, err := url.Parse(opts.ProxyURL)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q: expected e.g. http://proxy.example.com:3128", opts.ProxyURL)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	connectTimeout := opts.ConnectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = DefaultConnectTimeout
+	}
+	responseTimeout := opts.ResponseTimeout
+	if responseTimeout <= 0 {
+		responseTimeout = DefaultResponseTimeout
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   20,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   connectTimeout,
+		ResponseHeaderTimeout: responseTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}, nil
+}
@@ -0,0 +1,397 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Object types of a pack file
+const (
+	packCommit   = 1
+	packTree     = 2
+	packBlob     = 3
+	packTag      = 4
+	packOfsDelta = 6
+	packRefDelta = 7
+)
+
+var packTypeNames = map[int]string{packCommit: "commit", packTree: "tree", packBlob: "blob", packTag: "tag"}
+
+type gitObject struct {
+	kind int
+	data []byte
+}
+
+// ReadBundle reads the files of a commit of a git bundle, as created by `git bundle create`. ref names a
+// branch or tag of the bundle; it defaults to the bundle's HEAD.
+func ReadBundle(path string, ref string) (*RepoSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	refs, pack, err := parseBundleHeader(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	commit, err := selectBundleRef(refs, ref)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	objects, err := readPack(pack)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// Annotated tags point at the commit through a tag object
+	object, ok := objects[commit]
+	for ok && object.kind == packTag {
+		commit, err = headerField(object.data, "object")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		object, ok = objects[commit]
+	}
+	if !ok || object.kind != packCommit {
+		return nil, fmt.Errorf("%s: commit %s is not in the bundle", path, commit)
+	}
+	tree, err := headerField(object.data, "tree")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	snapshot := &RepoSnapshot{Commit: commit, Tree: tree}
+	if err := walkTree(objects, tree, "", &snapshot.Files); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return snapshot, nil
+}
+
+// parseBundleHeader splits a v2 or v3 bundle into its refs, keyed by name, and its pack
+func parseBundleHeader(data []byte) (map[string]string, []byte, error) {
+	reader := bufio.NewReader(bytes.NewReader(data))
+	signature, err := reader.ReadString('\n')
+	if err != nil || (signature != "# v2 git bundle\n" && signature != "# v3 git bundle\n") {
+		return nil, nil, fmt.Errorf("not a git bundle")
+	}
+
+	refs := map[string]string{}
+	offset := len(signature)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, nil, fmt.Errorf("truncated bundle header")
+		}
+		offset += len(line)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return refs, data[offset:], nil
+		case strings.HasPrefix(line, "@"):
+			// v3 capabilities, such as the object format
+			if strings.HasPrefix(line, "@object-format=") && line != "@object-format=sha1" {
+				return nil, nil, fmt.Errorf("unsupported bundle capability %s", line)
+			}
+		case strings.HasPrefix(line, "-"):
+			return nil, nil, fmt.Errorf("bundle depends on commits it does not contain; create it from the full history, e.g. with `git bundle create <file> --all`")
+		default:
+			sha, name, ok := strings.Cut(line, " ")
+			if !ok {
+				return nil, nil, fmt.Errorf("malformed bundle ref %q", line)
+			}
+			refs[name] = sha
+		}
+	}
+}
+
+// selectBundleRef returns the commit of a branch or tag of the bundle, or of its HEAD
+func selectBundleRef(refs map[string]string, ref string) (string, error) {
+	if ref == "" {
+		if sha, ok := refs["HEAD"]; ok {
+			return sha, nil
+		}
+		for _, branch := range []string{"refs/heads/main", "refs/heads/master"} {
+			if sha, ok := refs[branch]; ok {
+				return sha, nil
+			}
+		}
+		if len(refs) == 1 {
+			for _, sha := range refs {
+				return sha, nil
+			}
+		}
+		return "", fmt.Errorf("bundle has no HEAD; set the ref to import")
+	}
+	for _, name := range []string{ref, "refs/heads/" + ref, "refs/tags/" + ref} {
+		if sha, ok := refs[name]; ok {
+			return sha, nil
+		}
+	}
+	return "", fmt.Errorf("ref %s is not in the bundle", ref)
+}
+
+// readPack reads every object of a pack file, keyed by SHA, resolving deltas against their base objects
+func readPack(pack []byte) (map[string]gitObject, error) {
+	if len(pack) < 12 || string(pack[:4]) != "PACK" {
+		return nil, fmt.Errorf("bundle has no pack data")
+	}
+	count := binary.BigEndian.Uint32(pack[8:12])
+
+	type delta struct {
+		baseOffset int
+		baseSHA    string
+		data       []byte
+	}
+	objects := map[string]gitObject{}
+	byOffset := map[int]string{}
+	deltas := map[int]delta{}
+	var pending []int
+
+	reader := bytes.NewReader(pack)
+	reader.Seek(12, io.SeekStart)
+	for range count {
+		offset := int(reader.Size()) - reader.Len()
+		kind, err := readObjectHeader(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		var d delta
+		switch kind {
+		case packOfsDelta:
+			distance, err := readOffset(reader)
+			if err != nil {
+				return nil, err
+			}
+			d.baseOffset = offset - distance
+		case packRefDelta:
+			sha := make([]byte, 20)
+			if _, err := io.ReadFull(reader, sha); err != nil {
+				return nil, fmt.Errorf("truncated pack: %w", err)
+			}
+			d.baseSHA = hex.EncodeToString(sha)
+		}
+
+		data, err := inflate(reader)
+		if err != nil {
+			return nil, err
+		}
+		if kind == packOfsDelta || kind == packRefDelta {
+			d.data = data
+			deltas[offset] = d
+			pending = append(pending, offset)
+			continue
+		}
+		byOffset[offset] = storeObject(objects, kind, data)
+	}
+
+	// A delta's base may itself be a delta, so they are resolved until none is left
+	for len(pending) > 0 {
+		var unresolved []int
+		for _, offset := range pending {
+			d := deltas[offset]
+			baseSHA := d.baseSHA
+			if baseSHA == "" {
+				baseSHA = byOffset[d.baseOffset]
+			}
+			base, ok := objects[baseSHA]
+			if !ok {
+				unresolved = append(unresolved, offset)
+				continue
+			}
+			data, err := applyDelta(base.data, d.data)
+			if err != nil {
+				return nil, err
+			}
+			byOffset[offset] = storeObject(objects, base.kind, data)
+		}
+		if len(unresolved) == len(pending) {
+			return nil, fmt.Errorf("pack has %d deltas whose base object is missing", len(unresolved))
+		}
+		pending = unresolved
+	}
+	return objects, nil
+}
+
+// readObjectHeader reads the type of a packed object; its size is implied by the compressed data
+func readObjectHeader(reader *bytes.Reader) (int, error) {
+	b, err := reader.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("truncated pack: %w", err)
+	}
+	kind := int(b>>4) & 7
+	for b&0x80 != 0 {
+		if b, err = reader.ReadByte(); err != nil {
+			return 0, fmt.Errorf("truncated pack: %w", err)
+		}
+	}
+	return kind, nil
+}
+
+// readOffset reads the distance from an offset delta back to its base object
+func readOffset(reader *bytes.Reader) (int, error) {
+	b, err := reader.ReadByte()
+	if err != nil {
+		return 0, fmt.Errorf("truncated pack: %w", err)
+	}
+	distance := int(b & 0x7f)
+	for b&0x80 != 0 {
+		if b, err = reader.ReadByte(); err != nil {
+			return 0, fmt.Errorf("truncated pack: %w", err)
+		}
+		distance = (distance+1)<<7 | int(b&0x7f)
+	}
+	return distance, nil
+}
+
+// inflate decompresses one object. bytes.Reader is an io.ByteReader, so zlib reads no further than the
+// end of the object and the reader is left at the next one.
+func inflate(reader *bytes.Reader) ([]byte, error) {
+	z, err := zlib.NewReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt pack object: %w", err)
+	}
+	defer z.Close()
+	data, err := io.ReadAll(z)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt pack object: %w", err)
+	}
+	return data, nil
+}
+
+// storeObject adds an object under its SHA and returns the SHA
+func storeObject(objects map[string]gitObject, kind int, data []byte) string {
+	sha := objectSHA(packTypeNames[kind], data)
+	objects[sha] = gitObject{kind: kind, data: data}
+	return sha
+}
+
+// objectSHA hashes an object the way git does
+func objectSHA(kind string, data []byte) string {
+	hash := sha1.New()
+	fmt.Fprintf(hash, "%s %d\x00", kind, len(data))
+	hash.Write(data)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// BlobSHA returns the SHA git gives a file's content
+func BlobSHA(content []byte) string {
+	return objectSHA("blob", content)
+}
+
+// applyDelta rebuilds an object from its base and a delta of copy and insert instructions
+func applyDelta(base []byte, delta []byte) ([]byte, error) {
+	reader := bytes.NewReader(delta)
+	baseSize, err := binary.ReadUvarint(reader)
+	if err != nil || int(baseSize) != len(base) {
+		return nil, fmt.Errorf("corrupt delta: base size mismatch")
+	}
+	size, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt delta: %w", err)
+	}
+
+	result := make([]byte, 0, size)
+	for reader.Len() > 0 {
+		op, _ := reader.ReadByte()
+		if op&0x80 == 0 {
+			// Insert the next op bytes
+			if op == 0 {
+				return nil, fmt.Errorf("corrupt delta: reserved instruction")
+			}
+			insert := make([]byte, op)
+			if _, err := io.ReadFull(reader, insert); err != nil {
+				return nil, fmt.Errorf("corrupt delta: %w", err)
+			}
+			result = append(result, insert...)
+			continue
+		}
+
+		// Copy a range of the base; the low bits say which offset and size bytes follow
+		var offset, length int
+		for i := range 7 {
+			if op&(1<<i) == 0 {
+				continue
+			}
+			b, err := reader.ReadByte()
+			if err != nil {
+				return nil, fmt.Errorf("corrupt delta: %w", err)
+			}
+			if i < 4 {
+				offset |= int(b) << (8 * i)
+			} else {
+				length |= int(b) << (8 * (i - 4))
+			}
+		}
+		if length == 0 {
+			length = 0x10000
+		}
+		if offset+length > len(base) {
+			return nil, fmt.Errorf("corrupt delta: copy out of range")
+		}
+		result = append(result, base[offset:offset+length]...)
+	}
+	if uint64(len(result)) != size {
+		return nil, fmt.Errorf("corrupt delta: result size mismatch")
+	}
+	return result, nil
+}
+
+// headerField reads a header line, such as the tree of a commit, from a commit or tag object
+func headerField(data []byte, name string) (string, error) {
+	for line := range strings.SplitSeq(string(data), "\n") {
+		if line == "" {
+			break
+		}
+		if value, ok := strings.CutPrefix(line, name+" "); ok {
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("object has no %s", name)
+}
+
+// walkTree adds the files under a tree to files, prefixing their paths with dir
+func walkTree(objects map[string]gitObject, sha string, dir string, files *[]SourceFile) error {
+	tree, ok := objects[sha]
+	if !ok || tree.kind != packTree {
+		return fmt.Errorf("tree %s is not in the bundle", sha)
+	}
+
+	data := tree.data
+	for len(data) > 0 {
+		// Entries are "<mode> <name>\0<20 byte SHA>"
+		header, rest, ok := bytes.Cut(data, []byte{0})
+		if !ok || len(rest) < 20 {
+			return fmt.Errorf("corrupt tree %s", sha)
+		}
+		mode, name, _ := strings.Cut(string(header), " ")
+		entrySHA := hex.EncodeToString(rest[:20])
+		data = rest[20:]
+
+		path := dir + name
+		switch mode {
+		case "40000":
+			if err := walkTree(objects, entrySHA, path+"/", files); err != nil {
+				return err
+			}
+		case "160000":
+			*files = append(*files, SourceFile{Path: path, Mode: mode, SHA: entrySHA})
+		default:
+			blob, ok := objects[entrySHA]
+			if !ok || blob.kind != packBlob {
+				return fmt.Errorf("blob %s of %s is not in the bundle", entrySHA, path)
+			}
+			*files = append(*files, SourceFile{Path: path, Mode: mode, Content: blob.data})
+		}
+	}
+	return nil
+}
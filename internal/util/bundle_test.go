@@ -0,0 +1,271 @@
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gitOutput runs git in dir and returns its output
+func gitOutput(t *testing.T, dir string, stdin []byte, args ...string) []byte {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=Lab", "GIT_AUTHOR_EMAIL=lab@example.com", "GIT_AUTHOR_DATE=2025-01-01T00:00:00Z",
+		"GIT_COMMITTER_NAME=Lab", "GIT_COMMITTER_EMAIL=lab@example.com", "GIT_COMMITTER_DATE=2025-01-01T00:00:00Z",
+		"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1")
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, stderr.String())
+	}
+	return out
+}
+
+// git runs git in dir and returns its trimmed output
+func git(t *testing.T, dir string, stdin []byte, args ...string) string {
+	t.Helper()
+	return strings.TrimSpace(string(gitOutput(t, dir, stdin, args...)))
+}
+
+// testRepo creates a repository with two commits that differ slightly in a large file, so its packs
+// hold deltas, plus an executable, a symlink, a submodule and an annotated tag of the first commit
+func testRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	git(t, dir, nil, "init", "--quiet", "--initial-branch=main")
+
+	var large strings.Builder
+	for i := range 400 {
+		fmt.Fprintf(&large, "line %d of a vulnerable application\n", i)
+	}
+	write := func(name string, content string, mode os.FileMode) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("README.md", "# Demo\n", 0o644)
+	write("src/app.py", large.String(), 0o644)
+	write("scripts/run.sh", "#!/bin/sh\necho run\n", 0o755)
+	if err := os.Symlink("src/app.py", filepath.Join(dir, "app.py")); err != nil {
+		t.Fatal(err)
+	}
+	git(t, dir, nil, "add", ".")
+	git(t, dir, nil, "update-index", "--add", "--cacheinfo", "160000,1111111111111111111111111111111111111111,vendor/lib")
+	git(t, dir, nil, "commit", "--quiet", "-m", "v1")
+	git(t, dir, nil, "tag", "-a", "v1.0", "-m", "first release")
+
+	write("src/app.py", large.String()+"patched\n", 0o644)
+	git(t, dir, nil, "commit", "--quiet", "-am", "v2")
+	return dir
+}
+
+// checkSnapshot verifies a snapshot against the commit it was read from, and that its files rebuild
+// the commit's tree in another repository, as an import does through the API
+func checkSnapshot(t *testing.T, dir string, snapshot *RepoSnapshot, rev string) {
+	t.Helper()
+	if want := git(t, dir, nil, "rev-parse", rev+"^{commit}"); snapshot.Commit != want {
+		t.Errorf("commit = %s, want %s", snapshot.Commit, want)
+	}
+	if want := git(t, dir, nil, "rev-parse", rev+"^{tree}"); snapshot.Tree != want {
+		t.Errorf("tree = %s, want %s", snapshot.Tree, want)
+	}
+
+	var listed []string
+	for _, file := range snapshot.Files {
+		sha := file.SHA
+		if file.Mode != "160000" {
+			sha = BlobSHA(file.Content)
+		}
+		listed = append(listed, fmt.Sprintf("%s %s\t%s", file.Mode, sha, file.Path))
+	}
+	var want []string
+	for _, line := range strings.Split(git(t, dir, nil, "ls-tree", "-r", rev), "\n") {
+		// "<mode> <type> <sha>\t<path>" without the type
+		mode, rest, _ := strings.Cut(line, " ")
+		_, rest, _ = strings.Cut(rest, " ")
+		want = append(want, mode+" "+rest)
+	}
+	if strings.Join(listed, "\n") != strings.Join(want, "\n") {
+		t.Errorf("files =\n%s\nwant\n%s", strings.Join(listed, "\n"), strings.Join(want, "\n"))
+	}
+
+	rebuilt := t.TempDir()
+	git(t, rebuilt, nil, "init", "--quiet")
+	for _, file := range snapshot.Files {
+		sha := file.SHA
+		if file.Mode != "160000" {
+			sha = git(t, rebuilt, file.Content, "hash-object", "-w", "--stdin")
+		}
+		git(t, rebuilt, nil, "update-index", "--add", "--cacheinfo", file.Mode+","+sha+","+file.Path)
+	}
+	if tree := git(t, rebuilt, nil, "write-tree"); tree != snapshot.Tree {
+		t.Errorf("rebuilt tree = %s, want %s", tree, snapshot.Tree)
+	}
+}
+
+// requireDeltas fails the test unless a pack stores some objects as deltas of the given kind, which
+// the tests rely on
+func requireDeltas(t *testing.T, pack []byte, kind int) {
+	t.Helper()
+	reader := bytes.NewReader(pack)
+	reader.Seek(12, io.SeekStart)
+	for range binary.BigEndian.Uint32(pack[8:12]) {
+		objectKind, err := readObjectHeader(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch objectKind {
+		case packOfsDelta:
+			if _, err := readOffset(reader); err != nil {
+				t.Fatal(err)
+			}
+		case packRefDelta:
+			reader.Seek(20, io.SeekCurrent)
+		}
+		if _, err := inflate(reader); err != nil {
+			t.Fatal(err)
+		}
+		if objectKind == kind {
+			return
+		}
+	}
+	t.Fatalf("pack has no objects of type %d", kind)
+}
+
+// writeBundle writes a bundle of a pack by hand, with the given header lines
+func writeBundle(t *testing.T, signature string, lines []string, pack []byte) string {
+	t.Helper()
+	var data bytes.Buffer
+	data.WriteString(signature + "\n")
+	for _, line := range lines {
+		data.WriteString(line + "\n")
+	}
+	data.WriteString("\n")
+	data.Write(pack)
+
+	path := filepath.Join(t.TempDir(), "demo.bundle")
+	if err := os.WriteFile(path, data.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadBundle(t *testing.T) {
+	dir := testRepo(t)
+	bundle := filepath.Join(t.TempDir(), "demo.bundle")
+	git(t, dir, nil, "bundle", "create", "--quiet", bundle, "--all")
+
+	// git bundle create refers to delta bases by offset
+	data, err := os.ReadFile(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pack, err := parseBundleHeader(data)
+	if err != nil {
+		t.Fatalf("parseBundleHeader: %v", err)
+	}
+	requireDeltas(t, pack, packOfsDelta)
+
+	tests := []struct {
+		name string
+		ref  string
+		rev  string
+	}{
+		{"head", "", "HEAD"},
+		{"branch", "main", "main"},
+		{"full ref name", "refs/heads/main", "main"},
+		{"annotated tag", "v1.0", "v1.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snapshot, err := ReadBundle(bundle, tt.ref)
+			if err != nil {
+				t.Fatalf("ReadBundle: %v", err)
+			}
+			checkSnapshot(t, dir, snapshot, tt.rev)
+		})
+	}
+
+	if _, err := ReadBundle(bundle, "missing"); err == nil || !strings.Contains(err.Error(), "ref missing is not in the bundle") {
+		t.Errorf("missing ref: error = %v", err)
+	}
+}
+
+func TestReadBundleRefDeltas(t *testing.T) {
+	dir := testRepo(t)
+
+	// Without --delta-base-offset, pack-objects refers to delta bases by SHA
+	pack := gitOutput(t, dir, []byte("HEAD\n"), "pack-objects", "--revs", "--stdout", "-q")
+	requireDeltas(t, pack, packRefDelta)
+
+	head := git(t, dir, nil, "rev-parse", "HEAD")
+	bundle := writeBundle(t, "# v2 git bundle", []string{head + " refs/heads/main"}, pack)
+	snapshot, err := ReadBundle(bundle, "")
+	if err != nil {
+		t.Fatalf("ReadBundle: %v", err)
+	}
+	checkSnapshot(t, dir, snapshot, "HEAD")
+}
+
+func TestReadBundleHeader(t *testing.T) {
+	dir := testRepo(t)
+	pack := gitOutput(t, dir, []byte("HEAD\n"), "pack-objects", "--revs", "--stdout", "-q", "--delta-base-offset")
+	head := git(t, dir, nil, "rev-parse", "HEAD")
+	parent := git(t, dir, nil, "rev-parse", "HEAD~1")
+
+	tests := []struct {
+		name      string
+		signature string
+		lines     []string
+		wantErr   string
+	}{
+		{"v3", "# v3 git bundle", []string{"@object-format=sha1", head + " HEAD"}, ""},
+		{"v3 with another capability", "# v3 git bundle", []string{"@filter=blob:none", head + " HEAD"}, ""},
+		{"sha256", "# v3 git bundle", []string{"@object-format=sha256", head + " HEAD"}, "unsupported bundle capability"},
+		{"prerequisite", "# v2 git bundle", []string{"-" + parent + " v1", head + " HEAD"}, "depends on commits it does not contain"},
+		{"malformed ref", "# v2 git bundle", []string{head}, "malformed bundle ref"},
+		{"no head", "# v2 git bundle", []string{head + " refs/heads/a", head + " refs/heads/b"}, "bundle has no HEAD"},
+		{"not a bundle", "# v4 git bundle", []string{head + " HEAD"}, "not a git bundle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snapshot, err := ReadBundle(writeBundle(t, tt.signature, tt.lines, pack), "")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ReadBundle: %v", err)
+				}
+				checkSnapshot(t, dir, snapshot, "HEAD")
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// A real bundle of a range has prerequisites
+	bundle := filepath.Join(t.TempDir(), "range.bundle")
+	git(t, dir, nil, "bundle", "create", "--quiet", bundle, "HEAD~1..main")
+	if _, err := ReadBundle(bundle, ""); err == nil || !strings.Contains(err.Error(), "depends on commits it does not contain") {
+		t.Errorf("range bundle: error = %v", err)
+	}
+}
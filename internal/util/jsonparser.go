@@ -2,13 +2,178 @@ package util
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 // RepoConfig represents a repository configuration
 type RepoConfig struct {
 	Template           string `json:"template"`
 	IncludeAllBranches bool   `json:"include_all_branches"`
+	// Source is template (the default), or bundle or tarball to import Path instead
+	Source RepoSource `json:"source,omitempty"`
+	// Path of the bundle or tarball, relative to the repos file
+	Path string `json:"path,omitempty"`
+	// Snapshot is the content read from Path, or the pinned commit of the template, once for the whole lab
+	Snapshot *RepoSnapshot `json:"-"`
+	// Seed describes the issues, labels, branches and pull requests created in every generated repository
+	Seed *RepoSeed `json:"seed,omitempty"`
+
+	// Name of the generated repository; defaults to the template's name
+	Name string `json:"name,omitempty"`
+	// Visibility is private (the default), internal or public
+	Visibility  string   `json:"visibility,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Topics      []string `json:"topics,omitempty"`
+	// DefaultBranch switches the default branch to a branch of the generated repository
+	DefaultBranch string        `json:"default_branch,omitempty"`
+	Features      *RepoFeatures `json:"features,omitempty"`
+	// ActionsEnabled turns GitHub Actions on or off for the repository
+	ActionsEnabled *bool `json:"actions_enabled,omitempty"`
+
+	// Ref pins the content of the default branch to a tag, branch or commit of the template, or picks
+	// the branch or tag of a bundle to import
+	Ref string `json:"ref,omitempty"`
+	// PinnedSHA is the commit Ref resolved to, once for the whole lab
+	PinnedSHA string `json:"-"`
+}
+
+// RepoFeatures turns repository features on or off; features left out keep the template's setting
+type RepoFeatures struct {
+	Issues      *bool `json:"issues,omitempty"`
+	Wiki        *bool `json:"wiki,omitempty"`
+	Projects    *bool `json:"projects,omitempty"`
+	Discussions *bool `json:"discussions,omitempty"`
+}
+
+// SourceName identifies where the repository comes from: its template, or the file it is imported from
+func (r RepoConfig) SourceName() string {
+	if r.IsImported() {
+		return r.Path
+	}
+	return r.Template
+}
+
+// IsImported reports whether the repository is imported from a local bundle or tarball
+func (r RepoConfig) IsImported() bool {
+	return r.Source == SourceBundle || r.Source == SourceTarball
+}
+
+// RepoName returns the name of the repository generated from the template, or imported from a file
+// named after it
+func (r RepoConfig) RepoName() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	if r.IsImported() {
+		name := filepath.Base(r.Path)
+		for _, ext := range []string{".bundle", ".tar.gz", ".tgz", ".tar"} {
+			name = strings.TrimSuffix(name, ext)
+		}
+		return name
+	}
+	if _, name, ok := strings.Cut(r.Template, "/"); ok {
+		return name
+	}
+	return r.Template
+}
+
+// HasSettings reports whether the repository is configured beyond its name and description, which are
+// set when it is generated
+func (r RepoConfig) HasSettings() bool {
+	return r.Visibility == "internal" || len(r.Topics) > 0 || r.DefaultBranch != "" || r.Features != nil || r.ActionsEnabled != nil
+}
+
+// RepoSeed describes the exercise material of a generated repository
+type RepoSeed struct {
+	Labels       []SeedLabel       `json:"labels,omitempty"`
+	Branches     []SeedBranch      `json:"branches,omitempty"`
+	Issues       []SeedIssue       `json:"issues,omitempty"`
+	PullRequests []SeedPullRequest `json:"pull_requests,omitempty"`
+}
+
+// SeedLabel is a label created in the repository
+type SeedLabel struct {
+	Name        string `json:"name"`
+	Color       string `json:"color,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// SeedBranch is a branch created from another branch of the repository, by default its default branch
+type SeedBranch struct {
+	Name string `json:"name"`
+	From string `json:"from,omitempty"`
+}
+
+// SeedIssue is an issue opened in the repository
+type SeedIssue struct {
+	Title  string   `json:"title"`
+	Body   string   `json:"body,omitempty"`
+	Labels []string `json:"labels,omitempty"`
+}
+
+// SeedPullRequest is a pull request opened from a branch of the repository, by default into its default branch
+type SeedPullRequest struct {
+	Title  string   `json:"title"`
+	Body   string   `json:"body,omitempty"`
+	Head   string   `json:"head"`
+	Base   string   `json:"base,omitempty"`
+	Labels []string `json:"labels,omitempty"`
+	Draft  bool     `json:"draft,omitempty"`
+}
+
+// validate checks that every seeded item is named and that every pull request has commits to propose.
+// Its head must be a template branch, which only exists with include_all_branches, or a seed branch
+// created from one: a branch created from the default branch has nothing to merge into it.
+func (s *RepoSeed) validate(includeAllBranches bool) error {
+	for _, label := range s.Labels {
+		if label.Name == "" {
+			return fmt.Errorf("a seed label has no name")
+		}
+	}
+	branches := map[string]SeedBranch{}
+	for _, branch := range s.Branches {
+		if branch.Name == "" {
+			return fmt.Errorf("a seed branch has no name")
+		}
+		branches[branch.Name] = branch
+	}
+	for _, issue := range s.Issues {
+		if issue.Title == "" {
+			return fmt.Errorf("a seed issue has no title")
+		}
+	}
+	for _, pull := range s.PullRequests {
+		if pull.Title == "" || pull.Head == "" {
+			return fmt.Errorf("seed pull request %q needs a title and a head branch", pull.Title)
+		}
+		if pull.Head == pull.Base {
+			return fmt.Errorf("seed pull request %q has %s as both head and base", pull.Title, pull.Head)
+		}
+
+		// Follow seed branches back to the template branch they start from
+		origin := pull.Head
+		seen := map[string]bool{}
+		for branch, seeded := branches[origin]; seeded; branch, seeded = branches[origin] {
+			if seen[origin] {
+				return fmt.Errorf("seed pull request %q: seed branch %s is created from itself", pull.Title, origin)
+			}
+			if branch.From == "" {
+				return fmt.Errorf("seed pull request %q: branch %s starts from the default branch, so it has no commits to propose; create it from a template branch", pull.Title, pull.Head)
+			}
+			seen[origin] = true
+			origin = branch.From
+		}
+		if !includeAllBranches {
+			return fmt.Errorf("seed pull request %q: branch %s is copied from the template, which needs include_all_branches", pull.Title, origin)
+		}
+		if origin == pull.Base {
+			return fmt.Errorf("seed pull request %q: branch %s starts from its base %s, so it has no commits to propose", pull.Title, pull.Head, pull.Base)
+		}
+	}
+	return nil
 }
 
 // UnmarshalJSON allows RepoConfig to accept both string and object formats
@@ -34,13 +199,103 @@ func (r *RepoConfig) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// Topology decides how the users of a lab are spread across organizations
+type Topology string
+
+const (
+	// TopologyOrgPerUser gives every user an organization with the template repositories
+	TopologyOrgPerUser Topology = "org-per-user"
+	// TopologyOrgPerTeam gives every team of the users file an organization shared by its members
+	TopologyOrgPerTeam Topology = "org-per-team"
+	// TopologySingleOrg puts every user in one organization with repositories named <template>-<user>
+	TopologySingleOrg Topology = "single-org"
+)
+
+// DefaultRepoPermission is granted to users and teams on their repositories in shared organizations
+const DefaultRepoPermission = "admin"
+
+// OrgRole is the access facilitators and observers get to the organizations of the students
+type OrgRole string
+
+const (
+	// OrgRoleOwner makes them owners of the organizations
+	OrgRoleOwner OrgRole = "owner"
+	// OrgRoleSecurityManager makes them members with the security manager role
+	OrgRoleSecurityManager OrgRole = "security_manager"
+	// OrgRoleReadOnly makes them members with the read-only organization role
+	OrgRoleReadOnly OrgRole = "read_only"
+	// OrgRoleNone gives them no access
+	OrgRoleNone OrgRole = "none"
+)
+
+// DefaultReadOnlyRole is the organization role granted for OrgRoleReadOnly: GitHub's predefined
+// role that can read every repository of the organization
+const DefaultReadOnlyRole = "all_repo_read"
+
+// StaffAccess describes how facilitators or observers take part in a lab
+type StaffAccess struct {
+	Role OrgRole `json:"role"`
+	// OwnOrg gives facilitators an organization of their own, provisioned like a student's
+	OwnOrg *bool `json:"own_org,omitempty"`
+}
+
+// LabDefinition describes the organizations and repositories of a lab
+type LabDefinition struct {
+	Topology Topology `json:"topology"`
+	// RepoPermission is granted on the repositories of a shared organization to the team or user they belong to
+	RepoPermission string      `json:"repo_permission"`
+	Facilitators   StaffAccess `json:"facilitators"`
+	Observers      StaffAccess `json:"observers"`
+	// ReadOnlyRole names the organization role granted for OrgRoleReadOnly, e.g. a custom role
+	ReadOnlyRole string       `json:"read_only_role"`
+	Repos        []RepoConfig `json:"repos"`
+}
+
+// FacilitatorsOwnOrgs reports whether facilitators are provisioned like students
+func (d *LabDefinition) FacilitatorsOwnOrgs() bool {
+	return d.Facilitators.OwnOrg == nil || *d.Facilitators.OwnOrg
+}
+
+// DefaultLabDefinition is the definition of a lab without a template repositories file
+func DefaultLabDefinition() *LabDefinition {
+	definition := &LabDefinition{}
+	definition.setDefaults()
+	return definition
+}
+
+func (d *LabDefinition) setDefaults() {
+	if d.Topology == "" {
+		d.Topology = TopologyOrgPerUser
+	}
+	if d.RepoPermission == "" {
+		d.RepoPermission = DefaultRepoPermission
+	}
+	if d.Facilitators.Role == "" {
+		d.Facilitators.Role = OrgRoleOwner
+	}
+	if d.Observers.Role == "" {
+		d.Observers.Role = OrgRoleNone
+	}
+	if d.ReadOnlyRole == "" {
+		d.ReadOnlyRole = DefaultReadOnlyRole
+	}
+}
+
 type TemplateReposConfig struct {
-	LabEnvSetup struct {
-		Repos []RepoConfig `json:"repos"`
-	} `json:"lab-env-setup"`
+	LabEnvSetup LabDefinition `json:"lab-env-setup"`
 }
 
 func LoadFromJsonFile(path string) ([]RepoConfig, error) {
+	definition, err := LoadLabDefinition(path)
+	if err != nil {
+		return nil, err
+	}
+	return definition.Repos, nil
+}
+
+// LoadLabDefinition reads the lab-env-setup section of a template repositories file, defaulting to
+// one organization per user owned by the facilitators
+func LoadLabDefinition(path string) (*LabDefinition, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
@@ -52,5 +307,80 @@ func LoadFromJsonFile(path string) ([]RepoConfig, error) {
 		return nil, err
 	}
 
-	return config.LabEnvSetup.Repos, nil
+	definition := &config.LabEnvSetup
+	definition.setDefaults()
+	switch definition.Topology {
+	case TopologyOrgPerUser, TopologyOrgPerTeam, TopologySingleOrg:
+	default:
+		return nil, fmt.Errorf("%s: unknown topology %q (expected %s, %s or %s)", path, definition.Topology, TopologyOrgPerUser, TopologyOrgPerTeam, TopologySingleOrg)
+	}
+
+	switch definition.RepoPermission {
+	case "pull", "triage", "push", "maintain", "admin":
+	default:
+		return nil, fmt.Errorf("%s: unknown repo_permission %q (expected pull, triage, push, maintain or admin)", path, definition.RepoPermission)
+	}
+
+	for _, access := range []struct {
+		name string
+		role OrgRole
+	}{{"facilitators", definition.Facilitators.Role}, {"observers", definition.Observers.Role}} {
+		switch access.role {
+		case OrgRoleOwner, OrgRoleSecurityManager, OrgRoleReadOnly, OrgRoleNone:
+		default:
+			return nil, fmt.Errorf("%s: unknown %s role %q (expected %s, %s, %s or %s)", path, access.name, access.role, OrgRoleOwner, OrgRoleSecurityManager, OrgRoleReadOnly, OrgRoleNone)
+		}
+	}
+	if definition.Facilitators.Role == OrgRoleNone {
+		return nil, fmt.Errorf("%s: facilitators need a role in the lab organizations", path)
+	}
+	if definition.Observers.OwnOrg != nil {
+		return nil, fmt.Errorf("%s: observers cannot have an organization of their own", path)
+	}
+
+	for i := range definition.Repos {
+		repo := &definition.Repos[i]
+		if repo.Source == "" {
+			repo.Source = SourceTemplate
+		}
+		switch repo.Source {
+		case SourceTemplate:
+			if repo.Template == "" {
+				return nil, fmt.Errorf("%s: a template repository has no template", path)
+			}
+			// Only the default branch is pinned; other branches would keep the template's latest commits
+			if repo.Ref != "" && repo.IncludeAllBranches {
+				return nil, fmt.Errorf("%s: %s: ref pins only the default branch and cannot be combined with include_all_branches", path, repo.Template)
+			}
+		case SourceBundle, SourceTarball:
+			if repo.Path == "" {
+				return nil, fmt.Errorf("%s: a %s repository has no path", path, repo.Source)
+			}
+			if !filepath.IsAbs(repo.Path) {
+				repo.Path = filepath.Join(filepath.Dir(path), repo.Path)
+			}
+			if repo.IncludeAllBranches {
+				return nil, fmt.Errorf("%s: %s: include_all_branches needs a template source", path, repo.Path)
+			}
+			if repo.Source == SourceTarball && repo.Ref != "" {
+				return nil, fmt.Errorf("%s: %s: a tarball has no refs", path, repo.Path)
+			}
+		default:
+			return nil, fmt.Errorf("%s: unknown source %q (expected %s, %s or %s)", path, repo.Source, SourceTemplate, SourceBundle, SourceTarball)
+		}
+
+		switch repo.Visibility {
+		case "", "private", "internal", "public":
+		default:
+			return nil, fmt.Errorf("%s: %s: unknown visibility %q (expected private, internal or public)", path, repo.SourceName(), repo.Visibility)
+		}
+		if repo.Seed == nil {
+			continue
+		}
+		if err := repo.Seed.validate(repo.IncludeAllBranches); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, repo.SourceName(), err)
+		}
+	}
+
+	return definition, nil
 }
@@ -0,0 +1,91 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadLabDefinitionSeedPullRequests(t *testing.T) {
+	tests := []struct {
+		name               string
+		includeAllBranches bool
+		seed               string
+		wantErr            string
+	}{
+		{"template branch", true, `"pull_requests": [{"title": "Fix", "head": "vuln-dep"}]`, ""},
+		{"seed branch from a template branch", true, `"branches": [{"name": "exercise", "from": "solution"}], "pull_requests": [{"title": "Fix", "head": "exercise"}]`, ""},
+		{"seed branch from a seed branch from a template branch", true,
+			`"branches": [{"name": "a", "from": "b"}, {"name": "b", "from": "solution"}], "pull_requests": [{"title": "Fix", "head": "a"}]`, ""},
+		{"template branch without include_all_branches", false, `"pull_requests": [{"title": "Fix", "head": "vuln-dep"}]`, "needs include_all_branches"},
+		{"seed branch from the default branch", true, `"branches": [{"name": "exercise"}], "pull_requests": [{"title": "Fix", "head": "exercise"}]`, "no commits to propose"},
+		{"seed branch chain from the default branch", true,
+			`"branches": [{"name": "a", "from": "b"}, {"name": "b"}], "pull_requests": [{"title": "Fix", "head": "a"}]`, "no commits to propose"},
+		{"seed branch from a template branch without include_all_branches", false,
+			`"branches": [{"name": "exercise", "from": "solution"}], "pull_requests": [{"title": "Fix", "head": "exercise"}]`, "needs include_all_branches"},
+		{"seed branch from its base", true,
+			`"branches": [{"name": "exercise", "from": "main"}], "pull_requests": [{"title": "Fix", "head": "exercise", "base": "main"}]`, "starts from its base main"},
+		{"seed branch cycle", true,
+			`"branches": [{"name": "a", "from": "b"}, {"name": "b", "from": "a"}], "pull_requests": [{"title": "Fix", "head": "a"}]`, "created from itself"},
+		{"same head and base", true, `"pull_requests": [{"title": "Fix", "head": "main", "base": "main"}]`, "both head and base"},
+		{"missing head", true, `"pull_requests": [{"title": "Fix"}]`, "needs a title and a head branch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			includeAllBranches := "false"
+			if tt.includeAllBranches {
+				includeAllBranches = "true"
+			}
+			content := `{"lab-env-setup": {"repos": [{"template": "octo/demo", "include_all_branches": ` + includeAllBranches + `, "seed": {` + tt.seed + `}}]}}`
+			path := filepath.Join(t.TempDir(), "repos.json")
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := LoadLabDefinition(path)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadLabDefinitionRef(t *testing.T) {
+	tests := []struct {
+		name    string
+		repo    string
+		wantErr string
+	}{
+		{"pinned template", `{"template": "octo/demo", "ref": "v1.0"}`, ""},
+		{"all branches without ref", `{"template": "octo/demo", "include_all_branches": true}`, ""},
+		{"pinned template with all branches", `{"template": "octo/demo", "ref": "v1.0", "include_all_branches": true}`, "cannot be combined with include_all_branches"},
+		{"tarball with ref", `{"source": "tarball", "path": "demo.tar.gz", "ref": "v1.0"}`, "a tarball has no refs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := `{"lab-env-setup": {"repos": [` + tt.repo + `]}}`
+			path := filepath.Join(t.TempDir(), "repos.json")
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := LoadLabDefinition(path)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -6,18 +6,29 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
+// Supported log output formats
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
 // LoggerConfig holds configuration for logger initialization
 type LoggerConfig struct {
 	// LogFilePath is the path to the log file. If empty, logs to stdout only.
 	LogFilePath string
 	// LogLevel is the minimum log level to output
 	LogLevel slog.Level
+	// LogFormat is either LogFormatJSON (default) or LogFormatText
+	LogFormat string
+	// DisableStdout stops logs from being echoed to stdout, e.g. while the terminal progress view is active.
+	DisableStdout bool
 }
 
-// NewLogger creates a new structured logger that writes JSON logs to a file and stdout
+// NewLogger creates a new structured logger that writes JSON or text logs to a file and stdout
 func NewLogger(config LoggerConfig) (*slog.Logger, io.Closer, error) {
 	var writer io.Writer
 	var closer io.Closer
@@ -38,7 +49,11 @@ func NewLogger(config LoggerConfig) (*slog.Logger, io.Closer, error) {
 		}
 
 		// Use both stdout and file for logging
-		writer = io.MultiWriter(os.Stdout, file)
+		if config.DisableStdout {
+			writer = file
+		} else {
+			writer = io.MultiWriter(os.Stdout, file)
+		}
 		closer = file
 	} else {
 		// Default to stdout only
@@ -46,18 +61,57 @@ func NewLogger(config LoggerConfig) (*slog.Logger, io.Closer, error) {
 		closer = nil
 	}
 
-	// Create JSON handler with specified log level
+	// Create handler with specified log level and format.
+	// Every record passes through RedactAttr so tokens and keys never reach stdout or the log file.
 	opts := &slog.HandlerOptions{
-		Level: config.LogLevel,
+		Level:       config.LogLevel,
+		ReplaceAttr: RedactAttr,
+	}
+	var handler slog.Handler
+	switch config.LogFormat {
+	case "", LogFormatJSON:
+		handler = slog.NewJSONHandler(writer, opts)
+	case LogFormatText:
+		handler = slog.NewTextHandler(writer, opts)
+	default:
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, nil, fmt.Errorf("unsupported log format %q (expected %q or %q)", config.LogFormat, LogFormatJSON, LogFormatText)
 	}
-	handler := slog.NewJSONHandler(writer, opts)
 	logger := slog.New(handler)
 
 	return logger, closer, nil
 }
 
-// GenerateLogFileName generates a log file name with timestamp in the logs directory
-func GenerateLogFileName(commandName string) string {
+// GenerateLogFileName generates a log file name with timestamp in the given logs directory.
+// The extension follows the log format so text logs are not mistaken for JSON.
+func GenerateLogFileName(logDir string, commandName string, logFormat string) string {
+	if logDir == "" {
+		logDir = "logs"
+	}
+	ext := "json"
+	if logFormat == LogFormatText {
+		ext = "log"
+	}
 	timestamp := time.Now().Format("20060102-150405")
-	return filepath.Join("logs", fmt.Sprintf("%s-%s.json", commandName, timestamp))
+	return filepath.Join(logDir, fmt.Sprintf("%s-%s.%s", commandName, timestamp, ext))
+}
+
+// ParseLogLevel converts a level name (debug, info, warn, error) into a slog.Level
+func ParseLogLevel(level string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.TrimSpace(level))); err != nil {
+		return slog.LevelInfo, fmt.Errorf("invalid log level %q (expected debug, info, warn or error)", level)
+	}
+	return l, nil
+}
+
+// IsTerminal reports whether the file is attached to an interactive terminal
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
 }
@@ -0,0 +1,89 @@
+package util
+
+import (
+	"encoding/json"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// RedactedPlaceholder replaces any secret removed from logs and reports
+const RedactedPlaceholder = "[REDACTED]"
+
+// secretPatterns match credentials that may appear inside free-form text such as URLs,
+// error messages returned by GitHub, or logged request/response bodies.
+var secretPatterns = []*regexp.Regexp{
+	// PEM blocks (private keys, certificates), including JSON-escaped newlines
+	regexp.MustCompile(`-----BEGIN [A-Z0-9 ]+-----[\s\S]*?-----END [A-Z0-9 ]+-----`),
+	// Private keys that were cut off before their END line (e.g. truncated bodies)
+	regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----[\s\S]*`),
+	// GitHub tokens: installation (ghs_), personal (ghp_), OAuth (gho_), user-to-server (ghu_), refresh (ghr_)
+	regexp.MustCompile(`\bgh[psour]_[A-Za-z0-9]{20,}\b`),
+	// Fine-grained personal access tokens
+	regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{20,}\b`),
+	// JWTs (header.payload.signature, base64url encoded)
+	regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+`),
+}
+
+// authorizationHeader matches the credentials of an Authorization header, as sent, logged from a header map
+// or serialized as JSON; prose that merely mentions a token is left alone
+var authorizationHeader = regexp.MustCompile(`(?i)(\bauthorization["']?\s*[:=]\s*[\["']*\s*(?:bearer|token|basic)\s+)[A-Za-z0-9_\-\.=+/]+`)
+
+// secretQueryParam matches credentials passed as URL query parameters
+var secretQueryParam = regexp.MustCompile(`(?i)([?&](?:access_token|token|client_secret|private_key)=)[^&\s"]+`)
+
+// sensitiveKeys are log attribute keys whose values are always redacted regardless of content
+var sensitiveKeys = map[string]bool{
+	"authorization": true,
+	"token":         true,
+	"access_token":  true,
+	"private_key":   true,
+	"privatekey":    true,
+	"jwt":           true,
+	"password":      true,
+	"client_secret": true,
+}
+
+// Redact scrubs Authorization header values, GitHub tokens, JWTs and PEM blocks from s
+func Redact(s string) string {
+	if s == "" {
+		return s
+	}
+	for _, pattern := range secretPatterns {
+		s = pattern.ReplaceAllString(s, RedactedPlaceholder)
+	}
+	s = authorizationHeader.ReplaceAllString(s, "${1}"+RedactedPlaceholder)
+	return secretQueryParam.ReplaceAllString(s, "${1}"+RedactedPlaceholder)
+}
+
+// RedactAttr is a slog ReplaceAttr function that scrubs secrets from every log attribute,
+// including the message, error values and structured payloads.
+func RedactAttr(groups []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, RedactedPlaceholder)
+	}
+
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(a.Value.String()))
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case error:
+			return slog.String(a.Key, Redact(v.Error()))
+		case []byte:
+			return slog.String(a.Key, Redact(string(v)))
+		default:
+			// Structured values are encoded and scanned, but only rewritten when they actually
+			// contain a secret, so ordinary values keep their shape in the logs.
+			data, err := json.Marshal(v)
+			if err != nil {
+				return a
+			}
+			if redacted := Redact(string(data)); redacted != string(data) {
+				return slog.String(a.Key, redacted)
+			}
+		}
+	}
+
+	return a
+}
@@ -0,0 +1,140 @@
+package util
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// RepoSource is where the content of a lab repository comes from
+type RepoSource string
+
+const (
+	// SourceTemplate generates the repository from a template repository on GitHub
+	SourceTemplate RepoSource = "template"
+	// SourceBundle imports a commit of a local git bundle
+	SourceBundle RepoSource = "bundle"
+	// SourceTarball imports the files of a local tar archive, optionally gzipped
+	SourceTarball RepoSource = "tarball"
+)
+
+// RepoSnapshot is the content of a repository imported from a local bundle or tarball, or of a pinned template commit
+type RepoSnapshot struct {
+	Files []SourceFile
+	// Commit and Tree are the bundle commit imported and its tree; tarballs have neither
+	Commit string
+	Tree   string
+}
+
+// SourceFile is a file of an imported repository
+type SourceFile struct {
+	Path string
+	// Mode is the git file mode: 100644, 100755, 120000 for symlinks or 160000 for submodules
+	Mode    string
+	Content []byte
+	// SHA is the commit of a submodule, which has no content
+	SHA string
+}
+
+// BlobCount is the number of distinct blobs the files need, each uploaded once per repository
+func (s *RepoSnapshot) BlobCount() int {
+	blobs := map[string]bool{}
+	for _, file := range s.Files {
+		if file.Mode != "160000" {
+			blobs[BlobSHA(file.Content)] = true
+		}
+	}
+	return len(blobs)
+}
+
+// LoadRepoSnapshot reads the content of a repository with a bundle or tarball source
+func LoadRepoSnapshot(repo RepoConfig) (*RepoSnapshot, error) {
+	switch repo.Source {
+	case SourceBundle:
+		return ReadBundle(repo.Path, repo.Ref)
+	case SourceTarball:
+		return ReadTarball(repo.Path)
+	default:
+		return nil, fmt.Errorf("%s is not imported from a local file", repo.SourceName())
+	}
+}
+
+// ReadTarball reads the files of a tar archive, which may be gzipped. A single top-level directory,
+// like the one of GitHub's source archives, is stripped from the paths.
+func ReadTarball(file string) (*RepoSnapshot, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var reader io.Reader = bufio.NewReader(f)
+	if magic, _ := reader.(*bufio.Reader).Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	snapshot := &RepoSnapshot{}
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "./"))
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("%s: %s is outside the archive", file, header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeReg:
+			content, err := io.ReadAll(archive)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			mode := "100644"
+			if header.Mode&0o111 != 0 {
+				mode = "100755"
+			}
+			snapshot.Files = append(snapshot.Files, SourceFile{Path: name, Mode: mode, Content: content})
+		case tar.TypeSymlink:
+			snapshot.Files = append(snapshot.Files, SourceFile{Path: name, Mode: "120000", Content: []byte(header.Linkname)})
+		}
+	}
+	if len(snapshot.Files) == 0 {
+		return nil, fmt.Errorf("%s: archive has no files", file)
+	}
+
+	stripTopLevelDir(snapshot.Files)
+	return snapshot, nil
+}
+
+// stripTopLevelDir removes the directory every file is in, if there is one
+func stripTopLevelDir(files []SourceFile) {
+	dir, _, ok := strings.Cut(files[0].Path, "/")
+	if !ok {
+		return
+	}
+	for _, file := range files {
+		if !strings.HasPrefix(file.Path, dir+"/") {
+			return
+		}
+	}
+	for i := range files {
+		files[i].Path = strings.TrimPrefix(files[i].Path, dir+"/")
+	}
+}
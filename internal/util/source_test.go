@@ -0,0 +1,162 @@
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type tarEntry struct {
+	name     string
+	content  string
+	mode     int64
+	typeflag byte
+	linkname string
+}
+
+// writeTarball writes the entries to a tar archive, gzipped if asked
+func writeTarball(t *testing.T, entries []tarEntry, gzipped bool) string {
+	t.Helper()
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: entry.mode, Typeflag: entry.typeflag, Linkname: entry.linkname}
+		if header.Typeflag == 0 {
+			header.Typeflag = tar.TypeReg
+		}
+		if header.Mode == 0 {
+			header.Mode = 0o644
+		}
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(entry.content))
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := archive.Bytes()
+	name := "demo.tar"
+	if gzipped {
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		gz.Write(data)
+		gz.Close()
+		data = compressed.Bytes()
+		name = "demo.tar.gz"
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadTarball(t *testing.T) {
+	// GitHub's source archives put every file in a directory named after the repository and commit
+	entries := []tarEntry{
+		{name: "demo-abc123/", typeflag: tar.TypeDir, mode: 0o755},
+		{name: "demo-abc123/README.md", content: "# Demo\n"},
+		{name: "demo-abc123/scripts/run.sh", content: "#!/bin/sh\n", mode: 0o755},
+		{name: "demo-abc123/app.py", typeflag: tar.TypeSymlink, linkname: "src/app.py"},
+		{name: "./demo-abc123/src/app.py", content: "print('hello')\n"},
+	}
+	want := []string{
+		"100644 README.md # Demo\n",
+		"100755 scripts/run.sh #!/bin/sh\n",
+		"120000 app.py src/app.py",
+		"100644 src/app.py print('hello')\n",
+	}
+
+	for _, gzipped := range []bool{false, true} {
+		snapshot, err := ReadTarball(writeTarball(t, entries, gzipped))
+		if err != nil {
+			t.Fatalf("ReadTarball (gzipped %t): %v", gzipped, err)
+		}
+		var got []string
+		for _, file := range snapshot.Files {
+			got = append(got, file.Mode+" "+file.Path+" "+string(file.Content))
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("files (gzipped %t) = %q, want %q", gzipped, got, want)
+		}
+		if snapshot.Commit != "" || snapshot.Tree != "" {
+			t.Errorf("tarball has commit %q and tree %q", snapshot.Commit, snapshot.Tree)
+		}
+	}
+}
+
+func TestReadTarballErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		wantErr string
+	}{
+		{"parent directory", []tarEntry{{name: "../evil.sh", content: "x"}}, "outside the archive"},
+		{"nested parent directory", []tarEntry{{name: "demo/../../evil.sh", content: "x"}}, "outside the archive"},
+		{"absolute path", []tarEntry{{name: "/etc/passwd", content: "x"}}, "outside the archive"},
+		{"no files", []tarEntry{{name: "demo/", typeflag: tar.TypeDir, mode: 0o755}}, "archive has no files"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadTarball(writeTarball(t, tt.entries, false))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStripTopLevelDir(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		want  []string
+	}{
+		{"single directory", []string{"demo/a", "demo/b/c"}, []string{"a", "b/c"}},
+		{"files at the top level", []string{"README.md", "src/app.py"}, []string{"README.md", "src/app.py"}},
+		{"several directories", []string{"a/x", "b/y"}, []string{"a/x", "b/y"}},
+		{"directory name prefix", []string{"demo/a", "demo-extra/b"}, []string{"demo/a", "demo-extra/b"}},
+		{"only one level is stripped", []string{"demo/src/a", "demo/src/b"}, []string{"src/a", "src/b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := make([]SourceFile, len(tt.paths))
+			for i, path := range tt.paths {
+				files[i] = SourceFile{Path: path}
+			}
+			stripTopLevelDir(files)
+
+			var got []string
+			for _, file := range files {
+				got = append(got, file.Path)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("paths = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepoSnapshotBlobCount(t *testing.T) {
+	snapshot := &RepoSnapshot{Files: []SourceFile{
+		{Path: "a.txt", Mode: "100644", Content: []byte("same")},
+		{Path: "b.txt", Mode: "100644", Content: []byte("same")},
+		{Path: "c.txt", Mode: "100755", Content: []byte("other")},
+		{Path: "vendor/lib", Mode: "160000", SHA: "1111111111111111111111111111111111111111"},
+	}}
+	if got := snapshot.BlobCount(); got != 2 {
+		t.Errorf("BlobCount() = %d, want 2", got)
+	}
+}
@@ -1,11 +1,17 @@
 package util
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+
+	"gopkg.in/yaml.v3"
 )
 
 func LoadFromFile(path string) ([]string, error) {
@@ -37,3 +43,225 @@ func LoadFromFile(path string) ([]string, error) {
 		return nil, fmt.Errorf("unsupported file extension: %s", ext)
 	}
 }
+
+// UserRole is the part a person plays in a lab
+type UserRole string
+
+const (
+	// RoleStudent gets a lab organization with the template repositories
+	RoleStudent UserRole = "student"
+	// RoleFacilitator runs the lab and is an owner of every lab organization
+	RoleFacilitator UserRole = "facilitator"
+	// RoleObserver follows the lab without getting an organization of their own
+	RoleObserver UserRole = "observer"
+)
+
+// LabUser is one person listed in a users file, with the metadata carried into provisioning and reports
+type LabUser struct {
+	Login string `json:"login,omitempty" yaml:"login,omitempty"`
+	// ID is the optional GitHub account ID, used to detect accounts that were renamed
+	ID          int64  `json:"id,omitempty" yaml:"id,omitempty"`
+	Email       string `json:"email,omitempty" yaml:"email,omitempty"`
+	DisplayName string `json:"display_name,omitempty" yaml:"display_name,omitempty"`
+	// GivenName, FamilyName and UserName are used to provision Enterprise Managed Users through SCIM;
+	// UserName defaults to the email address
+	GivenName  string   `json:"given_name,omitempty" yaml:"given_name,omitempty"`
+	FamilyName string   `json:"family_name,omitempty" yaml:"family_name,omitempty"`
+	UserName   string   `json:"username,omitempty" yaml:"username,omitempty"`
+	Team       string   `json:"team,omitempty" yaml:"team,omitempty"`
+	Role       UserRole `json:"role,omitempty" yaml:"role,omitempty"`
+	// Attributes holds custom columns, e.g. a cohort or customer name, copied into reports
+	Attributes map[string]string `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+}
+
+// Name returns the display name of the user, or the login when there is none
+func (u LabUser) Name() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	if name := strings.TrimSpace(u.GivenName + " " + u.FamilyName); name != "" {
+		return name
+	}
+	return u.Login
+}
+
+// identity names the user in errors: the login, or the email before a login is known
+func (u LabUser) identity() string {
+	if u.Login != "" {
+		return u.Login
+	}
+	return u.Email
+}
+
+// usersDocument is the layout of JSON and YAML users files; a bare list of users is also accepted
+type usersDocument struct {
+	Users []LabUser `json:"users" yaml:"users"`
+}
+
+// LoadUsersFromFile reads the people of a lab from a users file:
+//   - .txt: comma-separated logins, or email addresses for Enterprise Managed Users
+//   - .csv: a header row naming the columns login, id, email, display_name, given_name, family_name,
+//     username, team and role; any other column becomes a custom attribute
+//   - .json, .yaml, .yml: a list of users, optionally under a "users" key
+//
+// Users without a role are students. Every user needs a login or an email address.
+func LoadUsersFromFile(path string) ([]LabUser, error) {
+	var users []LabUser
+	var err error
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".txt":
+		var entries []string
+		entries, err = LoadFromFile(path)
+		for _, entry := range entries {
+			if strings.Contains(entry, "@") {
+				users = append(users, LabUser{Email: entry})
+			} else {
+				users = append(users, LabUser{Login: entry})
+			}
+		}
+	case ".csv":
+		users, err = loadUsersFromCSV(path)
+	case ".json":
+		users, err = loadUsersDocument(path, json.Unmarshal)
+	case ".yaml", ".yml":
+		users, err = loadUsersDocument(path, yaml.Unmarshal)
+	default:
+		return nil, fmt.Errorf("unsupported users file extension: %s (expected .txt, .csv, .json, .yaml or .yml)", ext)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for i := range users {
+		user := &users[i]
+		user.Login = strings.TrimSpace(user.Login)
+		user.Email = strings.TrimSpace(user.Email)
+		if user.Login == "" && user.Email == "" {
+			return nil, fmt.Errorf("users file %s: user %d needs a login or an email", path, i+1)
+		}
+		if user.Email != "" && !strings.Contains(user.Email, "@") {
+			return nil, fmt.Errorf("users file %s: %q is not an email address", path, user.Email)
+		}
+		if user.UserName == "" {
+			user.UserName = user.Email
+		}
+
+		role, err := ParseUserRole(string(user.Role))
+		if err != nil {
+			return nil, fmt.Errorf("users file %s: user %s: %w", path, user.identity(), err)
+		}
+		user.Role = role
+
+		key := strings.ToLower(user.identity())
+		if seen[key] {
+			return nil, fmt.Errorf("users file %s: %s is listed more than once", path, user.identity())
+		}
+		seen[key] = true
+	}
+
+	return users, nil
+}
+
+// ParseUserRole validates a role name; an empty role is a student
+func ParseUserRole(role string) (UserRole, error) {
+	switch UserRole(strings.ToLower(strings.TrimSpace(role))) {
+	case "", RoleStudent:
+		return RoleStudent, nil
+	case RoleFacilitator:
+		return RoleFacilitator, nil
+	case RoleObserver:
+		return RoleObserver, nil
+	default:
+		return "", fmt.Errorf("unknown role %q (expected %s, %s or %s)", role, RoleStudent, RoleFacilitator, RoleObserver)
+	}
+}
+
+func loadUsersDocument(path string, unmarshal func([]byte, any) error) ([]LabUser, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []LabUser
+	if err := unmarshal(data, &users); err == nil {
+		return users, nil
+	}
+	var document usersDocument
+	if err := unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse users file %s: %w", path, err)
+	}
+	return document.Users, nil
+}
+
+func loadUsersFromCSV(path string) ([]LabUser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse users file %s: %w", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("users file %s is empty", path)
+	}
+
+	header := make([]string, len(rows[0]))
+	for i, name := range rows[0] {
+		header[i] = strings.ToLower(strings.TrimSpace(name))
+	}
+	if !slices.Contains(header, "login") && !slices.Contains(header, "email") {
+		return nil, fmt.Errorf("users file %s: header row needs a login or email column", path)
+	}
+
+	users := make([]LabUser, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		var user LabUser
+		for i, value := range row {
+			value = strings.TrimSpace(value)
+			switch header[i] {
+			case "login":
+				user.Login = value
+			case "id":
+				if value == "" {
+					continue
+				}
+				id, err := strconv.ParseInt(value, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("users file %s: %q is not a GitHub account ID", path, value)
+				}
+				user.ID = id
+			case "email":
+				user.Email = value
+			case "display_name":
+				user.DisplayName = value
+			case "given_name":
+				user.GivenName = value
+			case "family_name":
+				user.FamilyName = value
+			case "username":
+				user.UserName = value
+			case "team":
+				user.Team = value
+			case "role":
+				user.Role = UserRole(value)
+			default:
+				if value == "" {
+					continue
+				}
+				if user.Attributes == nil {
+					user.Attributes = map[string]string{}
+				}
+				user.Attributes[header[i]] = value
+			}
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
@@ -0,0 +1,145 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadUsersFromFile(t *testing.T) {
+	// Every format below describes the same three people
+	want := []LabUser{
+		{Login: "alice", ID: 42, Email: "alice@example.com", UserName: "alice@example.com", DisplayName: "Alice Liddell", Team: "red", Role: RoleStudent, Attributes: map[string]string{"cohort": "2025-q3"}},
+		{Login: "bob", Role: RoleFacilitator},
+		{Email: "carol@example.com", UserName: "carol_octo", GivenName: "Carol", FamilyName: "Danvers", Role: RoleObserver},
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"csv", "users.csv", `login, id, email, display_name, given_name, family_name, username, team, role, Cohort
+alice, 42, alice@example.com, Alice Liddell, , , , red, , 2025-q3
+bob, , , , , , , , Facilitator,
+, , carol@example.com, , Carol, Danvers, carol_octo, , observer,
+`},
+		{"json list", "users.json", `[
+  {"login": "alice", "id": 42, "email": "alice@example.com", "display_name": "Alice Liddell", "team": "red", "attributes": {"cohort": "2025-q3"}},
+  {"login": "bob", "role": "facilitator"},
+  {"email": "carol@example.com", "given_name": "Carol", "family_name": "Danvers", "username": "carol_octo", "role": "observer"}
+]`},
+		{"json document", "users.json", `{"users": [
+  {"login": " alice ", "id": 42, "email": "alice@example.com", "display_name": "Alice Liddell", "team": "red", "role": "student", "attributes": {"cohort": "2025-q3"}},
+  {"login": "bob", "role": "FACILITATOR"},
+  {"email": "carol@example.com", "given_name": "Carol", "family_name": "Danvers", "username": "carol_octo", "role": "observer"}
+]}`},
+		{"yaml document", "users.yaml", `users:
+  - login: alice
+    id: 42
+    email: alice@example.com
+    display_name: Alice Liddell
+    team: red
+    attributes:
+      cohort: 2025-q3
+  - login: bob
+    role: facilitator
+  - email: carol@example.com
+    given_name: Carol
+    family_name: Danvers
+    username: carol_octo
+    role: observer
+`},
+		{"yml list", "users.yml", `- {login: alice, id: 42, email: alice@example.com, display_name: Alice Liddell, team: red, attributes: {cohort: 2025-q3}}
+- {login: bob, role: facilitator}
+- {email: carol@example.com, given_name: Carol, family_name: Danvers, username: carol_octo, role: observer}
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadUsersFromFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got  %+v\nwant %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadUsersFromTextFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.txt")
+	if err := os.WriteFile(path, []byte("alice, bob,\n carol@example.com,\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadUsersFromFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LabUser{
+		{Login: "alice", Role: RoleStudent},
+		{Login: "bob", Role: RoleStudent},
+		{Email: "carol@example.com", UserName: "carol@example.com", Role: RoleStudent},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestLoadUsersFromFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr string
+	}{
+		{"unsupported extension", "users.xlsx", "", "unsupported users file extension"},
+		{"empty csv", "users.csv", "", "is empty"},
+		{"csv without identity column", "users.csv", "name,team\nAlice,red\n", "needs a login or email column"},
+		{"csv with invalid id", "users.csv", "login,id\nalice,abc\n", "is not a GitHub account ID"},
+		{"csv with ragged rows", "users.csv", "login,team\nalice\n", "failed to parse users file"},
+		{"user without identity", "users.json", `[{"team": "red"}]`, "user 1 needs a login or an email"},
+		{"invalid email", "users.json", `[{"email": "alice.example.com"}]`, "is not an email address"},
+		{"unknown role", "users.yaml", "- login: alice\n  role: owner\n", `unknown role "owner"`},
+		{"duplicate login", "users.csv", "login\nalice\nAlice\n", "Alice is listed more than once"},
+		{"duplicate email", "users.txt", "carol@example.com, CAROL@example.com", "listed more than once"},
+		{"invalid json", "users.json", `{"users": [`, "failed to parse users file"},
+		{"invalid yaml", "users.yml", "users: [alice", "failed to parse users file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadUsersFromFile(path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLabUserName(t *testing.T) {
+	tests := []struct {
+		user LabUser
+		want string
+	}{
+		{LabUser{Login: "alice", DisplayName: "Alice Liddell", GivenName: "A"}, "Alice Liddell"},
+		{LabUser{Login: "carol", GivenName: "Carol", FamilyName: "Danvers"}, "Carol Danvers"},
+		{LabUser{Login: "bob"}, "bob"},
+	}
+	for _, tt := range tests {
+		if got := tt.user.Name(); got != tt.want {
+			t.Errorf("Name() of %+v = %q, want %q", tt.user, got, tt.want)
+		}
+	}
+}